@@ -0,0 +1,304 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"node.town/db"
+	"node.town/ogg"
+	"node.town/snd"
+)
+
+// Archiver rolls the packets of completed sessions into one Ogg file per
+// stream, that is per session, channel and SSRC. A stream is completed
+// once its session holds no live voice connection to its channel,
+// because the bot left or its process ended, and no packet has arrived
+// for it within db.VoiceConnectionStaleAfter.
+type Archiver struct {
+	Pool    *pgxpool.Pool
+	Queries *db.Queries
+	Store   Store
+}
+
+// Result summarizes an archive run.
+type Result struct {
+	Streams int
+	Packets int64
+	Bytes   int64
+}
+
+// ArchiveCompleted archives every completed session's packets, or only
+// those of one session if sessionID is nonzero.
+func (a *Archiver) ArchiveCompleted(
+	ctx context.Context,
+	sessionID int32,
+) (Result, error) {
+	var result Result
+
+	streams, err := a.Queries.ListCompletedSessionStreams(
+		ctx,
+		db.ListCompletedSessionStreamsParams{
+			LiveSince: pgtype.Timestamptz{
+				Time:  time.Now().Add(-db.VoiceConnectionStaleAfter),
+				Valid: true,
+			},
+			SessionID: pgtype.Int4{Int32: sessionID, Valid: sessionID != 0},
+		},
+	)
+	if err != nil {
+		return result, fmt.Errorf("failed to list completed sessions: %w", err)
+	}
+
+	for _, stream := range streams {
+		size, err := a.archiveStream(ctx, stream)
+		if err != nil {
+			return result, fmt.Errorf(
+				"failed to archive session %d ssrc %d: %w",
+				stream.SessionID,
+				stream.Ssrc,
+				err,
+			)
+		}
+		result.Streams++
+		result.Packets += stream.PacketCount
+		result.Bytes += size
+	}
+
+	return result, nil
+}
+
+func (a *Archiver) archiveStream(
+	ctx context.Context,
+	stream db.ListCompletedSessionStreamsRow,
+) (int64, error) {
+	packets, err := a.Queries.GetSessionStreamPackets(
+		ctx,
+		db.GetSessionStreamPacketsParams{
+			SessionID: stream.SessionID,
+			GuildID:   stream.GuildID,
+			ChannelID: stream.ChannelID,
+			Ssrc:      stream.Ssrc,
+			EndTime:   stream.EndTime,
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get packets: %w", err)
+	}
+	if len(packets) == 0 {
+		return 0, nil
+	}
+
+	data, err := EncodeOgg(packets)
+	if err != nil {
+		return 0, err
+	}
+
+	startTime := packets[0].CreatedAt.Time
+	key := fmt.Sprintf(
+		"%s/%s/%d/%d-%d.ogg",
+		stream.GuildID,
+		stream.ChannelID,
+		stream.SessionID,
+		stream.Ssrc,
+		startTime.Unix(),
+	)
+	location, err := a.Store.Put(ctx, key, data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Record the archive and delete the packets together, so that the
+	// audio is always reachable one way or the other.
+	tx, err := a.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	queries := a.Queries.WithTx(tx)
+	_, err = queries.InsertOpusArchive(ctx, db.InsertOpusArchiveParams{
+		SessionID:   stream.SessionID,
+		GuildID:     stream.GuildID,
+		ChannelID:   stream.ChannelID,
+		Ssrc:        stream.Ssrc,
+		StartTime:   packets[0].CreatedAt,
+		EndTime:     packets[len(packets)-1].CreatedAt,
+		PacketCount: int64(len(packets)),
+		SizeBytes:   int64(len(data)),
+		Location:    location,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert archive: %w", err)
+	}
+
+	deleted, err := queries.DeleteSessionStreamPackets(
+		ctx,
+		db.DeleteSessionStreamPacketsParams{
+			SessionID: stream.SessionID,
+			GuildID:   stream.GuildID,
+			ChannelID: stream.ChannelID,
+			Ssrc:      stream.Ssrc,
+			EndTime:   stream.EndTime,
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived packets: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit archive: %w", err)
+	}
+
+	log.Info(
+		"Archived stream",
+		"session", stream.SessionID,
+		"ssrc", stream.Ssrc,
+		"packets", deleted,
+		"location", location,
+	)
+
+	return int64(len(data)), nil
+}
+
+// EncodeOgg writes packets of one SSRC into an Ogg container, filling
+// gaps with silence so that every packet sits 20 ms after the previous
+// one, starting at the first packet.
+func EncodeOgg(packets []db.OpusPacket) ([]byte, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no packets to encode")
+	}
+
+	var buf bytes.Buffer
+	oggWriter, err := snd.NewOggWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OGG writer: %w", err)
+	}
+
+	ogg, err := snd.NewOgg(
+		packets[0].Ssrc,
+		packets[0].CreatedAt.Time,
+		packets[len(packets)-1].CreatedAt.Time,
+		oggWriter,
+		&snd.RealTimeProvider{},
+		log.Default(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OGG: %w", err)
+	}
+
+	for _, dbPacket := range packets {
+		err := ogg.WritePacket(snd.OpusPacket{
+			ID:        int(dbPacket.ID),
+			Sequence:  uint16(dbPacket.Sequence),
+			Timestamp: uint32(dbPacket.Timestamp),
+			CreatedAt: dbPacket.CreatedAt.Time,
+			OpusData:  dbPacket.OpusData,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+
+	if err := ogg.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close OGG: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ReadClip returns the packets of an archive that fall between two
+// times, as if they had been read from opus_packets. Since EncodeOgg
+// lays packets out every 20 ms from the archive's start, their times
+// are reconstructed from their position in the file.
+func ReadClip(
+	archive db.OpusArchive,
+	data []byte,
+	from, to time.Time,
+) ([]db.OpusPacket, error) {
+	reader, err := ogg.NewReaderWith(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var packets []db.OpusPacket
+	for i := 0; ; i++ {
+		payload, err := reader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		at := archive.StartTime.Time.Add(time.Duration(i) * snd.OpusFrameDuration)
+		if at.Before(from) {
+			continue
+		}
+		if at.After(to) {
+			break
+		}
+
+		packets = append(packets, db.OpusPacket{
+			GuildID:   archive.GuildID,
+			ChannelID: archive.ChannelID,
+			Ssrc:      archive.Ssrc,
+			Sequence:  int32(i),
+			OpusData:  payload,
+			CreatedAt: pgtype.Timestamptz{Time: at, Valid: true},
+			SessionID: archive.SessionID,
+		})
+	}
+
+	return packets, nil
+}
+
+// LoadClip fetches the archived packets of an SSRC in a voice channel
+// between two times.
+func LoadClip(
+	ctx context.Context,
+	queries *db.Queries,
+	guildID, channelID string,
+	ssrc int64,
+	from, to time.Time,
+) ([]db.OpusPacket, error) {
+	archives, err := queries.GetOpusArchivesForTimeRange(
+		ctx,
+		db.GetOpusArchivesForTimeRangeParams{
+			GuildID:   guildID,
+			ChannelID: channelID,
+			Ssrc:      ssrc,
+			StartTime: pgtype.Timestamptz{Time: from, Valid: true},
+			EndTime:   pgtype.Timestamptz{Time: to, Valid: true},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archives: %w", err)
+	}
+
+	var packets []db.OpusPacket
+	for _, archive := range archives {
+		data, err := Fetch(ctx, archive.Location)
+		if err != nil {
+			return nil, err
+		}
+		clip, err := ReadClip(archive, data, from, to)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, clip...)
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].CreatedAt.Time.Before(packets[j].CreatedAt.Time)
+	})
+
+	return packets, nil
+}
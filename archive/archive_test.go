@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	location, err := store.Put(ctx, "guild/channel/1/42-0.ogg", []byte("audio"))
+	if err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if !strings.HasPrefix(location, "file://") {
+		t.Errorf("Expected a file:// location, got %s", location)
+	}
+
+	data, err := Fetch(ctx, location)
+	if err != nil {
+		t.Fatalf("Failed to fetch: %v", err)
+	}
+	if string(data) != "audio" {
+		t.Errorf("Expected:\n%s\nGot:\n%s", "audio", data)
+	}
+}
+
+// fakeS3 is a minimal stand-in for an S3-compatible server.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		r.Header.Get("x-amz-date") == "" {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if sha256Hex(body) != r.Header.Get("x-amz-content-sha256") {
+			http.Error(w, "bad content hash", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := &S3Store{
+		Endpoint:  server.URL,
+		Bucket:    "recordings",
+		Prefix:    "jamie",
+		AccessKey: "key",
+		SecretKey: "secret",
+	}
+
+	location, err := store.Put(ctx, "guild/1/42-0.ogg", []byte("audio"))
+	if err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if location != "s3://recordings/jamie/guild/1/42-0.ogg" {
+		t.Errorf("Unexpected location: %s", location)
+	}
+	if _, ok := fake.objects["/recordings/jamie/guild/1/42-0.ogg"]; !ok {
+		t.Errorf("Object not stored at path-style key: %v", fake.objects)
+	}
+
+	data, err := store.Get(ctx, location)
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if string(data) != "audio" {
+		t.Errorf("Expected:\n%s\nGot:\n%s", "audio", data)
+	}
+
+	if _, err := store.Get(ctx, "s3://recordings/missing.ogg"); err == nil {
+		t.Error("Expected an error for a missing object")
+	}
+}
+
+func TestEncodeAndReadClip(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Ten packets, then a 100 ms gap, then ten more
+	var packets []db.OpusPacket
+	for i := 0; i < 20; i++ {
+		at := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i >= 10 {
+			at = at.Add(100 * time.Millisecond)
+		}
+		packets = append(packets, db.OpusPacket{
+			Ssrc:      42,
+			Sequence:  int32(i),
+			OpusData:  []byte{0xF8, byte(i)},
+			CreatedAt: pgtype.Timestamptz{Time: at, Valid: true},
+		})
+	}
+
+	data, err := EncodeOgg(packets)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	archive := db.OpusArchive{
+		Ssrc:      42,
+		StartTime: pgtype.Timestamptz{Time: start, Valid: true},
+	}
+
+	clip, err := ReadClip(
+		archive,
+		data,
+		start.Add(300*time.Millisecond),
+		start.Add(400*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to read clip: %v", err)
+	}
+
+	// 300 ms in is packet 10 after the five silent frames of the gap
+	if len(clip) != 6 {
+		t.Fatalf("Expected 6 packets, got %d", len(clip))
+	}
+	if !bytes.Equal(clip[0].OpusData, []byte{0xF8, 10}) {
+		t.Errorf("Expected packet 10 first, got %v", clip[0].OpusData)
+	}
+	if !clip[0].CreatedAt.Time.Equal(start.Add(300 * time.Millisecond)) {
+		t.Errorf("Unexpected packet time: %s", clip[0].CreatedAt.Time)
+	}
+}
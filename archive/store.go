@@ -0,0 +1,121 @@
+// Package archive moves old voice recordings out of opus_packets into
+// Ogg files kept in a local directory or an S3-compatible bucket.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Store keeps archived Ogg files. Put returns a location URI that is
+// recorded in opus_archives and later handed back to Get.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) (string, error)
+	Get(ctx context.Context, location string) ([]byte, error)
+}
+
+// OpenStore opens the store for a base URL: a directory path or
+// file:///dir URL, or s3://bucket/prefix. S3 stores are configured with
+// S3_ENDPOINT, S3_REGION, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func OpenStore(base string) (Store, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL %q: %w", base, err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := u.Path
+		if u.Scheme == "" {
+			dir = base
+		}
+		return NewLocalStore(dir)
+	case "s3":
+		return &S3Store{
+			Endpoint:  viper.GetString("S3_ENDPOINT"),
+			Region:    viper.GetString("S3_REGION"),
+			Bucket:    u.Host,
+			Prefix:    strings.Trim(u.Path, "/"),
+			AccessKey: viper.GetString("S3_ACCESS_KEY_ID"),
+			SecretKey: viper.GetString("S3_SECRET_ACCESS_KEY"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive URL scheme %q", u.Scheme)
+	}
+}
+
+// Fetch reads an archived file from wherever its location points,
+// regardless of the currently configured store.
+func Fetch(ctx context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive location %q: %w", location, err)
+	}
+
+	var base string
+	switch u.Scheme {
+	case "file":
+		base = "file://" + filepath.Dir(u.Path)
+	case "s3":
+		base = "s3://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported archive location %q", location)
+	}
+
+	store, err := OpenStore(base)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(ctx, location)
+}
+
+// LocalStore keeps archives as files under a directory.
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve archive directory: %w", err)
+	}
+	return &LocalStore{Dir: abs}, nil
+}
+
+func (s *LocalStore) Put(_ context.Context, key string, data []byte) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a
+	// truncated archive behind.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func (s *LocalStore) Get(_ context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "file" {
+		return nil, fmt.Errorf("not a local archive location: %s", location)
+	}
+
+	data, err := os.ReadFile(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return data, nil
+}
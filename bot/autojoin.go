@@ -0,0 +1,524 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+// VoicePolicy describes when Jamie joins or leaves voice channels in a
+// guild without being summoned.
+type VoicePolicy struct {
+	AlwaysChannelID string        // Always stay in this channel
+	FollowUserIDs   []string      // Join whichever channel these users are in
+	MinMembers      int           // Join any channel with at least this many members
+	LeaveAfter      time.Duration // Leave after the channel has been empty this long
+}
+
+type VoiceAction string
+
+const (
+	VoiceActionNone  VoiceAction = ""
+	VoiceActionJoin  VoiceAction = "join"
+	VoiceActionLeave VoiceAction = "leave"
+)
+
+// VoiceDecision is the outcome of evaluating a VoicePolicy.
+type VoiceDecision struct {
+	Action    VoiceAction
+	ChannelID string
+	Reason    string
+}
+
+// Decide returns what the bot should do given the members of each voice
+// channel (excluding the bot itself), the channel the bot is currently
+// in, and how long that channel has been empty.
+func (p VoicePolicy) Decide(
+	occupancy map[string][]string,
+	currentChannelID string,
+	emptyFor time.Duration,
+) VoiceDecision {
+	target, reason := p.target(occupancy, currentChannelID)
+	if target != "" {
+		if target == currentChannelID {
+			return VoiceDecision{}
+		}
+		return VoiceDecision{
+			Action:    VoiceActionJoin,
+			ChannelID: target,
+			Reason:    reason,
+		}
+	}
+
+	if currentChannelID == "" || currentChannelID == p.AlwaysChannelID {
+		return VoiceDecision{}
+	}
+	if len(occupancy[currentChannelID]) > 0 || p.LeaveAfter <= 0 {
+		return VoiceDecision{}
+	}
+	if emptyFor >= p.LeaveAfter {
+		return VoiceDecision{
+			Action:    VoiceActionLeave,
+			ChannelID: currentChannelID,
+			Reason:    fmt.Sprintf("channel empty for %s", p.LeaveAfter),
+		}
+	}
+
+	return VoiceDecision{}
+}
+
+func (p VoicePolicy) target(
+	occupancy map[string][]string,
+	currentChannelID string,
+) (string, string) {
+	if p.AlwaysChannelID != "" {
+		return p.AlwaysChannelID, "always join this channel"
+	}
+
+	for _, userID := range p.FollowUserIDs {
+		for channelID, members := range occupancy {
+			if slices.Contains(members, userID) {
+				return channelID, fmt.Sprintf("following <@%s>", userID)
+			}
+		}
+	}
+
+	if p.MinMembers <= 0 || len(occupancy[currentChannelID]) > 0 {
+		return "", ""
+	}
+
+	channelIDs := make([]string, 0, len(occupancy))
+	for channelID := range occupancy {
+		channelIDs = append(channelIDs, channelID)
+	}
+	sort.Strings(channelIDs)
+
+	best := ""
+	for _, channelID := range channelIDs {
+		count := len(occupancy[channelID])
+		if count >= p.MinMembers && count > len(occupancy[best]) {
+			best = channelID
+		}
+	}
+	if best == "" {
+		return "", ""
+	}
+
+	return best, fmt.Sprintf("%d members present", len(occupancy[best]))
+}
+
+// Enabled reports whether the policy would ever act on its own.
+func (p VoicePolicy) Enabled() bool {
+	return p.AlwaysChannelID != "" ||
+		len(p.FollowUserIDs) > 0 ||
+		p.MinMembers > 0 ||
+		p.LeaveAfter > 0
+}
+
+func (p VoicePolicy) String() string {
+	if !p.Enabled() {
+		return "disabled"
+	}
+
+	var parts []string
+	if p.AlwaysChannelID != "" {
+		parts = append(parts, fmt.Sprintf("always <#%s>", p.AlwaysChannelID))
+	}
+	if len(p.FollowUserIDs) > 0 {
+		users := make([]string, len(p.FollowUserIDs))
+		for i, userID := range p.FollowUserIDs {
+			users[i] = fmt.Sprintf("<@%s>", userID)
+		}
+		parts = append(parts, "following "+strings.Join(users, ", "))
+	}
+	if p.MinMembers > 0 {
+		parts = append(parts, fmt.Sprintf("min members %d", p.MinMembers))
+	}
+	if p.LeaveAfter > 0 {
+		parts = append(parts, fmt.Sprintf("leave after %s empty", p.LeaveAfter))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func voicePolicyFromRow(row db.GuildVoicePolicy) VoicePolicy {
+	return VoicePolicy{
+		AlwaysChannelID: row.AlwaysChannelID.String,
+		FollowUserIDs:   row.FollowUserIds,
+		MinMembers:      int(row.MinMembers),
+		LeaveAfter:      time.Duration(row.LeaveAfterMinutes) * time.Minute,
+	}
+}
+
+func (b *Bot) loadVoicePolicy(
+	ctx context.Context,
+	guildID string,
+) (VoicePolicy, error) {
+	row, err := b.Queries.GetGuildVoicePolicy(ctx, guildID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return VoicePolicy{}, nil
+	}
+	if err != nil {
+		return VoicePolicy{}, err
+	}
+	return voicePolicyFromRow(row), nil
+}
+
+func (b *Bot) saveVoicePolicy(
+	ctx context.Context,
+	guildID string,
+	policy VoicePolicy,
+) error {
+	followUserIDs := policy.FollowUserIDs
+	if followUserIDs == nil {
+		followUserIDs = []string{}
+	}
+
+	return b.Queries.UpsertGuildVoicePolicy(
+		ctx,
+		db.UpsertGuildVoicePolicyParams{
+			GuildID: guildID,
+			AlwaysChannelID: pgtype.Text{
+				String: policy.AlwaysChannelID,
+				Valid:  policy.AlwaysChannelID != "",
+			},
+			FollowUserIds:     followUserIDs,
+			MinMembers:        int32(policy.MinMembers),
+			LeaveAfterMinutes: int32(policy.LeaveAfter / time.Minute),
+		},
+	)
+}
+
+// voiceOccupancy maps each voice channel in the guild to the human
+// members currently in it, according to the gateway state cache.
+func voiceOccupancy(
+	s *discordgo.Session,
+	guildID string,
+) (map[string][]string, error) {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	occupancy := make(map[string][]string)
+	for _, voice := range guild.VoiceStates {
+		if voice.ChannelID == "" || voice.UserID == s.State.User.ID {
+			continue
+		}
+		if voice.Member != nil && voice.Member.User != nil &&
+			voice.Member.User.Bot {
+			continue
+		}
+		occupancy[voice.ChannelID] = append(
+			occupancy[voice.ChannelID],
+			voice.UserID,
+		)
+	}
+
+	return occupancy, nil
+}
+
+func currentVoiceChannel(s *discordgo.Session, guildID string) string {
+	vc := voiceConnection(s, guildID)
+	if vc == nil {
+		return ""
+	}
+	return voiceChannelID(vc)
+}
+
+// voicePolicyLock is held while evaluating a guild's voice policy, so
+// that timers and event handlers evaluating at once can't both act.
+func (b *Bot) voicePolicyLock(guildID string) *sync.Mutex {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.voicePolicyLocks == nil {
+		b.voicePolicyLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := b.voicePolicyLocks[guildID]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.voicePolicyLocks[guildID] = lock
+	}
+	return lock
+}
+
+// evaluateVoicePolicy applies the guild's auto-join policy to the
+// current voice state and joins, moves or leaves accordingly. Only
+// actions that succeed are recorded as decisions.
+func (b *Bot) evaluateVoicePolicy(s *discordgo.Session, guildID string) {
+	lock := b.voicePolicyLock(guildID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx := context.Background()
+
+	policy, err := b.loadVoicePolicy(ctx, guildID)
+	if err != nil {
+		log.Error("Failed to load voice policy", "guild", guildID, "error", err)
+		return
+	}
+	if !policy.Enabled() {
+		return
+	}
+
+	occupancy, err := voiceOccupancy(s, guildID)
+	if err != nil {
+		log.Error("Failed to read voice states", "guild", guildID, "error", err)
+		return
+	}
+
+	currentChannelID := currentVoiceChannel(s, guildID)
+	emptyFor := b.trackEmptyChannel(
+		guildID,
+		currentChannelID != "" && len(occupancy[currentChannelID]) == 0,
+	)
+
+	decision := policy.Decide(occupancy, currentChannelID, emptyFor)
+	switch decision.Action {
+	case VoiceActionJoin:
+		if err := b.joinVoiceChannel(s, guildID, decision.ChannelID); err != nil {
+			log.Error(
+				"Failed to auto-join voice channel",
+				"guild", guildID,
+				"channel", decision.ChannelID,
+				"error", err,
+			)
+			return
+		}
+		b.recordVoiceDecision(ctx, guildID, decision)
+		b.trackEmptyChannel(guildID, len(occupancy[decision.ChannelID]) == 0)
+
+	case VoiceActionLeave:
+		if err := b.leaveVoiceChannel(s, guildID); err != nil {
+			log.Error(
+				"Failed to leave voice channel",
+				"guild", guildID,
+				"error", err,
+			)
+			return
+		}
+		b.recordVoiceDecision(ctx, guildID, decision)
+		b.trackEmptyChannel(guildID, false)
+
+	default:
+		// The bot never leaves the channel it always stays in, so there
+		// is nothing to check again for
+		if currentChannelID == "" || currentChannelID == policy.AlwaysChannelID ||
+			len(occupancy[currentChannelID]) > 0 || policy.LeaveAfter <= 0 {
+			return
+		}
+		if delay := policy.LeaveAfter - emptyFor; delay > 0 {
+			b.scheduleVoicePolicyCheck(s, guildID, delay)
+		}
+	}
+}
+
+// trackEmptyChannel records when the bot's channel in a guild became
+// empty and returns how long it has been empty.
+func (b *Bot) trackEmptyChannel(guildID string, empty bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.emptySince == nil {
+		b.emptySince = make(map[string]time.Time)
+	}
+
+	if !empty {
+		delete(b.emptySince, guildID)
+		if timer, ok := b.voiceTimers[guildID]; ok {
+			timer.Stop()
+			delete(b.voiceTimers, guildID)
+		}
+		return 0
+	}
+
+	since, ok := b.emptySince[guildID]
+	if !ok {
+		since = time.Now()
+		b.emptySince[guildID] = since
+	}
+	return time.Since(since)
+}
+
+func (b *Bot) scheduleVoicePolicyCheck(
+	s *discordgo.Session,
+	guildID string,
+	delay time.Duration,
+) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.voiceTimers == nil {
+		b.voiceTimers = make(map[string]*time.Timer)
+	}
+	if timer, ok := b.voiceTimers[guildID]; ok {
+		timer.Stop()
+	}
+
+	b.voiceTimers[guildID] = time.AfterFunc(delay, func() {
+		b.evaluateVoicePolicy(s, guildID)
+	})
+}
+
+func (b *Bot) recordVoiceDecision(
+	ctx context.Context,
+	guildID string,
+	decision VoiceDecision,
+) {
+	log.Info(
+		"voice policy",
+		"guild", guildID,
+		"action", decision.Action,
+		"channel", decision.ChannelID,
+		"reason", decision.Reason,
+	)
+
+	err := b.Queries.InsertBotVoiceDecision(
+		ctx,
+		db.InsertBotVoiceDecisionParams{
+			GuildID: guildID,
+			ChannelID: pgtype.Text{
+				String: decision.ChannelID,
+				Valid:  decision.ChannelID != "",
+			},
+			Action:    string(decision.Action),
+			Reason:    decision.Reason,
+			SessionID: pgtype.Int4{Int32: b.SessionID, Valid: true},
+		},
+	)
+	if err != nil {
+		log.Error("Failed to insert voice decision", "error", err)
+	}
+}
+
+// applyAutoJoinOptions updates a policy from the options of the
+// `/jamie autojoin` subcommand.
+func applyAutoJoinOptions(
+	policy VoicePolicy,
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) VoicePolicy {
+	for _, option := range options {
+		switch option.Name {
+		case "channel":
+			policy.AlwaysChannelID = option.ChannelValue(nil).ID
+		case "follow":
+			userID := option.UserValue(nil).ID
+			if !slices.Contains(policy.FollowUserIDs, userID) {
+				policy.FollowUserIDs = append(policy.FollowUserIDs, userID)
+			}
+		case "unfollow":
+			userID := option.UserValue(nil).ID
+			policy.FollowUserIDs = slices.DeleteFunc(
+				slices.Clone(policy.FollowUserIDs),
+				func(id string) bool { return id == userID },
+			)
+		case "min_members":
+			policy.MinMembers = int(option.IntValue())
+		case "leave_after":
+			policy.LeaveAfter = time.Duration(option.IntValue()) * time.Minute
+		case "reset":
+			if option.BoolValue() {
+				policy = VoicePolicy{}
+			}
+		}
+	}
+	return policy
+}
+
+func (b *Bot) handleAutoJoinCommand(
+	s *discordgo.Session,
+	m *discordgo.InteractionCreate,
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) string {
+	ctx := context.Background()
+
+	policy, err := b.loadVoicePolicy(ctx, m.GuildID)
+	if err != nil {
+		log.Error("Failed to load voice policy", "error", err)
+		return "Failed to load the auto-join policy."
+	}
+
+	policy = applyAutoJoinOptions(policy, options)
+	if err := b.saveVoicePolicy(ctx, m.GuildID, policy); err != nil {
+		log.Error("Failed to save voice policy", "error", err)
+		return "Failed to save the auto-join policy."
+	}
+
+	go b.evaluateVoicePolicy(s, m.GuildID)
+
+	return fmt.Sprintf("Auto-join policy: %s", policy)
+}
+
+func (b *Bot) handleStatusCommand(
+	s *discordgo.Session,
+	m *discordgo.InteractionCreate,
+) string {
+	ctx := context.Background()
+
+	var status strings.Builder
+
+	if conn, ok := b.Connections.Get(m.GuildID); ok {
+		fmt.Fprintf(
+			&status,
+			"Voice: %s in <#%s> since <t:%d:R>, %d packets recorded\n",
+			conn.State,
+			conn.ChannelID,
+			conn.StateSince.Unix(),
+			conn.PacketCount,
+		)
+	} else {
+		status.WriteString("Not in a voice channel\n")
+	}
+
+	policy, err := b.loadVoicePolicy(ctx, m.GuildID)
+	if err != nil {
+		log.Error("Failed to load voice policy", "error", err)
+		status.WriteString("Auto-join: unknown\n")
+	} else {
+		fmt.Fprintf(&status, "Auto-join: %s\n", policy)
+	}
+
+	decisions, err := b.Queries.GetRecentBotVoiceDecisions(
+		ctx,
+		db.GetRecentBotVoiceDecisionsParams{
+			GuildID: m.GuildID,
+			Limit:   5,
+		},
+	)
+	if err != nil {
+		log.Error("Failed to load voice decisions", "error", err)
+		return status.String()
+	}
+
+	if len(decisions) > 0 {
+		status.WriteString("Recent decisions:\n")
+	}
+	for _, decision := range decisions {
+		fmt.Fprintf(
+			&status,
+			"- <t:%d:R> %s",
+			decision.CreatedAt.Time.Unix(),
+			decision.Action,
+		)
+		if decision.ChannelID.Valid {
+			fmt.Fprintf(&status, " <#%s>", decision.ChannelID.String)
+		}
+		fmt.Fprintf(&status, " (%s)\n", decision.Reason)
+	}
+
+	return status.String()
+}
@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVoicePolicyDecide(t *testing.T) {
+	occupancy := map[string][]string{
+		"general": {"alice", "bob"},
+		"music":   {"carol", "dave", "erin"},
+	}
+
+	tests := []struct {
+		name      string
+		policy    VoicePolicy
+		occupancy map[string][]string
+		current   string
+		emptyFor  time.Duration
+		expected  VoiceDecision
+	}{
+		{
+			name:      "Disabled Policy",
+			policy:    VoicePolicy{},
+			occupancy: occupancy,
+			expected:  VoiceDecision{},
+		},
+		{
+			name:      "Always Join Channel",
+			policy:    VoicePolicy{AlwaysChannelID: "meeting"},
+			occupancy: occupancy,
+			expected: VoiceDecision{
+				Action:    VoiceActionJoin,
+				ChannelID: "meeting",
+				Reason:    "always join this channel",
+			},
+		},
+		{
+			name:      "Already In Always Channel",
+			policy:    VoicePolicy{AlwaysChannelID: "meeting"},
+			occupancy: occupancy,
+			current:   "meeting",
+			expected:  VoiceDecision{},
+		},
+		{
+			name:      "Follow User",
+			policy:    VoicePolicy{FollowUserIDs: []string{"zed", "bob"}},
+			occupancy: occupancy,
+			expected: VoiceDecision{
+				Action:    VoiceActionJoin,
+				ChannelID: "general",
+				Reason:    "following <@bob>",
+			},
+		},
+		{
+			name:      "Min Members Picks Fullest Channel",
+			policy:    VoicePolicy{MinMembers: 2},
+			occupancy: occupancy,
+			expected: VoiceDecision{
+				Action:    VoiceActionJoin,
+				ChannelID: "music",
+				Reason:    "3 members present",
+			},
+		},
+		{
+			name:      "Min Members Not Reached",
+			policy:    VoicePolicy{MinMembers: 4},
+			occupancy: occupancy,
+			expected:  VoiceDecision{},
+		},
+		{
+			name:      "Min Members Stays In Occupied Channel",
+			policy:    VoicePolicy{MinMembers: 2},
+			occupancy: occupancy,
+			current:   "general",
+			expected:  VoiceDecision{},
+		},
+		{
+			name:      "Leave Empty Channel",
+			policy:    VoicePolicy{MinMembers: 4, LeaveAfter: 5 * time.Minute},
+			occupancy: occupancy,
+			current:   "empty",
+			emptyFor:  6 * time.Minute,
+			expected: VoiceDecision{
+				Action:    VoiceActionLeave,
+				ChannelID: "empty",
+				Reason:    "channel empty for 5m0s",
+			},
+		},
+		{
+			name:      "Wait Before Leaving",
+			policy:    VoicePolicy{LeaveAfter: 5 * time.Minute},
+			occupancy: occupancy,
+			current:   "empty",
+			emptyFor:  time.Minute,
+			expected:  VoiceDecision{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.policy.Decide(tt.occupancy, tt.current, tt.emptyFor)
+			if result != tt.expected {
+				t.Errorf(
+					"Decide() returned incorrect result.\nExpected: %+v\nGot: %+v",
+					tt.expected,
+					result,
+				)
+			}
+		})
+	}
+}
@@ -3,19 +3,99 @@ package bot
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/charmbracelet/log"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/spf13/viper"
 	"node.town/db"
 )
 
 type Bot struct {
-	Discord   *discordgo.Session
-	Queries   *db.Queries
-	SessionID int32
+	Discord     *discordgo.Session
+	Queries     *db.Queries
+	SessionID   int32
+	Connections *ConnectionManager
+
+	mu               sync.Mutex
+	emptySince       map[string]time.Time
+	voiceTimers      map[string]*time.Timer
+	voicePolicyLocks map[string]*sync.Mutex
+	gatewayDownSince time.Time
+}
+
+var jamieCommandOptions = []*discordgo.ApplicationCommandOption{
+	{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        "join",
+		Description: "Summon Jamie to this channel",
+	},
+	{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        "status",
+		Description: "Show where Jamie is listening and why",
+	},
+	{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        "autojoin",
+		Description: "Configure when Jamie joins voice channels by itself",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionChannel,
+				Name:        "channel",
+				Description: "Always stay in this voice channel",
+				ChannelTypes: []discordgo.ChannelType{
+					discordgo.ChannelTypeGuildVoice,
+					discordgo.ChannelTypeGuildStageVoice,
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "follow",
+				Description: "Join whichever channel this user is in",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "unfollow",
+				Description: "Stop following this user",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "min_members",
+				Description: "Join any channel with at least this many members (0 to disable)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "leave_after",
+				Description: "Leave after this many minutes in an empty channel (0 to stay)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "reset",
+				Description: "Clear the auto-join policy",
+			},
+		},
+	},
+	{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        "replay",
+		Description: "Play back recent conversation in this voice channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "last",
+				Description: "How much to replay, e.g. 30s or 2m (default 30s)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "ago",
+				Description: "End the replay this long ago, e.g. 5m (default now)",
+			},
+		},
+	},
 }
 
 func (b *Bot) HandleEvent(_ *discordgo.Session, m *discordgo.Event) {
@@ -39,26 +119,28 @@ func (b *Bot) HandleGuildCreate(
 		m.ID,
 		&discordgo.ApplicationCommand{
 			Name:        "jamie",
-			Description: "Summon Jamie to this channel",
+			Description: "Talk to Jamie",
+			Options:     jamieCommandOptions,
 		},
 	)
 	if err != nil {
 		log.Error("command", "error", err)
+	} else {
+		log.Info("app command", "id", cmd.ID)
 	}
-	log.Info("app command", "id", cmd.ID)
 
 	// Check if we should join a voice channel in this guild
 	channelID, err := b.Queries.GetLastJoinedChannel(
 		context.Background(),
 		db.GetLastJoinedChannelParams{
-			GuildID:  m.ID,
-			BotToken: viper.GetString("DISCORD_TOKEN"),
+			GuildID: m.ID,
+			UserID:  s.State.User.ID,
 		},
 	)
 
 	if err == nil && channelID != "" {
 		// We have a record of joining a channel in this guild, so let's join it
-		vc, err := s.ChannelVoiceJoin(m.ID, channelID, false, false)
+		err := b.joinVoiceChannel(s, m.ID, channelID)
 		if err != nil {
 			log.Error(
 				"Failed to join voice channel",
@@ -71,18 +153,18 @@ func (b *Bot) HandleGuildCreate(
 			)
 		} else {
 			log.Info("Rejoined voice channel", "guild", m.ID, "channel", channelID)
-			vc.AddHandler(b.HandleVoiceSpeakingUpdate)
-			go b.HandleOpusPackets(vc)
 		}
 	} else if errors.Is(err, pgx.ErrNoRows) {
 		log.Info("No bot voice joins found for guild", "guild", m.ID)
 	} else {
 		log.Error("Failed to query bot voice joins", "error", err)
 	}
+
+	b.evaluateVoicePolicy(s, m.ID)
 }
 
 func (b *Bot) HandleVoiceStateUpdate(
-	_ *discordgo.Session,
+	s *discordgo.Session,
 	m *discordgo.VoiceStateUpdate,
 ) {
 	log.Info("voice", "user", m.UserID, "channel", m.ChannelID)
@@ -107,6 +189,10 @@ func (b *Bot) HandleVoiceStateUpdate(
 	if err != nil {
 		log.Error("Failed to insert voice state event", "error", err)
 	}
+
+	if m.UserID != s.State.User.ID {
+		b.evaluateVoicePolicy(s, m.GuildID)
+	}
 }
 
 func (b *Bot) HandleVoiceServerUpdate(
@@ -120,34 +206,120 @@ func (b *Bot) HandleInteractionCreate(
 	s *discordgo.Session,
 	m *discordgo.InteractionCreate,
 ) {
+	if m.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	data := m.ApplicationCommandData()
+	subcommand := "join"
+	var options []*discordgo.ApplicationCommandInteractionDataOption
+	if len(data.Options) > 0 {
+		subcommand = data.Options[0].Name
+		options = data.Options[0].Options
+	}
+
+	var content string
+	switch subcommand {
+	case "status":
+		content = b.handleStatusCommand(s, m)
+	case "autojoin":
+		content = b.handleAutoJoinCommand(s, m, options)
+	case "replay":
+		content = b.handleReplayCommand(s, m, options)
+	default:
+		content = b.handleJoinCommand(s, m)
+	}
+
 	err := s.InteractionRespond(
 		m.Interaction,
 		&discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponsePong,
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
 		},
 	)
-
 	if err != nil {
 		log.Error("couldn't send response", "err", err)
-		return
+	}
+}
+
+func (b *Bot) handleJoinCommand(
+	s *discordgo.Session,
+	m *discordgo.InteractionCreate,
+) string {
+	if m.GuildID == "" {
+		return "I can only join voice channels in a server."
+	}
+
+	channelID := invokingVoiceChannel(s, m)
+	if channelID == "" {
+		return "Join a voice channel first, then summon me again."
 	}
 
-	vc, err := s.ChannelVoiceJoin(m.GuildID, m.ChannelID, false, false)
+	err := b.joinVoiceChannel(s, m.GuildID, channelID)
 	if err != nil {
 		log.Error("voice", "error", err)
-		return
+		return "Failed to join the voice channel."
+	}
+
+	return fmt.Sprintf("Listening in <#%s>", channelID)
+}
+
+// invokingVoiceChannel returns the voice channel the user who ran a
+// command is in, falling back to the channel the command was run in if
+// that is a voice channel's text chat.
+func invokingVoiceChannel(
+	s *discordgo.Session,
+	m *discordgo.InteractionCreate,
+) string {
+	var userID string
+	if m.Member != nil && m.Member.User != nil {
+		userID = m.Member.User.ID
+	} else if m.User != nil {
+		userID = m.User.ID
+	}
+
+	voice, err := s.State.VoiceState(m.GuildID, userID)
+	if err == nil && voice.ChannelID != "" {
+		return voice.ChannelID
+	}
+
+	channel, err := s.State.Channel(m.ChannelID)
+	if err == nil && (channel.Type == discordgo.ChannelTypeGuildVoice ||
+		channel.Type == discordgo.ChannelTypeGuildStageVoice) {
+		return channel.ID
 	}
 
-	vc.AddHandler(b.HandleVoiceSpeakingUpdate)
+	return ""
+}
+
+// joinVoiceChannel joins or moves to a voice channel, starts recording
+// and remembers the channel so it can be rejoined after a restart.
+func (b *Bot) joinVoiceChannel(
+	s *discordgo.Session,
+	guildID, channelID string,
+) error {
+	if vc := voiceConnection(s, guildID); vc != nil {
+		if err := vc.ChangeChannel(channelID, false, false); err != nil {
+			return err
+		}
+	} else {
+		_, err := s.ChannelVoiceJoin(guildID, channelID, false, false)
+		if err != nil {
+			return err
+		}
+	}
 
-	go b.HandleOpusPackets(vc)
+	b.superviseVoice(s, guildID, channelID)
 
 	// Save or update the channel join information
-	err = b.Queries.UpsertBotVoiceJoin(
+	err := b.Queries.UpsertBotVoiceJoin(
 		context.Background(),
 		db.UpsertBotVoiceJoinParams{
-			GuildID:   m.GuildID,
-			ChannelID: m.ChannelID,
+			GuildID:   guildID,
+			ChannelID: channelID,
 			SessionID: pgtype.Int4{
 				Int32: b.SessionID,
 				Valid: true,
@@ -157,6 +329,43 @@ func (b *Bot) HandleInteractionCreate(
 	if err != nil {
 		log.Error("Failed to upsert bot voice join", "error", err)
 	}
+
+	return nil
+}
+
+// leaveVoiceChannel stops recording and leaves the guild's voice
+// channel, forgetting it so that it isn't rejoined after a restart.
+func (b *Bot) leaveVoiceChannel(s *discordgo.Session, guildID string) error {
+	b.Connections.remove(guildID)
+
+	err := b.Queries.DeleteBotVoiceJoins(
+		context.Background(),
+		db.DeleteBotVoiceJoinsParams{
+			GuildID: guildID,
+			UserID:  s.State.User.ID,
+		},
+	)
+	if err != nil {
+		log.Error("Failed to delete bot voice joins", "error", err)
+	}
+
+	err = b.Queries.DeleteBotVoiceConnection(
+		context.Background(),
+		db.DeleteBotVoiceConnectionParams{
+			GuildID:   guildID,
+			SessionID: b.SessionID,
+		},
+	)
+	if err != nil {
+		log.Error("Failed to delete voice connection", "error", err)
+	}
+
+	vc := voiceConnection(s, guildID)
+	if vc == nil {
+		return nil
+	}
+
+	return vc.Disconnect()
 }
 
 func (b *Bot) HandleVoiceSpeakingUpdate(
@@ -178,23 +387,44 @@ func (b *Bot) HandleVoiceSpeakingUpdate(
 	}
 }
 
-func (b *Bot) HandleOpusPackets(vc *discordgo.VoiceConnection) {
-	for pkt := range vc.OpusRecv {
-		err := b.Queries.InsertOpusPacket(
-			context.Background(),
-			db.InsertOpusPacketParams{
-				GuildID:   vc.GuildID,
-				ChannelID: vc.ChannelID,
-				Ssrc:      int64(pkt.SSRC),
-				Sequence:  int32(pkt.Sequence),
-				Timestamp: int64(pkt.Timestamp),
-				OpusData:  pkt.Opus,
-				SessionID: b.SessionID,
-			},
-		)
-
-		if err != nil {
-			log.Error("Failed to insert opus packet", "error", err)
+func (b *Bot) HandleOpusPackets(
+	ctx context.Context,
+	vc *discordgo.VoiceConnection,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pkt, ok := <-vc.OpusRecv:
+			if !ok {
+				return
+			}
+			b.insertOpusPacket(vc, pkt)
 		}
 	}
 }
+
+func (b *Bot) insertOpusPacket(
+	vc *discordgo.VoiceConnection,
+	pkt *discordgo.Packet,
+) {
+	err := b.Queries.InsertOpusPacket(
+		context.Background(),
+		db.InsertOpusPacketParams{
+			GuildID:   vc.GuildID,
+			ChannelID: vc.ChannelID,
+			Ssrc:      int64(pkt.SSRC),
+			Sequence:  int32(pkt.Sequence),
+			Timestamp: int64(pkt.Timestamp),
+			OpusData:  pkt.Opus,
+			SessionID: b.SessionID,
+		},
+	)
+
+	if err != nil {
+		log.Error("Failed to insert opus packet", "error", err)
+		return
+	}
+
+	b.Connections.notePacket(vc.GuildID, time.Now())
+}
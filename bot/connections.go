@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ConnectionState string
+
+const (
+	ConnectionJoining      ConnectionState = "joining"
+	ConnectionRecording    ConnectionState = "recording"
+	ConnectionReconnecting ConnectionState = "reconnecting"
+)
+
+// ConnectionInfo is a snapshot of Jamie's voice connection in one guild.
+type ConnectionInfo struct {
+	GuildID      string
+	ChannelID    string
+	State        ConnectionState
+	StateSince   time.Time // When the connection entered its current state
+	JoinedAt     time.Time
+	PacketCount  int64
+	LastPacketAt time.Time
+}
+
+// ConnectionManager tracks the voice connection Jamie holds in each
+// guild, together with the supervisor goroutine keeping it alive.
+type ConnectionManager struct {
+	mu          sync.Mutex
+	connections map[string]*connection
+}
+
+type connection struct {
+	info         ConnectionInfo
+	wake         chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stopPlayback context.CancelFunc
+}
+
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		connections: make(map[string]*connection),
+	}
+}
+
+// add registers a connection for the guild unless one already exists,
+// returning the new connection's context and whether it was added.
+func (m *ConnectionManager) add(
+	guildID, channelID string,
+) (context.Context, *connection, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.connections[guildID]; ok {
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &connection{
+		info: ConnectionInfo{
+			GuildID:    guildID,
+			ChannelID:  channelID,
+			State:      ConnectionJoining,
+			StateSince: now,
+			JoinedAt:   now,
+		},
+		wake:   make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	m.connections[guildID] = conn
+
+	return ctx, conn, true
+}
+
+// remove stops the guild's supervisor and forgets the connection.
+func (m *ConnectionManager) remove(guildID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.connections[guildID]; ok {
+		conn.cancel()
+		delete(m.connections, guildID)
+	}
+}
+
+// removeAll stops every supervisor and forgets every connection.
+func (m *ConnectionManager) removeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for guildID, conn := range m.connections {
+		conn.cancel()
+		delete(m.connections, guildID)
+	}
+}
+
+func (m *ConnectionManager) setState(
+	guildID string,
+	state ConnectionState,
+	channelID string,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, ok := m.connections[guildID]
+	if !ok {
+		return
+	}
+	if channelID != "" {
+		conn.info.ChannelID = channelID
+	}
+	if conn.info.State != state {
+		conn.info.State = state
+		conn.info.StateSince = time.Now()
+	}
+}
+
+// startPlayback stops any playback running in the guild and returns a
+// context for a new one, which is also canceled when the connection is
+// removed.
+func (m *ConnectionManager) startPlayback(
+	guildID string,
+) (context.Context, context.CancelFunc, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, ok := m.connections[guildID]
+	if !ok {
+		return nil, nil, false
+	}
+	if conn.stopPlayback != nil {
+		conn.stopPlayback()
+	}
+
+	ctx, cancel := context.WithCancel(conn.ctx)
+	conn.stopPlayback = cancel
+
+	return ctx, cancel, true
+}
+
+func (m *ConnectionManager) notePacket(guildID string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.connections[guildID]; ok {
+		conn.info.PacketCount++
+		conn.info.LastPacketAt = at
+	}
+}
+
+func (m *ConnectionManager) wakeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, conn := range m.connections {
+		select {
+		case conn.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Get returns the connection in a guild, if any.
+func (m *ConnectionManager) Get(guildID string) (ConnectionInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, ok := m.connections[guildID]
+	if !ok {
+		return ConnectionInfo{}, false
+	}
+	return conn.info, true
+}
+
+// List returns all connections ordered by guild ID.
+func (m *ConnectionManager) List() []ConnectionInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(m.connections))
+	for _, conn := range m.connections {
+		infos = append(infos, conn.info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GuildID < infos[j].GuildID
+	})
+
+	return infos
+}
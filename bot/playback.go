@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"node.town/ogg"
+	"node.town/snd"
+)
+
+// Discord asks senders to follow speech with a few frames of silence so
+// that clients don't interpolate the last frame.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+const trailingSilenceFrames = 5
+
+// OpusSource yields 20 ms Opus frames for playback. NextFrame returns
+// io.EOF when the source is exhausted.
+type OpusSource interface {
+	NextFrame() ([]byte, error)
+	Close() error
+}
+
+// OggOpusSource plays the Opus packets of an Ogg container.
+type OggOpusSource struct {
+	reader *ogg.OggReader
+	close  func() error
+}
+
+func NewOggOpusSource(r io.ReadCloser) (*OggOpusSource, error) {
+	reader, err := ogg.NewReaderWith(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Ogg reader: %w", err)
+	}
+	return &OggOpusSource{reader: reader, close: r.Close}, nil
+}
+
+// OpenOggOpusFile plays an Ogg/Opus file from disk.
+func OpenOggOpusFile(path string) (*OggOpusSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Ogg file: %w", err)
+	}
+	return NewOggOpusSource(file)
+}
+
+// NewPCMSource encodes signed 16-bit little-endian 48 kHz stereo PCM to
+// Opus with ffmpeg and plays the result.
+func NewPCMSource(ctx context.Context, pcm io.Reader) (*OggOpusSource, error) {
+	return encodeWithFFmpeg(
+		ctx,
+		pcm,
+		"-f", "s16le",
+		"-ar", strconv.Itoa(snd.SampleRate),
+		"-ac", strconv.Itoa(snd.Channels),
+		"-i", "pipe:0",
+	)
+}
+
+// MixOggOpusFiles mixes several Ogg/Opus files, such as one per speaker,
+// into a single stream with ffmpeg and plays the result.
+func MixOggOpusFiles(ctx context.Context, paths []string) (*OggOpusSource, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("nothing to mix")
+	}
+
+	var args []string
+	for _, path := range paths {
+		args = append(args, "-i", path)
+	}
+	args = append(
+		args,
+		"-filter_complex",
+		fmt.Sprintf("amix=inputs=%d:duration=longest", len(paths)),
+	)
+
+	return encodeWithFFmpeg(ctx, nil, args...)
+}
+
+func (o *OggOpusSource) NextFrame() ([]byte, error) {
+	return o.reader.ReadPacket()
+}
+
+func (o *OggOpusSource) Close() error {
+	return o.close()
+}
+
+// encodeWithFFmpeg runs ffmpeg with the given input arguments and reads
+// its output as 20 ms Opus frames in an Ogg container.
+func encodeWithFFmpeg(
+	ctx context.Context,
+	stdin io.Reader,
+	inputArgs ...string,
+) (*OggOpusSource, error) {
+	args := append([]string{"-loglevel", "error"}, inputArgs...)
+	args = append(
+		args,
+		"-c:a", "libopus",
+		"-b:a", "96k",
+		"-frame_duration", "20",
+		"-ar", strconv.Itoa(snd.SampleRate),
+		"-ac", strconv.Itoa(snd.Channels),
+		"-f", "ogg",
+		"pipe:1",
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ffmpeg output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	reader, err := ogg.NewReaderWith(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Ogg reader: %w", err)
+	}
+
+	return &OggOpusSource{
+		reader: reader,
+		close: func() error {
+			stdout.Close()
+			if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+				return fmt.Errorf("ffmpeg error: %w: %s", err, stderr.String())
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Play streams a source into a voice connection at one frame every
+// 20 ms, marking Jamie as speaking for the duration. Frames are
+// scheduled against the start time rather than the previous send, so
+// slow reads don't accumulate drift.
+func Play(
+	ctx context.Context,
+	vc *discordgo.VoiceConnection,
+	source OpusSource,
+) error {
+	if err := vc.Speaking(true); err != nil {
+		return fmt.Errorf("failed to set speaking state: %w", err)
+	}
+	defer vc.Speaking(false)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	next := time.Now()
+	send := func(frame []byte) error {
+		timer.Reset(time.Until(next))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case vc.OpusSend <- frame:
+		}
+
+		next = next.Add(snd.OpusFrameDuration)
+		// If we fell far behind, skip ahead instead of bursting.
+		if behind := time.Since(next); behind > 5*snd.OpusFrameDuration {
+			next = time.Now()
+		}
+		return nil
+	}
+
+	<-timer.C
+	for {
+		frame, err := source.NextFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read frame: %w", err)
+		}
+		if err := send(frame); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < trailingSilenceFrames; i++ {
+		if err := send(silenceFrame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+	"node.town/snd"
+)
+
+const (
+	defaultReplayLength = 30 * time.Second
+	maxReplayLength     = 10 * time.Minute
+)
+
+// replayRange parses the replay options into the time range to play,
+// which ends `ago` before now and lasts `last`.
+func replayRange(
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+	now time.Time,
+) (time.Time, time.Time, error) {
+	length := defaultReplayLength
+	var ago time.Duration
+
+	for _, option := range options {
+		d, err := time.ParseDuration(option.StringValue())
+		if err != nil || d < 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf(
+				"%s should be a duration like 30s or 2m",
+				option.Name,
+			)
+		}
+		switch option.Name {
+		case "last":
+			length = d
+		case "ago":
+			ago = d
+		}
+	}
+
+	if length == 0 || length > maxReplayLength {
+		return time.Time{}, time.Time{}, fmt.Errorf(
+			"replays can be at most %s long",
+			maxReplayLength,
+		)
+	}
+
+	end := now.Add(-ago)
+	return end.Add(-length), end, nil
+}
+
+func (b *Bot) handleReplayCommand(
+	s *discordgo.Session,
+	m *discordgo.InteractionCreate,
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) string {
+	conn, ok := b.Connections.Get(m.GuildID)
+	if !ok {
+		return "I'm not in a voice channel."
+	}
+
+	startTime, endTime, err := replayRange(options, time.Now())
+	if err != nil {
+		return fmt.Sprintf("Can't replay that: %s.", err)
+	}
+
+	ctx, cancel, ok := b.Connections.startPlayback(m.GuildID)
+	if !ok {
+		return "I'm not in a voice channel."
+	}
+
+	go func() {
+		defer cancel()
+		err := b.replay(ctx, s, m.GuildID, conn.ChannelID, startTime, endTime)
+		if err != nil && ctx.Err() == nil {
+			log.Error(
+				"Failed to replay audio",
+				"guild", m.GuildID,
+				"channel", conn.ChannelID,
+				"error", err,
+			)
+		}
+	}()
+
+	return fmt.Sprintf(
+		"Replaying <#%s> from <t:%d:T> to <t:%d:T>",
+		conn.ChannelID,
+		startTime.Unix(),
+		endTime.Unix(),
+	)
+}
+
+// replay plays back what was recorded in a channel between two times,
+// with every speaker mixed together.
+func (b *Bot) replay(
+	ctx context.Context,
+	s *discordgo.Session,
+	guildID, channelID string,
+	startTime, endTime time.Time,
+) error {
+	packets, err := b.Queries.GetOpusPacketsForChannel(
+		ctx,
+		db.GetOpusPacketsForChannelParams{
+			GuildID:   guildID,
+			ChannelID: channelID,
+			StartTime: pgtype.Timestamptz{Time: startTime, Valid: true},
+			EndTime:   pgtype.Timestamptz{Time: endTime, Valid: true},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get opus packets: %w", err)
+	}
+	if len(packets) == 0 {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "jamie-replay-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := writeSpeakerOggFiles(dir, packets, startTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	var source OpusSource
+	if len(paths) == 1 {
+		source, err = OpenOggOpusFile(paths[0])
+	} else {
+		source, err = MixOggOpusFiles(ctx, paths)
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	vc := voiceConnection(s, guildID)
+	if vc == nil || !voiceReady(vc) {
+		return fmt.Errorf("voice connection not ready")
+	}
+
+	return Play(ctx, vc, source)
+}
+
+// writeSpeakerOggFiles writes one Ogg file per SSRC, each padded with
+// silence from startTime so that they line up when mixed. Packets must
+// be ordered by SSRC and then time.
+func writeSpeakerOggFiles(
+	dir string,
+	packets []db.OpusPacket,
+	startTime, endTime time.Time,
+) ([]string, error) {
+	var (
+		paths   []string
+		current *snd.Ogg
+		ssrc    int64
+	)
+
+	closeCurrent := func() error {
+		if current == nil {
+			return nil
+		}
+		err := current.Close()
+		current = nil
+		return err
+	}
+	defer closeCurrent()
+
+	for _, packet := range packets {
+		if current == nil || packet.Ssrc != ssrc {
+			if err := closeCurrent(); err != nil {
+				return nil, fmt.Errorf("failed to close Ogg: %w", err)
+			}
+
+			ssrc = packet.Ssrc
+			path := filepath.Join(dir, fmt.Sprintf("%d.ogg", ssrc))
+			file, err := os.Create(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Ogg file: %w", err)
+			}
+			oggWriter, err := snd.NewOggWriter(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			current, err = snd.NewOgg(
+				ssrc,
+				startTime,
+				endTime,
+				oggWriter,
+				&snd.RealTimeProvider{},
+				log.Default(),
+			)
+			if err != nil {
+				oggWriter.Close()
+				return nil, fmt.Errorf("failed to create Ogg: %w", err)
+			}
+			paths = append(paths, path)
+		}
+
+		err := current.WritePacket(snd.OpusPacket{
+			ID:        int(packet.ID),
+			Sequence:  uint16(packet.Sequence),
+			Timestamp: uint32(packet.Timestamp),
+			CreatedAt: packet.CreatedAt.Time,
+			OpusData:  packet.OpusData,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+
+	if err := closeCurrent(); err != nil {
+		return nil, fmt.Errorf("failed to close Ogg: %w", err)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestReplayRange(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	option := func(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+		return &discordgo.ApplicationCommandInteractionDataOption{
+			Name:  name,
+			Type:  discordgo.ApplicationCommandOptionString,
+			Value: value,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options []*discordgo.ApplicationCommandInteractionDataOption
+		start   time.Time
+		end     time.Time
+		wantErr bool
+	}{
+		{
+			name:  "Default",
+			start: now.Add(-30 * time.Second),
+			end:   now,
+		},
+		{
+			name:    "Last Two Minutes",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{option("last", "2m")},
+			start:   now.Add(-2 * time.Minute),
+			end:     now,
+		},
+		{
+			name: "Ending Five Minutes Ago",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				option("last", "1m"),
+				option("ago", "5m"),
+			},
+			start: now.Add(-6 * time.Minute),
+			end:   now.Add(-5 * time.Minute),
+		},
+		{
+			name:    "Too Long",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{option("last", "1h")},
+			wantErr: true,
+		},
+		{
+			name:    "Not A Duration",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{option("last", "soon")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := replayRange(tt.options, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got range %s to %s", start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf(
+					"replayRange() returned incorrect range.\nExpected: %s to %s\nGot: %s to %s",
+					tt.start,
+					tt.end,
+					start,
+					end,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+const (
+	voiceCheckInterval  = 2 * time.Second
+	voiceGracePeriod    = 10 * time.Second // Let discordgo try its own reconnect first
+	maxRejoinBackoff    = 5 * time.Minute
+	connectionHeartbeat = 30 * time.Second
+)
+
+// superviseVoice starts keeping the guild's voice connection recording,
+// unless it is already supervised. The supervisor attaches handlers to
+// whichever VoiceConnection discordgo currently has for the guild,
+// rejoins the last channel when the connection is lost, and records the
+// gap as a recording interruption.
+func (b *Bot) superviseVoice(
+	s *discordgo.Session,
+	guildID, channelID string,
+) {
+	ctx, conn, added := b.Connections.add(guildID, channelID)
+	if added {
+		go b.runVoiceSupervisor(ctx, s, guildID, conn.wake)
+	}
+}
+
+func (b *Bot) runVoiceSupervisor(
+	ctx context.Context,
+	s *discordgo.Session,
+	guildID string,
+	wake <-chan struct{},
+) {
+	var (
+		attached      *discordgo.VoiceConnection
+		stopRecording context.CancelFunc = func() {}
+		downSince     time.Time
+		downChannelID string
+		downReason    string
+		nextAttempt   time.Time
+		backoff       = time.Second
+		savedState    ConnectionState
+		savedAt       time.Time
+	)
+	defer func() { stopRecording() }()
+
+	ticker := time.NewTicker(voiceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		vc := voiceConnection(s, guildID)
+
+		// discordgo replaces the VoiceConnection after a hard disconnect,
+		// and the new one has neither our handler nor our packet reader.
+		if vc != nil && vc != attached {
+			stopRecording()
+			stopRecording = b.startRecording(ctx, vc)
+			attached = vc
+		}
+
+		ready := vc != nil && voiceReady(vc)
+		now := time.Now()
+
+		switch {
+		case ready && !downSince.IsZero():
+			log.Info(
+				"Voice connection restored",
+				"guild", guildID,
+				"gap", now.Sub(downSince),
+			)
+			b.recordInterruption(
+				ctx,
+				guildID,
+				downChannelID,
+				downSince,
+				now,
+				downReason,
+			)
+			downSince = time.Time{}
+			backoff = time.Second
+			b.Connections.setState(guildID, ConnectionRecording, voiceChannelID(vc))
+
+		case ready:
+			b.Connections.setState(guildID, ConnectionRecording, voiceChannelID(vc))
+
+		case !ready && downSince.IsZero():
+			downSince = now
+			downReason = b.interruptionReason()
+			nextAttempt = now.Add(voiceGracePeriod)
+			if attached != nil {
+				downChannelID = voiceChannelID(attached)
+			}
+			b.Connections.setState(guildID, ConnectionReconnecting, "")
+			log.Warn(
+				"Voice connection lost",
+				"guild", guildID,
+				"channel", downChannelID,
+				"reason", downReason,
+			)
+
+		case !ready && !now.Before(nextAttempt):
+			channelID, err := b.rejoinLastChannel(s, guildID)
+			if err != nil {
+				log.Error(
+					"Failed to rejoin voice channel",
+					"guild", guildID,
+					"retry_in", backoff,
+					"error", err,
+				)
+			} else {
+				log.Info(
+					"Rejoined voice channel",
+					"guild", guildID,
+					"channel", channelID,
+				)
+				if downChannelID == "" {
+					downChannelID = channelID
+				}
+			}
+			nextAttempt = now.Add(backoff)
+			backoff = min(backoff*2, maxRejoinBackoff)
+		}
+
+		if info, ok := b.Connections.Get(guildID); ok &&
+			(info.State != savedState ||
+				now.Sub(savedAt) >= connectionHeartbeat) {
+			b.saveConnection(ctx, s, info)
+			savedState = info.State
+			savedAt = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// startRecording attaches the speaking handler and packet reader to a
+// voice connection and returns a function that stops the reader.
+func (b *Bot) startRecording(
+	ctx context.Context,
+	vc *discordgo.VoiceConnection,
+) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+
+	vc.AddHandler(b.HandleVoiceSpeakingUpdate)
+	go b.HandleOpusPackets(ctx, vc)
+
+	return cancel
+}
+
+// rejoinLastChannel joins the channel recorded in bot_voice_joins for
+// the guild and returns its ID.
+func (b *Bot) rejoinLastChannel(
+	s *discordgo.Session,
+	guildID string,
+) (string, error) {
+	channelID, err := b.Queries.GetLastJoinedChannel(
+		context.Background(),
+		db.GetLastJoinedChannelParams{
+			GuildID: guildID,
+			UserID:  s.State.User.ID,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to get last joined channel: %w", err)
+	}
+
+	_, err = s.ChannelVoiceJoin(guildID, channelID, false, false)
+	if err != nil {
+		return "", err
+	}
+
+	return channelID, nil
+}
+
+// saveConnection persists a connection's state so that it can be
+// inspected from outside the bot process.
+func (b *Bot) saveConnection(
+	ctx context.Context,
+	s *discordgo.Session,
+	info ConnectionInfo,
+) {
+	var guildName, channelName string
+	if guild, err := s.State.Guild(info.GuildID); err == nil {
+		guildName = guild.Name
+	}
+	if channel, err := s.State.Channel(info.ChannelID); err == nil {
+		channelName = channel.Name
+	}
+
+	err := b.Queries.UpsertBotVoiceConnection(
+		ctx,
+		db.UpsertBotVoiceConnectionParams{
+			GuildID:     info.GuildID,
+			SessionID:   b.SessionID,
+			GuildName:   guildName,
+			ChannelID:   info.ChannelID,
+			ChannelName: channelName,
+			State:       string(info.State),
+			JoinedAt:    pgtype.Timestamptz{Time: info.JoinedAt, Valid: true},
+			PacketCount: info.PacketCount,
+			LastPacketAt: pgtype.Timestamptz{
+				Time:  info.LastPacketAt,
+				Valid: !info.LastPacketAt.IsZero(),
+			},
+		},
+	)
+	if err != nil {
+		log.Error("Failed to save voice connection", "error", err)
+	}
+}
+
+// ClearStaleConnections forgets the voice connections saved by earlier
+// runs of the bot user, which ended with them. Call it before opening
+// the Discord session, so that it can't race with connections saved by
+// this run.
+func (b *Bot) ClearStaleConnections(ctx context.Context, userID string) {
+	err := b.Queries.DeleteStaleBotVoiceConnections(
+		ctx,
+		db.DeleteStaleBotVoiceConnectionsParams{
+			UserID:    userID,
+			SessionID: b.SessionID,
+		},
+	)
+	if err != nil {
+		log.Error("Failed to delete stale voice connections", "error", err)
+	}
+}
+
+// StopSupervising stops keeping voice connections alive and forgets the
+// saved ones, as the bot is shutting down.
+func (b *Bot) StopSupervising(ctx context.Context) {
+	b.Connections.removeAll()
+
+	err := b.Queries.DeleteSessionBotVoiceConnections(ctx, b.SessionID)
+	if err != nil {
+		log.Error("Failed to delete voice connections", "error", err)
+	}
+}
+
+func (b *Bot) interruptionReason() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.gatewayDownSince.IsZero() {
+		return "gateway disconnected"
+	}
+	return "voice connection lost"
+}
+
+func (b *Bot) recordInterruption(
+	ctx context.Context,
+	guildID, channelID string,
+	startedAt, endedAt time.Time,
+	reason string,
+) {
+	err := b.Queries.InsertRecordingInterruption(
+		ctx,
+		db.InsertRecordingInterruptionParams{
+			GuildID:   guildID,
+			ChannelID: channelID,
+			SessionID: pgtype.Int4{Int32: b.SessionID, Valid: true},
+			StartedAt: pgtype.Timestamptz{Time: startedAt, Valid: true},
+			EndedAt:   pgtype.Timestamptz{Time: endedAt, Valid: true},
+			Reason:    reason,
+		},
+	)
+	if err != nil {
+		log.Error("Failed to insert recording interruption", "error", err)
+	}
+}
+
+func (b *Bot) HandleDisconnect(_ *discordgo.Session, _ *discordgo.Disconnect) {
+	log.Warn("Gateway disconnected")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.gatewayDownSince.IsZero() {
+		b.gatewayDownSince = time.Now()
+	}
+}
+
+func (b *Bot) HandleConnect(_ *discordgo.Session, _ *discordgo.Connect) {
+	b.mu.Lock()
+	downSince := b.gatewayDownSince
+	b.gatewayDownSince = time.Time{}
+	b.mu.Unlock()
+
+	if !downSince.IsZero() {
+		log.Info("Gateway reconnected", "gap", time.Since(downSince))
+	}
+
+	b.Connections.wakeAll()
+}
+
+func voiceConnection(
+	s *discordgo.Session,
+	guildID string,
+) *discordgo.VoiceConnection {
+	s.RLock()
+	defer s.RUnlock()
+	return s.VoiceConnections[guildID]
+}
+
+func voiceReady(vc *discordgo.VoiceConnection) bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.Ready
+}
+
+func voiceChannelID(vc *discordgo.VoiceConnection) string {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.ChannelID
+}
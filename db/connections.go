@@ -0,0 +1,8 @@
+package db
+
+import "time"
+
+// VoiceConnectionStaleAfter is how long a bot_voice_connections row
+// lasts without the bot refreshing it before its connection is taken to
+// be gone, as after a crash. The bot refreshes its rows every 30 seconds.
+const VoiceConnectionStaleAfter = 90 * time.Second
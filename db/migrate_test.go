@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("Ordered By Version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/0010_later.up.sql":   {Data: []byte("SELECT 10;")},
+			"m/0010_later.down.sql": {Data: []byte("SELECT -10;")},
+			"m/0002_first.up.sql":   {Data: []byte("SELECT 2;")},
+			"m/0002_first.down.sql": {Data: []byte("SELECT -2;")},
+		}
+
+		migrations, err := LoadMigrations(fsys, "m")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(migrations) != 2 {
+			t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+		}
+		if migrations[0].Version != 2 || migrations[1].Version != 10 {
+			t.Errorf(
+				"Expected versions 2 and 10, got %d and %d",
+				migrations[0].Version,
+				migrations[1].Version,
+			)
+		}
+		if migrations[0].Up != "SELECT 2;" || migrations[0].Down != "SELECT -2;" {
+			t.Errorf("Migration 2 has wrong SQL: %+v", migrations[0])
+		}
+	})
+
+	t.Run("Missing Down File", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/0001_only_up.up.sql": {Data: []byte("SELECT 1;")},
+		}
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Error("Expected an error for a migration without a down file")
+		}
+	})
+
+	t.Run("Bad File Name", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"m/add_column.sql": {Data: []byte("SELECT 1;")},
+		}
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Error("Expected an error for an unnumbered migration")
+		}
+	})
+
+	t.Run("Embedded Migrations", func(t *testing.T) {
+		migrations, err := LoadMigrations(migrationsFS, "migrations")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(migrations) == 0 || migrations[0].Name != "initial" {
+			t.Errorf("Expected the initial migration first, got %+v", migrations)
+		}
+	})
+}
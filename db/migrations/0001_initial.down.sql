@@ -0,0 +1,19 @@
+DROP FUNCTION IF EXISTS upsert_transcription_segment(BIGINT, BOOLEAN);
+
+DROP TABLE IF EXISTS word_alternatives;
+DROP TABLE IF EXISTS transcription_words;
+DROP TABLE IF EXISTS transcription_segments;
+DROP TABLE IF EXISTS transcription_sessions;
+DROP TABLE IF EXISTS uploaded_files;
+DROP TABLE IF EXISTS bot_voice_connections;
+DROP TABLE IF EXISTS recording_interruptions;
+DROP TABLE IF EXISTS bot_voice_decisions;
+DROP TABLE IF EXISTS guild_voice_policies;
+DROP TABLE IF EXISTS bot_voice_joins;
+DROP TABLE IF EXISTS voice_state_events;
+DROP TABLE IF EXISTS opus_packets;
+DROP TABLE IF EXISTS ssrc_mappings;
+DROP TABLE IF EXISTS discord_sessions;
+
+DROP FUNCTION IF EXISTS notify_transcription_change();
+DROP FUNCTION IF EXISTS notify_new_opus_packet();
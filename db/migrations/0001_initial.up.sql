@@ -1,10 +1,16 @@
+-- Baseline schema. Databases created before migrations were introduced
+-- already have most of this, so every statement tolerates existing objects.
 CREATE TABLE IF NOT EXISTS discord_sessions (
     id SERIAL PRIMARY KEY,
-    bot_token TEXT NOT NULL,
     user_id TEXT NOT NULL,
     created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Sessions used to store the raw bot token; the bot's user ID identifies it
+ALTER TABLE discord_sessions DROP COLUMN IF EXISTS bot_token;
+
+CREATE INDEX IF NOT EXISTS idx_discord_sessions_user_id ON discord_sessions (user_id);
+
 CREATE TABLE IF NOT EXISTS ssrc_mappings (
     id SERIAL PRIMARY KEY,
     guild_id TEXT NOT NULL,
@@ -54,6 +60,54 @@ CREATE TABLE IF NOT EXISTS bot_voice_joins (
     UNIQUE (guild_id, session_id)
 );
 
+CREATE TABLE IF NOT EXISTS guild_voice_policies (
+    guild_id TEXT PRIMARY KEY,
+    always_channel_id TEXT,
+    follow_user_ids TEXT[] NOT NULL DEFAULT '{}',
+    min_members INT NOT NULL DEFAULT 0,
+    leave_after_minutes INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS bot_voice_decisions (
+    id BIGSERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT,
+    action TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    session_id INTEGER REFERENCES discord_sessions(id),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_bot_voice_decisions_guild_created_at ON bot_voice_decisions (guild_id, created_at);
+
+CREATE TABLE IF NOT EXISTS recording_interruptions (
+    id BIGSERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    session_id INTEGER REFERENCES discord_sessions(id),
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_recording_interruptions_guild_started_at ON recording_interruptions (guild_id, started_at);
+
+CREATE TABLE IF NOT EXISTS bot_voice_connections (
+    guild_id TEXT NOT NULL,
+    session_id INTEGER NOT NULL REFERENCES discord_sessions(id),
+    guild_name TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    channel_name TEXT NOT NULL,
+    state TEXT NOT NULL,
+    joined_at TIMESTAMPTZ NOT NULL,
+    packet_count BIGINT NOT NULL DEFAULT 0,
+    last_packet_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (guild_id, session_id)
+);
+
 -- Create a function to notify about new opus packets
 CREATE OR REPLACE FUNCTION notify_new_opus_packet() RETURNS TRIGGER AS $$ BEGIN PERFORM pg_notify('new_opus_packet', row_to_json(NEW)::text);
 
@@ -78,6 +132,8 @@ END $$;
 -- Create an index on the opus_packets table
 CREATE INDEX IF NOT EXISTS idx_opus_packets_ssrc_created_at ON opus_packets (ssrc, created_at);
 
+CREATE INDEX IF NOT EXISTS idx_opus_packets_guild_channel_created_at ON opus_packets (guild_id, channel_id, created_at);
+
 CREATE TABLE IF NOT EXISTS uploaded_files (
     id SERIAL PRIMARY KEY,
     hash TEXT UNIQUE NOT NULL,
@@ -115,6 +171,11 @@ CREATE TABLE IF NOT EXISTS transcription_words (
     created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Columns that older databases got from loose migration scripts
+ALTER TABLE transcription_segments ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE transcription_words ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE transcription_words ADD COLUMN IF NOT EXISTS attaches_to TEXT;
+
 -- Create an index on the attaches_to column for better performance
 CREATE INDEX IF NOT EXISTS idx_transcription_words_attaches_to ON transcription_words(attaches_to);
 
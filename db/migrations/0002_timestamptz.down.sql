@@ -0,0 +1,18 @@
+-- The baseline schema already declares these columns as TIMESTAMPTZ,
+-- except for request_to_speak_timestamp, which goes back to local EEST
+-- wall-clock time.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+            AND table_name = 'voice_state_events'
+            AND column_name = 'request_to_speak_timestamp'
+            AND data_type = 'timestamp with time zone'
+    ) THEN
+        ALTER TABLE voice_state_events
+        ALTER COLUMN request_to_speak_timestamp TYPE timestamp
+        USING request_to_speak_timestamp AT TIME ZONE 'EEST';
+    END IF;
+END $$;
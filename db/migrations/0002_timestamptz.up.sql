@@ -0,0 +1,30 @@
+-- Databases created before the schema used TIMESTAMPTZ stored local EEST
+-- wall-clock times in plain TIMESTAMP columns. Convert any such column
+-- that is still TIMESTAMP; columns that are already TIMESTAMPTZ are left
+-- alone, so this is safe to run on every database.
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+            AND data_type = 'timestamp without time zone'
+            AND (table_name, column_name) IN (
+                ('discord_sessions', 'created_at'),
+                ('ssrc_mappings', 'created_at'),
+                ('opus_packets', 'created_at'),
+                ('voice_state_events', 'created_at'),
+                ('voice_state_events', 'request_to_speak_timestamp'),
+                ('bot_voice_joins', 'joined_at')
+            )
+    LOOP
+        EXECUTE format(
+            'ALTER TABLE %I ALTER COLUMN %I TYPE timestamptz USING %I AT TIME ZONE ''EEST''',
+            col.table_name,
+            col.column_name,
+            col.column_name
+        );
+    END LOOP;
+END $$;
@@ -0,0 +1 @@
+-- The duplicate constraint is not restored.
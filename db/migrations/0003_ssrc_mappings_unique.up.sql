@@ -0,0 +1,20 @@
+-- The unique constraint on ssrc_mappings was declared in the table
+-- definition and also added separately as unique_ssrc_mapping, leaving
+-- some databases with two identical constraints. Keep exactly one.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'unique_ssrc_mapping'
+    ) THEN
+        IF EXISTS (
+            SELECT 1 FROM pg_constraint
+            WHERE conname = 'ssrc_mappings_guild_id_channel_id_user_id_ssrc_key'
+        ) THEN
+            ALTER TABLE ssrc_mappings DROP CONSTRAINT unique_ssrc_mapping;
+        ELSE
+            ALTER TABLE ssrc_mappings RENAME CONSTRAINT unique_ssrc_mapping
+                TO ssrc_mappings_guild_id_channel_id_user_id_ssrc_key;
+        END IF;
+    END IF;
+END $$;
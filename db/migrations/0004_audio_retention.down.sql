@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_opus_packets_guild_created_at;
+
+DROP TABLE IF EXISTS guild_retention_policies;
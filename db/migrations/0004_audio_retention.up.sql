@@ -0,0 +1,10 @@
+-- How many days of raw audio to keep per guild; 0 keeps it forever.
+-- Guilds without a row use the AUDIO_RETENTION_DAYS setting.
+CREATE TABLE IF NOT EXISTS guild_retention_policies (
+    guild_id TEXT PRIMARY KEY,
+    audio_retention_days INT NOT NULL CHECK (audio_retention_days >= 0),
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Pruning looks up old packets by guild and age
+CREATE INDEX IF NOT EXISTS idx_opus_packets_guild_created_at ON opus_packets (guild_id, created_at);
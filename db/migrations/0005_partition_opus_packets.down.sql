@@ -0,0 +1,45 @@
+ALTER TABLE opus_packets RENAME TO opus_packets_partitioned;
+
+-- Free the constraint name for the new table's primary key
+ALTER TABLE opus_packets_partitioned RENAME CONSTRAINT opus_packets_pkey TO opus_packets_partitioned_pkey;
+
+ALTER SEQUENCE opus_packets_id_seq OWNED BY NONE;
+
+CREATE TABLE opus_packets (
+    id INTEGER PRIMARY KEY DEFAULT nextval('opus_packets_id_seq'),
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    ssrc BIGINT NOT NULL,
+    sequence INTEGER NOT NULL,
+    timestamp BIGINT NOT NULL,
+    opus_data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    session_id INTEGER NOT NULL REFERENCES discord_sessions(id)
+);
+
+ALTER SEQUENCE opus_packets_id_seq OWNED BY opus_packets.id;
+
+INSERT INTO opus_packets
+SELECT id,
+    guild_id,
+    channel_id,
+    ssrc,
+    sequence,
+    timestamp,
+    opus_data,
+    created_at,
+    session_id
+FROM opus_packets_partitioned;
+
+DROP TABLE opus_packets_partitioned;
+
+CREATE INDEX idx_opus_packets_ssrc_created_at ON opus_packets (ssrc, created_at);
+CREATE INDEX idx_opus_packets_guild_channel_created_at ON opus_packets (guild_id, channel_id, created_at);
+CREATE INDEX idx_opus_packets_guild_created_at ON opus_packets (guild_id, created_at);
+
+CREATE TRIGGER opus_packet_inserted
+AFTER
+INSERT ON opus_packets FOR EACH ROW EXECUTE FUNCTION notify_new_opus_packet();
+
+DROP FUNCTION IF EXISTS ensure_opus_packets_partitions(DATE, DATE);
+DROP FUNCTION IF EXISTS create_opus_packets_partition(DATE);
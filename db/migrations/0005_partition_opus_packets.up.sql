@@ -0,0 +1,92 @@
+-- Partition opus_packets by day on created_at so that time range queries
+-- only touch the days they cover and old audio can be dropped a whole
+-- day at a time. Partitions cover UTC days and are named
+-- opus_packets_pYYYYMMDD.
+--
+-- Existing rows are copied into the new table inside this migration's
+-- transaction, which takes a while on a large table.
+
+CREATE OR REPLACE FUNCTION create_opus_packets_partition(day DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := format('opus_packets_p%s', to_char(day, 'YYYYMMDD'));
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF opus_packets FOR VALUES FROM (%L) TO (%L)',
+        partition_name,
+        day::timestamp AT TIME ZONE 'UTC',
+        (day + 1)::timestamp AT TIME ZONE 'UTC'
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ensure_opus_packets_partitions(from_day DATE, to_day DATE) RETURNS INT AS $$
+DECLARE
+    day DATE;
+    created INT := 0;
+BEGIN
+    FOR day IN SELECT generate_series(from_day, to_day, '1 day')::DATE LOOP
+        IF to_regclass(format('opus_packets_p%s', to_char(day, 'YYYYMMDD'))) IS NULL THEN
+            PERFORM create_opus_packets_partition(day);
+            created := created + 1;
+        END IF;
+    END LOOP;
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE opus_packets RENAME TO opus_packets_unpartitioned;
+
+-- Free the constraint name for the new table's primary key
+ALTER TABLE opus_packets_unpartitioned RENAME CONSTRAINT opus_packets_pkey TO opus_packets_unpartitioned_pkey;
+
+ALTER SEQUENCE opus_packets_id_seq OWNED BY NONE;
+
+-- The primary key of a partitioned table must include the partition key
+CREATE TABLE opus_packets (
+    id INTEGER NOT NULL DEFAULT nextval('opus_packets_id_seq'),
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    ssrc BIGINT NOT NULL,
+    sequence INTEGER NOT NULL,
+    timestamp BIGINT NOT NULL,
+    opus_data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    session_id INTEGER NOT NULL REFERENCES discord_sessions(id),
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+ALTER SEQUENCE opus_packets_id_seq OWNED BY opus_packets.id;
+
+SELECT ensure_opus_packets_partitions(
+        COALESCE(
+            (
+                SELECT MIN(created_at AT TIME ZONE 'UTC')::DATE
+                FROM opus_packets_unpartitioned
+            ),
+            (now() AT TIME ZONE 'UTC')::DATE
+        ),
+        (now() AT TIME ZONE 'UTC')::DATE + 7
+    );
+
+INSERT INTO opus_packets
+SELECT id,
+    guild_id,
+    channel_id,
+    ssrc,
+    sequence,
+    timestamp,
+    opus_data,
+    created_at,
+    session_id
+FROM opus_packets_unpartitioned;
+
+DROP TABLE opus_packets_unpartitioned;
+
+CREATE INDEX idx_opus_packets_ssrc_created_at ON opus_packets (ssrc, created_at);
+CREATE INDEX idx_opus_packets_guild_channel_created_at ON opus_packets (guild_id, channel_id, created_at);
+CREATE INDEX idx_opus_packets_guild_created_at ON opus_packets (guild_id, created_at);
+
+CREATE TRIGGER opus_packet_inserted
+AFTER
+INSERT ON opus_packets FOR EACH ROW EXECUTE FUNCTION notify_new_opus_packet();
@@ -0,0 +1,12 @@
+-- Archived audio is not restored into opus_packets, and its packets are
+-- gone from there, so opus_archives is the only record of where it is.
+-- Refuse to roll back while anything has been archived.
+DO $$
+BEGIN
+    IF to_regclass('opus_archives') IS NOT NULL
+        AND EXISTS (SELECT 1 FROM opus_archives) THEN
+        RAISE EXCEPTION 'opus_archives holds archived audio; restore or delete it before rolling back';
+    END IF;
+END $$;
+
+DROP TABLE IF EXISTS opus_archives;
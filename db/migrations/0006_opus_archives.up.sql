@@ -0,0 +1,17 @@
+-- Packets of completed sessions rolled into one Ogg file per stream.
+-- The packets themselves are deleted from opus_packets once archived.
+CREATE TABLE IF NOT EXISTS opus_archives (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES discord_sessions(id),
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    ssrc BIGINT NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ NOT NULL,
+    packet_count BIGINT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    location TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_opus_archives_ssrc_start_time ON opus_archives (ssrc, start_time);
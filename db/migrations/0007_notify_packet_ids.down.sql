@@ -0,0 +1,7 @@
+CREATE OR REPLACE FUNCTION notify_new_opus_packet() RETURNS TRIGGER AS $$ BEGIN PERFORM pg_notify('new_opus_packet', row_to_json(NEW)::text);
+
+RETURN NEW;
+
+END;
+
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,18 @@
+-- NOTIFY payloads are capped at 8000 bytes, and sending the hex-encoded
+-- Opus data doubled the traffic of every packet. Listeners now get only
+-- the row's identity and fetch the payloads themselves.
+CREATE OR REPLACE FUNCTION notify_new_opus_packet() RETURNS TRIGGER AS $$ BEGIN PERFORM pg_notify(
+        'new_opus_packet',
+        json_build_object(
+            'id', NEW.id,
+            'guild_id', NEW.guild_id,
+            'channel_id', NEW.channel_id,
+            'ssrc', NEW.ssrc
+        )::text
+    );
+
+RETURN NEW;
+
+END;
+
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_transcription_sessions_start_time;
+
+ALTER TABLE transcription_sessions DROP COLUMN IF EXISTS transcript_version;
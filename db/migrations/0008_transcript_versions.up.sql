@@ -0,0 +1,6 @@
+-- Re-transcribing recorded audio creates new sessions alongside the
+-- original ones; transcript_version tells the runs apart.
+ALTER TABLE transcription_sessions
+ADD COLUMN IF NOT EXISTS transcript_version INT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_transcription_sessions_start_time ON transcription_sessions (start_time);
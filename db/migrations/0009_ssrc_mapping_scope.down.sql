@@ -0,0 +1,18 @@
+DROP TRIGGER IF EXISTS ssrc_mapping_inserted ON ssrc_mappings;
+
+DROP FUNCTION IF EXISTS notify_ssrc_mapping_change();
+
+-- Keep the latest session's row of each mapping
+DELETE FROM ssrc_mappings m USING ssrc_mappings newer
+WHERE newer.guild_id = m.guild_id
+    AND newer.channel_id = m.channel_id
+    AND newer.user_id = m.user_id
+    AND newer.ssrc = m.ssrc
+    AND newer.id > m.id;
+
+ALTER TABLE ssrc_mappings DROP CONSTRAINT IF EXISTS ssrc_mappings_scope_key;
+
+ALTER TABLE ssrc_mappings
+ADD CONSTRAINT ssrc_mappings_guild_id_channel_id_user_id_ssrc_key UNIQUE (guild_id, channel_id, user_id, ssrc);
+
+ALTER TABLE ssrc_mappings DROP COLUMN IF EXISTS last_seen_at;
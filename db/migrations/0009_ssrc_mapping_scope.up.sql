@@ -0,0 +1,45 @@
+-- Discord reuses SSRCs across sessions and users, so a mapping is only
+-- meaningful within one bot session's voice connection and from the
+-- time it was first seen. Keep one row per user an SSRC was assigned
+-- to in each session, instead of one per channel overall.
+ALTER TABLE ssrc_mappings
+ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE ssrc_mappings
+SET last_seen_at = created_at
+WHERE last_seen_at IS NULL
+    OR last_seen_at > created_at;
+
+ALTER TABLE ssrc_mappings DROP CONSTRAINT IF EXISTS ssrc_mappings_guild_id_channel_id_user_id_ssrc_key;
+
+ALTER TABLE ssrc_mappings
+ADD CONSTRAINT ssrc_mappings_scope_key UNIQUE (
+        guild_id,
+        channel_id,
+        session_id,
+        ssrc,
+        user_id
+    );
+
+-- Tell SSRC caches when an SSRC gets a new user
+CREATE OR REPLACE FUNCTION notify_ssrc_mapping_change() RETURNS TRIGGER AS $$ BEGIN PERFORM pg_notify(
+        'ssrc_mapping_change',
+        json_build_object(
+            'guild_id', NEW.guild_id,
+            'channel_id', NEW.channel_id,
+            'session_id', NEW.session_id,
+            'ssrc', NEW.ssrc
+        )::text
+    );
+
+RETURN NEW;
+
+END;
+
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ssrc_mapping_inserted ON ssrc_mappings;
+
+CREATE TRIGGER ssrc_mapping_inserted
+AFTER
+INSERT ON ssrc_mappings FOR EACH ROW EXECUTE FUNCTION notify_ssrc_mapping_change();
@@ -0,0 +1,10 @@
+DROP FUNCTION IF EXISTS refresh_transcription_segment_content(BIGINT);
+
+DROP INDEX IF EXISTS idx_transcription_segments_start_time;
+DROP INDEX IF EXISTS idx_transcription_segments_search;
+
+ALTER TABLE transcription_segments
+DROP COLUMN IF EXISTS search,
+DROP COLUMN IF EXISTS end_time,
+DROP COLUMN IF EXISTS start_time,
+DROP COLUMN IF EXISTS content;
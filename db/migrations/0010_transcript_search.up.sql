@@ -0,0 +1,56 @@
+-- Final segments keep their text, where they happened and a search
+-- vector, so that transcripts can be searched without stitching words
+-- back together on every query.
+ALTER TABLE transcription_segments
+ADD COLUMN IF NOT EXISTS content TEXT,
+ADD COLUMN IF NOT EXISTS start_time TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS end_time TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    to_tsvector('english', coalesce(content, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_transcription_segments_search ON transcription_segments USING GIN (search);
+CREATE INDEX IF NOT EXISTS idx_transcription_segments_start_time ON transcription_segments (start_time);
+
+-- Rebuilds the text of a segment from the most confident alternative of
+-- each word of its current version.
+CREATE OR REPLACE FUNCTION refresh_transcription_segment_content(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    UPDATE transcription_segments ts
+    SET content = words.content,
+        start_time = words.start_time,
+        end_time = words.end_time
+    FROM (
+        SELECT ltrim(string_agg(
+                CASE WHEN best.attaches_to = 'previous' THEN best.content
+                ELSE ' ' || best.content END,
+                '' ORDER BY best.start_time, best.id
+            )) AS content,
+            MIN(s.start_time + best.start_time) AS start_time,
+            MAX(s.start_time + best.start_time + best.duration) AS end_time
+        FROM (
+            SELECT DISTINCT ON (tw.id) tw.id,
+                tw.start_time,
+                tw.duration,
+                tw.attaches_to,
+                wa.content
+            FROM transcription_words tw
+                JOIN word_alternatives wa ON wa.word_id = tw.id
+            WHERE tw.segment_id = p_segment_id
+                AND tw.version = (
+                    SELECT version
+                    FROM transcription_segments
+                    WHERE id = p_segment_id
+                )
+            ORDER BY tw.id, wa.confidence DESC
+        ) best
+            JOIN transcription_segments seg ON seg.id = p_segment_id
+            JOIN transcription_sessions s ON s.id = seg.session_id
+    ) words
+    WHERE ts.id = p_segment_id;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_transcription_segment_content(id)
+FROM transcription_segments
+WHERE is_final;
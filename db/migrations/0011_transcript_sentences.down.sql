@@ -0,0 +1,9 @@
+ALTER TABLE transcription_segments
+ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    to_tsvector('english', coalesce(content, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_transcription_segments_search ON transcription_segments USING GIN (search);
+
+DROP FUNCTION IF EXISTS refresh_transcript_sentences(BIGINT);
+DROP TABLE IF EXISTS transcript_sentences;
@@ -0,0 +1,95 @@
+-- Sentences of final segments, kept so that views and searches can read
+-- whole sentences instead of reassembling them from word rows.
+CREATE TABLE IF NOT EXISTS transcript_sentences (
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES transcription_sessions(id),
+    segment_id BIGINT NOT NULL REFERENCES transcription_segments(id),
+    sentence_index INT NOT NULL,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ NOT NULL,
+    content TEXT NOT NULL,
+    confidence REAL NOT NULL,
+    search tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT transcript_sentences_segment_key UNIQUE (segment_id, sentence_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transcript_sentences_session_id ON transcript_sentences (session_id);
+CREATE INDEX IF NOT EXISTS idx_transcript_sentences_channel_time ON transcript_sentences (guild_id, channel_id, start_time);
+CREATE INDEX IF NOT EXISTS idx_transcript_sentences_start_time ON transcript_sentences (start_time);
+CREATE INDEX IF NOT EXISTS idx_transcript_sentences_search ON transcript_sentences USING GIN (search);
+
+-- Rebuilds the sentences of a segment from the most confident
+-- alternative of each word of its current version. A segment that is
+-- not final has no sentences.
+CREATE OR REPLACE FUNCTION refresh_transcript_sentences(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    DELETE FROM transcript_sentences
+    WHERE segment_id = p_segment_id;
+
+    INSERT INTO transcript_sentences (
+        session_id,
+        segment_id,
+        sentence_index,
+        guild_id,
+        channel_id,
+        user_id,
+        start_time,
+        end_time,
+        content,
+        confidence
+    )
+    SELECT s.id,
+        seg.id,
+        words.sentence_index,
+        s.guild_id,
+        s.channel_id,
+        s.user_id,
+        MIN(s.start_time + words.start_time),
+        MAX(s.start_time + words.start_time + words.duration),
+        ltrim(string_agg(
+            CASE WHEN words.attaches_to = 'previous' THEN words.content
+            ELSE ' ' || words.content END,
+            '' ORDER BY words.start_time, words.id
+        )),
+        AVG(words.confidence)::REAL
+    FROM transcription_segments seg
+        JOIN transcription_sessions s ON s.id = seg.session_id
+        JOIN (
+            SELECT best.*,
+                COALESCE(SUM(CASE WHEN best.is_eos THEN 1 ELSE 0 END) OVER (
+                    ORDER BY best.start_time, best.id
+                    ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+                ), 0)::INT AS sentence_index
+            FROM (
+                SELECT DISTINCT ON (tw.id) tw.id,
+                    tw.start_time,
+                    tw.duration,
+                    tw.is_eos,
+                    tw.attaches_to,
+                    wa.content,
+                    wa.confidence
+                FROM transcription_words tw
+                    JOIN transcription_segments tseg ON tseg.id = tw.segment_id
+                    AND tseg.version = tw.version
+                    JOIN word_alternatives wa ON wa.word_id = tw.id
+                WHERE tw.segment_id = p_segment_id
+                ORDER BY tw.id, wa.confidence DESC
+            ) best
+        ) words ON TRUE
+    WHERE seg.id = p_segment_id
+        AND seg.is_final
+    GROUP BY s.id, seg.id, words.sentence_index;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_transcript_sentences(id)
+FROM transcription_segments
+WHERE is_final;
+
+-- Searches read sentences now
+DROP INDEX IF EXISTS idx_transcription_segments_search;
+ALTER TABLE transcription_segments DROP COLUMN IF EXISTS search;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS web_sessions;
+DROP TABLE IF EXISTS web_user_guilds;
+DROP TABLE IF EXISTS web_users;
@@ -0,0 +1,36 @@
+-- People who have logged in to the HTTP server with Discord.
+CREATE TABLE IF NOT EXISTS web_users (
+    id TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Guilds a user was in when they last logged in.
+CREATE TABLE IF NOT EXISTS web_user_guilds (
+    user_id TEXT NOT NULL REFERENCES web_users(id) ON DELETE CASCADE,
+    guild_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, guild_id)
+);
+
+-- Browser sessions. Only a hash of the cookie is kept.
+CREATE TABLE IF NOT EXISTS web_sessions (
+    token_hash BYTEA PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES web_users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_web_sessions_expires_at ON web_sessions (expires_at);
+
+-- Tokens for scripts, acting as the user they were made for. Only a hash
+-- of the token is kept.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES web_users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,112 @@
+CREATE OR REPLACE FUNCTION refresh_transcription_segment_content(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    UPDATE transcription_segments ts
+    SET content = words.content,
+        start_time = words.start_time,
+        end_time = words.end_time
+    FROM (
+        SELECT ltrim(string_agg(
+                CASE WHEN best.attaches_to = 'previous' THEN best.content
+                ELSE ' ' || best.content END,
+                '' ORDER BY best.start_time, best.id
+            )) AS content,
+            MIN(s.start_time + best.start_time) AS start_time,
+            MAX(s.start_time + best.start_time + best.duration) AS end_time
+        FROM (
+            SELECT DISTINCT ON (tw.id) tw.id,
+                tw.start_time,
+                tw.duration,
+                tw.attaches_to,
+                wa.content
+            FROM transcription_words tw
+                JOIN word_alternatives wa ON wa.word_id = tw.id
+            WHERE tw.segment_id = p_segment_id
+                AND tw.version = (
+                    SELECT version
+                    FROM transcription_segments
+                    WHERE id = p_segment_id
+                )
+            ORDER BY tw.id, wa.confidence DESC
+        ) best
+            JOIN transcription_segments seg ON seg.id = p_segment_id
+            JOIN transcription_sessions s ON s.id = seg.session_id
+    ) words
+    WHERE ts.id = p_segment_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION refresh_transcript_sentences(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    DELETE FROM transcript_sentences
+    WHERE segment_id = p_segment_id;
+
+    INSERT INTO transcript_sentences (
+        session_id,
+        segment_id,
+        sentence_index,
+        guild_id,
+        channel_id,
+        user_id,
+        start_time,
+        end_time,
+        content,
+        confidence
+    )
+    SELECT s.id,
+        seg.id,
+        words.sentence_index,
+        s.guild_id,
+        s.channel_id,
+        s.user_id,
+        MIN(s.start_time + words.start_time),
+        MAX(s.start_time + words.start_time + words.duration),
+        ltrim(string_agg(
+            CASE WHEN words.attaches_to = 'previous' THEN words.content
+            ELSE ' ' || words.content END,
+            '' ORDER BY words.start_time, words.id
+        )),
+        AVG(words.confidence)::REAL
+    FROM transcription_segments seg
+        JOIN transcription_sessions s ON s.id = seg.session_id
+        JOIN (
+            SELECT best.*,
+                COALESCE(SUM(CASE WHEN best.is_eos THEN 1 ELSE 0 END) OVER (
+                    ORDER BY best.start_time, best.id
+                    ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+                ), 0)::INT AS sentence_index
+            FROM (
+                SELECT DISTINCT ON (tw.id) tw.id,
+                    tw.start_time,
+                    tw.duration,
+                    tw.is_eos,
+                    tw.attaches_to,
+                    wa.content,
+                    wa.confidence
+                FROM transcription_words tw
+                    JOIN transcription_segments tseg ON tseg.id = tw.segment_id
+                    AND tseg.version = tw.version
+                    JOIN word_alternatives wa ON wa.word_id = tw.id
+                WHERE tw.segment_id = p_segment_id
+                ORDER BY tw.id, wa.confidence DESC
+            ) best
+        ) words ON TRUE
+    WHERE seg.id = p_segment_id
+        AND seg.is_final
+    GROUP BY s.id, seg.id, words.sentence_index;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP VIEW IF EXISTS current_transcript_words;
+
+ALTER TABLE transcript_sentences DROP COLUMN IF EXISTS original_content;
+
+DROP TABLE IF EXISTS guild_vocabulary;
+DROP TABLE IF EXISTS transcript_corrections;
+
+SELECT refresh_transcript_sentences(id)
+FROM transcription_segments
+WHERE is_final;
+
+SELECT refresh_transcription_segment_content(id)
+FROM transcription_segments
+WHERE is_final;
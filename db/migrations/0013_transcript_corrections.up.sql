@@ -0,0 +1,185 @@
+-- Corrections people make to transcripts. A correction with a word_id
+-- replaces that word's text; one without replaces the whole sentence.
+-- Corrections are never changed or deleted, so they are the edit history,
+-- and the latest one wins.
+CREATE TABLE IF NOT EXISTS transcript_corrections (
+    id BIGSERIAL PRIMARY KEY,
+    segment_id BIGINT NOT NULL REFERENCES transcription_segments(id),
+    sentence_index INT NOT NULL,
+    word_id BIGINT REFERENCES transcription_words(id),
+    content TEXT NOT NULL,
+    previous_content TEXT NOT NULL,
+    author_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transcript_corrections_sentence ON transcript_corrections (segment_id, sentence_index);
+CREATE INDEX IF NOT EXISTS idx_transcript_corrections_word_id ON transcript_corrections (word_id)
+WHERE word_id IS NOT NULL;
+
+-- Words people corrected transcripts to, given to the speech recognizer
+-- as extra vocabulary when transcribing the guild.
+CREATE TABLE IF NOT EXISTS guild_vocabulary (
+    guild_id TEXT NOT NULL,
+    content TEXT NOT NULL,
+    uses INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (guild_id, content)
+);
+
+-- The text of sentences as recognized, before corrections
+ALTER TABLE transcript_sentences
+ADD COLUMN IF NOT EXISTS original_content TEXT NOT NULL DEFAULT '';
+
+-- The words of the current version of each segment: the most confident
+-- alternative as recognized, its corrected text, and which sentence of
+-- the segment it belongs to. Filter on segment_id, which is pushed down.
+CREATE OR REPLACE VIEW current_transcript_words AS
+SELECT best.*,
+    COALESCE(SUM(CASE WHEN best.is_eos THEN 1 ELSE 0 END) OVER (
+        PARTITION BY best.segment_id
+        ORDER BY best.start_time, best.id
+        ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+    ), 0)::INT AS sentence_index
+FROM (
+    SELECT DISTINCT ON (tw.segment_id, tw.id) tw.id,
+        tw.segment_id,
+        tw.start_time,
+        tw.duration,
+        tw.is_eos,
+        tw.attaches_to,
+        wa.confidence,
+        wa.content AS original_content,
+        COALESCE(correction.content, wa.content) AS content
+    FROM transcription_words tw
+        JOIN transcription_segments tseg ON tseg.id = tw.segment_id
+        AND tseg.version = tw.version
+        JOIN word_alternatives wa ON wa.word_id = tw.id
+        LEFT JOIN LATERAL (
+            SELECT tc.content
+            FROM transcript_corrections tc
+            WHERE tc.word_id = tw.id
+            ORDER BY tc.id DESC
+            LIMIT 1
+        ) correction ON TRUE
+    ORDER BY tw.segment_id, tw.id, wa.confidence DESC
+) best;
+
+CREATE OR REPLACE FUNCTION refresh_transcription_segment_content(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    UPDATE transcription_segments ts
+    SET content = words.content,
+        start_time = words.start_time,
+        end_time = words.end_time
+    FROM (
+        SELECT ltrim(string_agg(
+                CASE WHEN w.attaches_to = 'previous' THEN w.content
+                ELSE ' ' || w.content END,
+                '' ORDER BY w.start_time, w.id
+            )) AS content,
+            MIN(s.start_time + w.start_time) AS start_time,
+            MAX(s.start_time + w.start_time + w.duration) AS end_time
+        FROM current_transcript_words w
+            JOIN transcription_segments seg ON seg.id = p_segment_id
+            JOIN transcription_sessions s ON s.id = seg.session_id
+        WHERE w.segment_id = p_segment_id
+    ) words
+    WHERE ts.id = p_segment_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Rebuilds the sentences of a segment from its current words with their
+-- corrections, or from the latest correction of a whole sentence. Rows
+-- are updated in place so that sentence IDs, and links to them, last.
+-- A segment that is not final has no sentences.
+CREATE OR REPLACE FUNCTION refresh_transcript_sentences(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    WITH sentences AS (
+        SELECT s.id AS session_id,
+            seg.id AS segment_id,
+            w.sentence_index,
+            s.guild_id,
+            s.channel_id,
+            s.user_id,
+            MIN(s.start_time + w.start_time) AS start_time,
+            MAX(s.start_time + w.start_time + w.duration) AS end_time,
+            ltrim(string_agg(
+                CASE WHEN w.attaches_to = 'previous' THEN w.content
+                ELSE ' ' || w.content END,
+                '' ORDER BY w.start_time, w.id
+            )) AS content,
+            ltrim(string_agg(
+                CASE WHEN w.attaches_to = 'previous' THEN w.original_content
+                ELSE ' ' || w.original_content END,
+                '' ORDER BY w.start_time, w.id
+            )) AS original_content,
+            AVG(w.confidence)::REAL AS confidence
+        FROM transcription_segments seg
+            JOIN transcription_sessions s ON s.id = seg.session_id
+            JOIN current_transcript_words w ON w.segment_id = seg.id
+        WHERE seg.id = p_segment_id
+            AND seg.is_final
+        GROUP BY s.id, seg.id, w.sentence_index
+    )
+    INSERT INTO transcript_sentences (
+        session_id,
+        segment_id,
+        sentence_index,
+        guild_id,
+        channel_id,
+        user_id,
+        start_time,
+        end_time,
+        content,
+        original_content,
+        confidence
+    )
+    SELECT sentences.session_id,
+        sentences.segment_id,
+        sentences.sentence_index,
+        sentences.guild_id,
+        sentences.channel_id,
+        sentences.user_id,
+        sentences.start_time,
+        sentences.end_time,
+        COALESCE(rewrite.content, sentences.content),
+        sentences.original_content,
+        sentences.confidence
+    FROM sentences
+        LEFT JOIN LATERAL (
+            SELECT tc.content
+            FROM transcript_corrections tc
+            WHERE tc.segment_id = sentences.segment_id
+                AND tc.sentence_index = sentences.sentence_index
+                AND tc.word_id IS NULL
+            ORDER BY tc.id DESC
+            LIMIT 1
+        ) rewrite ON TRUE
+    ON CONFLICT (segment_id, sentence_index) DO UPDATE
+    SET session_id = EXCLUDED.session_id,
+        guild_id = EXCLUDED.guild_id,
+        channel_id = EXCLUDED.channel_id,
+        user_id = EXCLUDED.user_id,
+        start_time = EXCLUDED.start_time,
+        end_time = EXCLUDED.end_time,
+        content = EXCLUDED.content,
+        original_content = EXCLUDED.original_content,
+        confidence = EXCLUDED.confidence;
+
+    DELETE FROM transcript_sentences ts
+    WHERE ts.segment_id = p_segment_id
+        AND NOT EXISTS (
+            SELECT 1
+            FROM current_transcript_words w
+                JOIN transcription_segments seg ON seg.id = w.segment_id
+            WHERE w.segment_id = p_segment_id
+                AND w.sentence_index = ts.sentence_index
+                AND seg.is_final
+        );
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_transcript_sentences(id)
+FROM transcription_segments
+WHERE is_final;
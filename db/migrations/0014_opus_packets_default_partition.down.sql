@@ -0,0 +1,22 @@
+-- Give the packets in the default partition day partitions of their own
+SELECT create_opus_packets_partition(day)
+FROM (
+        SELECT DISTINCT (created_at AT TIME ZONE 'UTC')::DATE AS day
+        FROM opus_packets_pdefault
+    ) days;
+
+DROP TABLE IF EXISTS opus_packets_pdefault;
+
+CREATE OR REPLACE FUNCTION create_opus_packets_partition(day DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := format('opus_packets_p%s', to_char(day, 'YYYYMMDD'));
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF opus_packets FOR VALUES FROM (%L) TO (%L)',
+        partition_name,
+        day::timestamp AT TIME ZONE 'UTC',
+        (day + 1)::timestamp AT TIME ZONE 'UTC'
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
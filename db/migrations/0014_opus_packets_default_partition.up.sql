@@ -0,0 +1,42 @@
+-- Packets for a day without a partition land in the default partition
+-- instead of failing to insert, as when partition maintenance has not
+-- run for a week.
+CREATE TABLE IF NOT EXISTS opus_packets_pdefault PARTITION OF opus_packets DEFAULT;
+
+-- A day partition can't be created while the default partition holds
+-- packets for that day, so they are moved into the new table before it
+-- is attached.
+CREATE OR REPLACE FUNCTION create_opus_packets_partition(day DATE) RETURNS TEXT AS $$
+DECLARE
+    partition_name TEXT := format('opus_packets_p%s', to_char(day, 'YYYYMMDD'));
+    day_start TIMESTAMPTZ := day::timestamp AT TIME ZONE 'UTC';
+    day_end TIMESTAMPTZ := (day + 1)::timestamp AT TIME ZONE 'UTC';
+BEGIN
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN partition_name;
+    END IF;
+
+    EXECUTE format(
+        'CREATE TABLE %I (LIKE opus_packets INCLUDING DEFAULTS INCLUDING CONSTRAINTS)',
+        partition_name
+    );
+    EXECUTE format(
+        'WITH moved AS (
+            DELETE FROM opus_packets_pdefault
+            WHERE created_at >= %L AND created_at < %L
+            RETURNING *
+        )
+        INSERT INTO %I SELECT * FROM moved',
+        day_start,
+        day_end,
+        partition_name
+    );
+    EXECUTE format(
+        'ALTER TABLE opus_packets ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        partition_name,
+        day_start,
+        day_end
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS transcript_session_visible(BIGINT, INT);
+DROP TABLE IF EXISTS transcript_versions;
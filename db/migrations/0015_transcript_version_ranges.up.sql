@@ -0,0 +1,55 @@
+-- The audio each re-transcription covered. A transcription session is
+-- superseded by a later version whose run covered its start and began
+-- after it, and readers show only sessions that are not superseded
+-- unless they ask for an earlier version.
+CREATE TABLE IF NOT EXISTS transcript_versions (
+    id BIGSERIAL PRIMARY KEY,
+    version INT NOT NULL,
+    from_time TIMESTAMPTZ NOT NULL,
+    to_time TIMESTAMPTZ NOT NULL,
+    guild_id TEXT,
+    channel_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transcript_versions_time ON transcript_versions (from_time, to_time);
+
+-- Runs from before their ranges were recorded are taken to have covered
+-- the sessions they created in each channel.
+INSERT INTO transcript_versions (
+        version,
+        from_time,
+        to_time,
+        guild_id,
+        channel_id,
+        created_at
+    )
+SELECT transcript_version,
+    MIN(start_time),
+    MAX(start_time),
+    guild_id,
+    channel_id,
+    MIN(created_at)
+FROM transcription_sessions
+WHERE transcript_version > 1
+GROUP BY transcript_version,
+    guild_id,
+    channel_id;
+
+-- Whether a session is part of the transcript as of a version: the
+-- latest one if p_version is NULL.
+CREATE OR REPLACE FUNCTION transcript_session_visible(p_session_id BIGINT, p_version INT) RETURNS BOOLEAN AS $$
+    SELECT s.transcript_version <= COALESCE(p_version, s.transcript_version)
+        AND NOT EXISTS (
+            SELECT 1
+            FROM transcript_versions v
+            WHERE v.version > s.transcript_version
+                AND v.version <= COALESCE(p_version, v.version)
+                AND v.created_at > s.created_at
+                AND s.start_time BETWEEN v.from_time AND v.to_time
+                AND (v.guild_id IS NULL OR v.guild_id = s.guild_id)
+                AND (v.channel_id IS NULL OR v.channel_id = s.channel_id)
+        )
+    FROM transcription_sessions s
+    WHERE s.id = p_session_id;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,99 @@
+DROP INDEX IF EXISTS idx_transcript_corrections_sentence;
+CREATE INDEX IF NOT EXISTS idx_transcript_corrections_sentence ON transcript_corrections (segment_id, sentence_index);
+
+-- Rebuilds the sentences of a segment from its current words with their
+-- corrections, or from the latest correction of a whole sentence. Rows
+-- are updated in place so that sentence IDs, and links to them, last.
+-- A segment that is not final has no sentences.
+CREATE OR REPLACE FUNCTION refresh_transcript_sentences(p_segment_id BIGINT) RETURNS VOID AS $$
+BEGIN
+    WITH sentences AS (
+        SELECT s.id AS session_id,
+            seg.id AS segment_id,
+            w.sentence_index,
+            s.guild_id,
+            s.channel_id,
+            s.user_id,
+            MIN(s.start_time + w.start_time) AS start_time,
+            MAX(s.start_time + w.start_time + w.duration) AS end_time,
+            ltrim(string_agg(
+                CASE WHEN w.attaches_to = 'previous' THEN w.content
+                ELSE ' ' || w.content END,
+                '' ORDER BY w.start_time, w.id
+            )) AS content,
+            ltrim(string_agg(
+                CASE WHEN w.attaches_to = 'previous' THEN w.original_content
+                ELSE ' ' || w.original_content END,
+                '' ORDER BY w.start_time, w.id
+            )) AS original_content,
+            AVG(w.confidence)::REAL AS confidence
+        FROM transcription_segments seg
+            JOIN transcription_sessions s ON s.id = seg.session_id
+            JOIN current_transcript_words w ON w.segment_id = seg.id
+        WHERE seg.id = p_segment_id
+            AND seg.is_final
+        GROUP BY s.id, seg.id, w.sentence_index
+    )
+    INSERT INTO transcript_sentences (
+        session_id,
+        segment_id,
+        sentence_index,
+        guild_id,
+        channel_id,
+        user_id,
+        start_time,
+        end_time,
+        content,
+        original_content,
+        confidence
+    )
+    SELECT sentences.session_id,
+        sentences.segment_id,
+        sentences.sentence_index,
+        sentences.guild_id,
+        sentences.channel_id,
+        sentences.user_id,
+        sentences.start_time,
+        sentences.end_time,
+        COALESCE(rewrite.content, sentences.content),
+        sentences.original_content,
+        sentences.confidence
+    FROM sentences
+        LEFT JOIN LATERAL (
+            SELECT tc.content
+            FROM transcript_corrections tc
+            WHERE tc.segment_id = sentences.segment_id
+                AND tc.sentence_index = sentences.sentence_index
+                AND tc.word_id IS NULL
+            ORDER BY tc.id DESC
+            LIMIT 1
+        ) rewrite ON TRUE
+    ON CONFLICT (segment_id, sentence_index) DO UPDATE
+    SET session_id = EXCLUDED.session_id,
+        guild_id = EXCLUDED.guild_id,
+        channel_id = EXCLUDED.channel_id,
+        user_id = EXCLUDED.user_id,
+        start_time = EXCLUDED.start_time,
+        end_time = EXCLUDED.end_time,
+        content = EXCLUDED.content,
+        original_content = EXCLUDED.original_content,
+        confidence = EXCLUDED.confidence;
+
+    DELETE FROM transcript_sentences ts
+    WHERE ts.segment_id = p_segment_id
+        AND NOT EXISTS (
+            SELECT 1
+            FROM current_transcript_words w
+                JOIN transcription_segments seg ON seg.id = w.segment_id
+            WHERE w.segment_id = p_segment_id
+                AND w.sentence_index = ts.sentence_index
+                AND seg.is_final
+        );
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE transcript_corrections DROP COLUMN IF EXISTS segment_version;
+
+SELECT refresh_transcript_sentences(id)
+FROM transcription_segments
+WHERE is_final;
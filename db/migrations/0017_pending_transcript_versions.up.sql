@@ -0,0 +1,37 @@
+-- When each re-transcription finished. Until then its version is
+-- pending: its sessions are hidden and it supersedes nothing, so readers
+-- keep seeing the previous transcript of the range while it runs or if
+-- it fails.
+ALTER TABLE transcript_versions
+ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+
+UPDATE transcript_versions
+SET completed_at = created_at
+WHERE completed_at IS NULL;
+
+CREATE OR REPLACE FUNCTION transcript_session_visible(p_session_id BIGINT, p_version INT) RETURNS BOOLEAN AS $$
+    SELECT s.transcript_version <= COALESCE(p_version, s.transcript_version)
+        AND NOT EXISTS (
+            SELECT 1
+            FROM transcript_versions v
+            WHERE v.version > s.transcript_version
+                AND v.version <= COALESCE(p_version, v.version)
+                AND v.completed_at IS NOT NULL
+                AND v.created_at > s.created_at
+                AND s.start_time BETWEEN v.from_time AND v.to_time
+                AND (v.guild_id IS NULL OR v.guild_id = s.guild_id)
+                AND (v.channel_id IS NULL OR v.channel_id = s.channel_id)
+        )
+        AND NOT EXISTS (
+            SELECT 1
+            FROM transcript_versions v
+            WHERE v.version = s.transcript_version
+                AND v.completed_at IS NULL
+                AND v.created_at <= s.created_at
+                AND s.start_time BETWEEN v.from_time AND v.to_time
+                AND (v.guild_id IS NULL OR v.guild_id = s.guild_id)
+                AND (v.channel_id IS NULL OR v.channel_id = s.channel_id)
+        )
+    FROM transcription_sessions s
+    WHERE s.id = p_session_id;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// opus_packets is partitioned by UTC day into tables named like
+// opus_packets_p20240601. Partitions are created a week ahead; packets
+// for a day without one go to opus_packets_pdefault until it is created.
+const (
+	opusPacketsPartitionPrefix   = "opus_packets_p"
+	opusPacketsPartitionLayout   = "20060102"
+	PartitionDaysAhead           = 7
+	partitionMaintenanceInterval = 6 * time.Hour
+)
+
+// EnsureOpusPacketsPartitions creates any missing partitions from the
+// day of now through daysAhead days later, returning how many it created.
+func EnsureOpusPacketsPartitions(
+	ctx context.Context,
+	q *Queries,
+	now time.Time,
+	daysAhead int,
+) (int32, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	created, err := q.EnsureOpusPacketsPartitions(
+		ctx,
+		EnsureOpusPacketsPartitionsParams{
+			FromDay: pgtype.Date{Time: today, Valid: true},
+			ToDay:   pgtype.Date{Time: today.AddDate(0, 0, daysAhead), Valid: true},
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create opus_packets partitions: %w", err)
+	}
+	return created, nil
+}
+
+// MaintainPartitions keeps future partitions created until the context
+// is canceled.
+func MaintainPartitions(ctx context.Context, q *Queries) {
+	ticker := time.NewTicker(partitionMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		created, err := EnsureOpusPacketsPartitions(
+			ctx,
+			q,
+			time.Now(),
+			PartitionDaysAhead,
+		)
+		if err != nil && ctx.Err() == nil {
+			log.Error("Failed to maintain partitions", "error", err)
+		} else if created > 0 {
+			log.Info("Created opus_packets partitions", "count", created)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// partitionDay returns the UTC day a partition covers.
+func partitionDay(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, opusPacketsPartitionPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(opusPacketsPartitionLayout, suffix)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// partitionExpired reports whether a partition that ended at end only
+// holds audio past every one of its guilds' retention periods.
+func partitionExpired(
+	end time.Time,
+	guildIDs []string,
+	cutoff func(guildID string) (time.Time, bool),
+) bool {
+	for _, guildID := range guildIDs {
+		c, ok := cutoff(guildID)
+		if !ok || c.Before(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// dropExpiredPartitions drops the day partitions that ended before now
+// and whose audio every guild in them is done keeping, after finishing
+// off any that an earlier prune detached but failed to drop.
+func (p *Pruner) dropExpiredPartitions(
+	ctx context.Context,
+	now time.Time,
+	cutoff func(guildID string) (time.Time, bool),
+	result *PruneResult,
+) error {
+	if err := p.dropDetachedPartitions(ctx, now, result); err != nil {
+		return err
+	}
+
+	partitions, err := p.Queries.ListOpusPacketsPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	for _, partition := range partitions {
+		day, ok := partitionDay(partition.Name)
+		if !ok {
+			continue
+		}
+		end := day.AddDate(0, 0, 1)
+		if end.After(now) {
+			continue
+		}
+
+		counts, err := p.partitionGuildCounts(ctx, partition.Name)
+		if err != nil {
+			return err
+		}
+		guildIDs := make([]string, 0, len(counts))
+		var packets int64
+		for guildID, count := range counts {
+			guildIDs = append(guildIDs, guildID)
+			packets += count
+		}
+		if !partitionExpired(end, guildIDs, cutoff) {
+			continue
+		}
+
+		if err := p.dropPartition(ctx, partition.Name); err != nil {
+			return err
+		}
+		log.Info(
+			"Dropped opus_packets partition",
+			"partition", partition.Name,
+			"packets", packets,
+			"bytes", partition.Size,
+		)
+		result.Partitions++
+		result.Packets += packets
+		result.Bytes += partition.Size
+	}
+
+	return nil
+}
+
+// dropDetachedPartitions drops day partitions that were detached from
+// opus_packets but are still there. Only a prune detaches them, and only
+// once they have expired.
+func (p *Pruner) dropDetachedPartitions(
+	ctx context.Context,
+	now time.Time,
+	result *PruneResult,
+) error {
+	detached, err := p.Queries.ListDetachedOpusPacketsPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list detached partitions: %w", err)
+	}
+
+	for _, partition := range detached {
+		day, ok := partitionDay(partition.Name)
+		if !ok || day.AddDate(0, 0, 1).After(now) {
+			continue
+		}
+
+		counts, err := p.partitionGuildCounts(ctx, partition.Name)
+		if err != nil {
+			return err
+		}
+		var packets int64
+		for _, count := range counts {
+			packets += count
+		}
+
+		_, err = p.Queries.db.Exec(
+			ctx,
+			"DROP TABLE "+pgx.Identifier{partition.Name}.Sanitize(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to drop %s: %w", partition.Name, err)
+		}
+		log.Info(
+			"Dropped detached opus_packets partition",
+			"partition", partition.Name,
+			"packets", packets,
+			"bytes", partition.Size,
+		)
+		result.Partitions++
+		result.Packets += packets
+		result.Bytes += partition.Size
+	}
+
+	return nil
+}
+
+func (p *Pruner) partitionGuildCounts(
+	ctx context.Context,
+	partition string,
+) (map[string]int64, error) {
+	rows, err := p.Queries.db.Query(
+		ctx,
+		"SELECT guild_id, COUNT(*) FROM "+
+			pgx.Identifier{partition}.Sanitize()+
+			" GROUP BY guild_id",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count packets in %s: %w", partition, err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var guildID string
+		var count int64
+		if err := rows.Scan(&guildID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan packet counts: %w", err)
+		}
+		counts[guildID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// dropPartition detaches a partition and then drops it. Detaching locks
+// opus_packets briefly, as DETACH CONCURRENTLY isn't allowed while the
+// table has a default partition. If the drop fails, the next prune drops
+// the detached table.
+func (p *Pruner) dropPartition(ctx context.Context, partition string) error {
+	name := pgx.Identifier{partition}.Sanitize()
+
+	_, err := p.Queries.db.Exec(
+		ctx,
+		"ALTER TABLE opus_packets DETACH PARTITION "+name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to detach %s: %w", partition, err)
+	}
+
+	_, err = p.Queries.db.Exec(ctx, "DROP TABLE "+name)
+	if err != nil {
+		return fmt.Errorf("failed to drop %s: %w", partition, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionDay(t *testing.T) {
+	day, ok := partitionDay("opus_packets_p20240601")
+	if !ok || !day.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 2024-06-01, got %s (ok=%v)", day, ok)
+	}
+
+	for _, name := range []string{"opus_packets", "opus_packets_pdefault", "other_p20240601"} {
+		if _, ok := partitionDay(name); ok {
+			t.Errorf("Expected %q not to be a day partition", name)
+		}
+	}
+}
+
+func TestPartitionExpired(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	cutoffs := map[string]time.Time{
+		"short": now.AddDate(0, 0, -7),
+		"long":  now.AddDate(0, 0, -30),
+	}
+	cutoff := func(guildID string) (time.Time, bool) {
+		c, ok := cutoffs[guildID]
+		return c, ok
+	}
+
+	tests := []struct {
+		name     string
+		end      time.Time
+		guildIDs []string
+		expected bool
+	}{
+		{
+			name:     "Past Every Retention",
+			end:      time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			guildIDs: []string{"short", "long"},
+			expected: true,
+		},
+		{
+			name:     "Longer Retention Still Applies",
+			end:      time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC),
+			guildIDs: []string{"short", "long"},
+			expected: false,
+		},
+		{
+			name:     "Only Short Retention",
+			end:      time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC),
+			guildIDs: []string{"short"},
+			expected: true,
+		},
+		{
+			name:     "Guild Keeping Audio Forever",
+			end:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			guildIDs: []string{"short", "forever"},
+			expected: false,
+		},
+		{
+			name:     "Empty Partition",
+			end:      time.Date(2024, 6, 29, 0, 0, 0, 0, time.UTC),
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := partitionExpired(tt.end, tt.guildIDs, cutoff)
+			if result != tt.expected {
+				t.Errorf(
+					"partitionExpired() returned %v, expected %v",
+					result,
+					tt.expected,
+				)
+			}
+		})
+	}
+}
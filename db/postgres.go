@@ -2,54 +2,67 @@ package db
 
 import (
 	"context"
-	"embed"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/viper"
 )
 
-//go:embed db_init.sql
-var sqlFS embed.FS
-
 var (
 	dbPool    *pgxpool.Pool
 	dbQueries *Queries
 	dbOnce    sync.Once
 )
 
+// Connect opens a connection pool without touching the schema.
+func Connect(ctx context.Context) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(
+		ctx,
+		viper.GetString("DATABASE_URL")+"?sslmode=disable",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	return pool, nil
+}
+
+// OpenDatabase connects and applies any pending migrations.
 func OpenDatabase() (*pgxpool.Pool, *Queries, error) {
 	var err error
 	dbOnce.Do(func() {
-		dbPool, err = pgxpool.New(
-			context.Background(),
-			viper.GetString("DATABASE_URL")+"?sslmode=disable",
-		)
+		ctx := context.Background()
+
+		dbPool, err = Connect(ctx)
 		if err != nil {
-			err = fmt.Errorf("unable to connect to database: %w", err)
 			return
 		}
 
 		dbQueries = New(dbPool)
 
-		sqlFile, readErr := sqlFS.ReadFile("db_init.sql")
-		if readErr != nil {
-			err = fmt.Errorf(
-				"failed to read embedded db_init.sql: %w",
-				readErr,
-			)
+		migrator, migrateErr := NewMigrator(dbPool)
+		if migrateErr != nil {
+			err = migrateErr
 			return
 		}
 
-		_, execErr := dbPool.Exec(context.Background(), string(sqlFile))
-		if execErr != nil {
-			err = fmt.Errorf(
-				"failed to execute embedded db_init.sql: %w",
-				execErr,
-			)
+		applied, migrateErr := migrator.Migrate(ctx)
+		if migrateErr != nil {
+			err = fmt.Errorf("failed to migrate database: %w", migrateErr)
 			return
 		}
+		for _, m := range applied {
+			log.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+
+		_, err = EnsureOpusPacketsPartitions(
+			ctx,
+			dbQueries,
+			time.Now(),
+			PartitionDaysAhead,
+		)
 	})
 
 	if err != nil {
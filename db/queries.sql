@@ -5,6 +5,107 @@ UPDATE
 SET channel_id = EXCLUDED.channel_id,
     joined_at = CURRENT_TIMESTAMP;
 
+-- name: GetGuildVoicePolicy :one
+SELECT *
+FROM guild_voice_policies
+WHERE guild_id = $1;
+
+-- name: UpsertGuildVoicePolicy :exec
+INSERT INTO guild_voice_policies (
+        guild_id,
+        always_channel_id,
+        follow_user_ids,
+        min_members,
+        leave_after_minutes
+    )
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (guild_id) DO
+UPDATE
+SET always_channel_id = EXCLUDED.always_channel_id,
+    follow_user_ids = EXCLUDED.follow_user_ids,
+    min_members = EXCLUDED.min_members,
+    leave_after_minutes = EXCLUDED.leave_after_minutes,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: InsertBotVoiceDecision :exec
+INSERT INTO bot_voice_decisions (
+        guild_id,
+        channel_id,
+        action,
+        reason,
+        session_id
+    )
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetRecentBotVoiceDecisions :many
+SELECT *
+FROM bot_voice_decisions
+WHERE guild_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: InsertRecordingInterruption :exec
+INSERT INTO recording_interruptions (
+        guild_id,
+        channel_id,
+        session_id,
+        started_at,
+        ended_at,
+        reason
+    )
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetRecordingInterruptions :many
+SELECT *
+FROM recording_interruptions
+WHERE ended_at >= sqlc.arg(start_time)
+    AND started_at <= sqlc.arg(end_time)
+ORDER BY started_at;
+
+-- name: UpsertBotVoiceConnection :exec
+INSERT INTO bot_voice_connections (
+        guild_id,
+        session_id,
+        guild_name,
+        channel_id,
+        channel_name,
+        state,
+        joined_at,
+        packet_count,
+        last_packet_at
+    )
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (guild_id, session_id) DO
+UPDATE
+SET guild_name = EXCLUDED.guild_name,
+    channel_id = EXCLUDED.channel_id,
+    channel_name = EXCLUDED.channel_name,
+    state = EXCLUDED.state,
+    joined_at = EXCLUDED.joined_at,
+    packet_count = EXCLUDED.packet_count,
+    last_packet_at = EXCLUDED.last_packet_at,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteBotVoiceConnection :exec
+DELETE FROM bot_voice_connections
+WHERE guild_id = $1
+    AND session_id = $2;
+
+-- name: DeleteSessionBotVoiceConnections :exec
+DELETE FROM bot_voice_connections
+WHERE session_id = $1;
+
+-- name: DeleteStaleBotVoiceConnections :exec
+DELETE FROM bot_voice_connections c USING discord_sessions ds
+WHERE c.session_id = ds.id
+    AND ds.user_id = $1
+    AND c.session_id <> $2;
+
+-- name: ListBotVoiceConnections :many
+SELECT *
+FROM bot_voice_connections
+WHERE updated_at >= sqlc.arg(live_since)::TIMESTAMPTZ
+ORDER BY guild_name,
+    guild_id;
+
 -- name: GetVoiceActivityReport :many
 SELECT u.user_id,
     COUNT(DISTINCT op.id) AS packet_count,
@@ -13,15 +114,18 @@ SELECT u.user_id,
     SUM(LENGTH(op.opus_data)) AS total_bytes
 FROM opus_packets op
     JOIN ssrc_mappings u ON op.ssrc = u.ssrc
+    AND op.guild_id = u.guild_id
+    AND op.channel_id = u.channel_id
+    AND op.session_id = u.session_id
 WHERE op.created_at BETWEEN $1 AND $2
 GROUP BY u.user_id
 ORDER BY packet_count DESC;
 
 -- name: UpsertSSRCMapping :exec
 INSERT INTO ssrc_mappings (guild_id, channel_id, user_id, ssrc, session_id)
-VALUES ($1, $2, $3, $4, $5) ON CONFLICT (guild_id, channel_id, user_id, ssrc) DO
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (guild_id, channel_id, session_id, ssrc, user_id) DO
 UPDATE
-SET session_id = EXCLUDED.session_id;
+SET last_seen_at = CURRENT_TIMESTAMP;
 
 -- name: InsertOpusPacket :exec
 INSERT INTO opus_packets (
@@ -36,16 +140,22 @@ INSERT INTO opus_packets (
 VALUES ($1, $2, $3, $4, $5, $6, $7);
 
 -- name: InsertDiscordSession :one
-INSERT INTO discord_sessions (bot_token, user_id)
-VALUES ($1, $2)
+INSERT INTO discord_sessions (user_id)
+VALUES ($1)
 RETURNING id;
 
+-- name: DeleteBotVoiceJoins :exec
+DELETE FROM bot_voice_joins bvj USING discord_sessions ds
+WHERE bvj.session_id = ds.id
+    AND bvj.guild_id = $1
+    AND ds.user_id = $2;
+
 -- name: GetLastJoinedChannel :one
 SELECT bvj.channel_id
 FROM bot_voice_joins bvj
     JOIN discord_sessions ds ON bvj.session_id = ds.id
 WHERE bvj.guild_id = $1
-    AND ds.bot_token = $2
+    AND ds.user_id = $2
 ORDER BY bvj.joined_at DESC
 LIMIT 1;
 
@@ -86,6 +196,53 @@ WHERE ssrc = $1
     AND created_at BETWEEN $2 AND $3
 ORDER BY created_at;
 
+-- name: GetOpusPacketsForChannel :many
+SELECT *
+FROM opus_packets
+WHERE guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND created_at BETWEEN sqlc.arg(start_time) AND sqlc.arg(end_time)
+ORDER BY ssrc,
+    created_at;
+
+-- name: GetOpusPacketsAfterID :many
+SELECT *
+FROM opus_packets
+WHERE id > sqlc.arg(after_id)
+    AND created_at >= sqlc.arg(since)::TIMESTAMPTZ
+ORDER BY id
+LIMIT sqlc.arg(batch_size);
+
+-- name: GetOpusPacketsForReplay :many
+SELECT *
+FROM opus_packets
+WHERE (created_at, id) > (
+        sqlc.arg(after_time)::TIMESTAMPTZ,
+        sqlc.arg(after_id)::INTEGER
+    )
+    AND created_at < sqlc.arg(end_time)::TIMESTAMPTZ
+    AND (
+        sqlc.narg(guild_id)::TEXT IS NULL
+        OR guild_id = sqlc.narg(guild_id)::TEXT
+    )
+    AND (
+        sqlc.narg(channel_id)::TEXT IS NULL
+        OR channel_id = sqlc.narg(channel_id)::TEXT
+    )
+ORDER BY created_at,
+    id
+LIMIT sqlc.arg(batch_size);
+
+-- name: GetTranscriptionSegmentsForCatchUp :many
+SELECT id,
+    session_id,
+    is_final,
+    version
+FROM transcription_segments
+WHERE id > sqlc.arg(after_id)
+    OR id = ANY(sqlc.arg(open_ids)::BIGINT [])
+ORDER BY id;
+
 -- name: GetSSRCForSession :one
 SELECT ssrc
 FROM transcription_sessions
@@ -95,8 +252,10 @@ LIMIT 1;
 -- name: GetOpusPacketsForTimeRange :many
 SELECT *
 FROM opus_packets
-WHERE ssrc = $1
-    AND created_at BETWEEN $2 AND $3
+WHERE guild_id = $1
+    AND channel_id = $2
+    AND ssrc = $3
+    AND created_at BETWEEN $4 AND $5
 ORDER BY created_at;
 
 -- name: GetUploadedFileByHash :one
@@ -109,17 +268,60 @@ LIMIT 1;
 INSERT INTO uploaded_files (hash, file_name, remote_uri)
 VALUES ($1, $2, $3);
 
--- name: GetUserIDBySSRC :one
-SELECT user_id
+-- name: ListSSRCMappings :many
+SELECT user_id,
+    created_at
 FROM ssrc_mappings
-WHERE ssrc = $1
-LIMIT 1;
+WHERE guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND ssrc = sqlc.arg(ssrc)
+    AND (
+        sqlc.narg(session_id)::INTEGER IS NULL
+        OR session_id = sqlc.narg(session_id)::INTEGER
+    )
+ORDER BY created_at;
 
 -- name: InsertTranscriptionSession :one
-INSERT INTO transcription_sessions (ssrc, start_time, guild_id, channel_id, user_id)
+INSERT INTO transcription_sessions (
+        ssrc,
+        start_time,
+        guild_id,
+        channel_id,
+        user_id,
+        transcript_version
+    )
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id;
+
+-- name: GetNextTranscriptVersion :one
+SELECT (COALESCE(MAX(transcript_version), 0) + 1)::INT AS version
+FROM transcription_sessions
+WHERE start_time BETWEEN sqlc.arg(from_time) AND sqlc.arg(to_time)
+    AND (
+        sqlc.narg(guild_id)::TEXT IS NULL
+        OR guild_id = sqlc.narg(guild_id)::TEXT
+    )
+    AND (
+        sqlc.narg(channel_id)::TEXT IS NULL
+        OR channel_id = sqlc.narg(channel_id)::TEXT
+    );
+
+-- name: InsertTranscriptVersion :one
+INSERT INTO transcript_versions (
+        version,
+        from_time,
+        to_time,
+        guild_id,
+        channel_id
+    )
 VALUES ($1, $2, $3, $4, $5)
 RETURNING id;
 
+-- name: CompleteTranscriptVersion :exec
+UPDATE transcript_versions
+SET completed_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
 -- name: UpsertTranscriptionSegment :one
 SELECT result.segment_id::BIGINT,
     result.version::INT
@@ -154,14 +356,17 @@ SELECT ts.id,
     ts.is_final,
     tw.id AS word_id,
     s.created_at AS session_created_at,
-    (s.created_at + tw.start_time)::timestamptz AS real_start_time,
+    (s.start_time + tw.start_time)::timestamptz AS real_start_time,
     tw.start_time,
     tw.duration,
     tw.is_eos,
     tw.attaches_to,
     wa.content,
     wa.confidence,
-    s.id AS session_id
+    s.id AS session_id,
+    s.guild_id,
+    s.channel_id,
+    s.user_id
 FROM transcription_segments ts
     JOIN transcription_words tw ON ts.id = tw.segment_id
     AND ts.version = tw.version
@@ -175,7 +380,604 @@ WHERE (
         sqlc.narg(created_at)::TIMESTAMPTZ IS NULL
         OR ts.created_at > sqlc.narg(created_at)::TIMESTAMPTZ
     )
+    AND transcript_session_visible(s.id, NULL::INT)
 ORDER BY ts.created_at,
     tw.start_time,
     tw.id,
     wa.confidence DESC;
+
+-- name: ListGuildRetentionPolicies :many
+SELECT *
+FROM guild_retention_policies
+ORDER BY guild_id;
+
+-- name: UpsertGuildRetentionPolicy :exec
+INSERT INTO guild_retention_policies (guild_id, audio_retention_days)
+VALUES ($1, $2) ON CONFLICT (guild_id) DO
+UPDATE
+SET audio_retention_days = EXCLUDED.audio_retention_days,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteGuildRetentionPolicy :exec
+DELETE FROM guild_retention_policies
+WHERE guild_id = $1;
+
+-- name: PruneGuildOpusPackets :one
+WITH doomed AS (
+    SELECT id,
+        created_at
+    FROM opus_packets
+    WHERE guild_id = sqlc.arg(guild_id)
+        AND created_at < sqlc.arg(cutoff)
+    LIMIT sqlc.arg(batch_size)
+), deleted AS (
+    DELETE FROM opus_packets op USING doomed
+    WHERE op.id = doomed.id
+        AND op.created_at = doomed.created_at
+    RETURNING octet_length(op.opus_data) AS size
+)
+SELECT COUNT(*)::BIGINT AS packets,
+    COALESCE(SUM(size), 0)::BIGINT AS bytes
+FROM deleted;
+
+-- name: PruneDefaultOpusPackets :one
+WITH doomed AS (
+    SELECT id,
+        created_at
+    FROM opus_packets
+    WHERE created_at < sqlc.arg(cutoff)
+        AND guild_id NOT IN (
+            SELECT guild_id
+            FROM guild_retention_policies
+        )
+    LIMIT sqlc.arg(batch_size)
+), deleted AS (
+    DELETE FROM opus_packets op USING doomed
+    WHERE op.id = doomed.id
+        AND op.created_at = doomed.created_at
+    RETURNING octet_length(op.opus_data) AS size
+)
+SELECT COUNT(*)::BIGINT AS packets,
+    COALESCE(SUM(size), 0)::BIGINT AS bytes
+FROM deleted;
+
+-- name: GetOpusPacketsTableSize :one
+SELECT COALESCE(SUM(pg_total_relation_size(i.inhrelid)), 0)::BIGINT AS size
+FROM pg_inherits i
+WHERE i.inhparent = 'opus_packets'::regclass;
+
+-- name: ListOpusPacketsPartitions :many
+SELECT c.relname::TEXT AS name,
+    pg_total_relation_size(c.oid)::BIGINT AS size
+FROM pg_inherits i
+    JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = 'opus_packets'::regclass
+ORDER BY c.relname;
+
+-- name: ListDetachedOpusPacketsPartitions :many
+SELECT c.relname::TEXT AS name,
+    pg_total_relation_size(c.oid)::BIGINT AS size
+FROM pg_class c
+WHERE c.relkind = 'r'
+    AND NOT c.relispartition
+    AND c.relnamespace = (
+        SELECT relnamespace
+        FROM pg_class
+        WHERE oid = 'opus_packets'::regclass
+    )
+    AND c.relname LIKE 'opus\_packets\_p%'
+ORDER BY c.relname;
+
+-- name: EnsureOpusPacketsPartitions :one
+SELECT ensure_opus_packets_partitions(
+        sqlc.arg(from_day)::DATE,
+        sqlc.arg(to_day)::DATE
+    )::INT AS created;
+
+-- name: ListCompletedSessionStreams :many
+SELECT op.session_id,
+    op.guild_id,
+    op.channel_id,
+    op.ssrc,
+    MIN(op.created_at)::TIMESTAMPTZ AS start_time,
+    MAX(op.created_at)::TIMESTAMPTZ AS end_time,
+    COUNT(*) AS packet_count
+FROM opus_packets op
+WHERE NOT EXISTS (
+        SELECT 1
+        FROM bot_voice_connections c
+        WHERE c.session_id = op.session_id
+            AND c.guild_id = op.guild_id
+            AND c.channel_id = op.channel_id
+            AND c.updated_at >= sqlc.arg(live_since)::TIMESTAMPTZ
+    )
+    AND (
+        sqlc.narg(session_id)::INT IS NULL
+        OR op.session_id = sqlc.narg(session_id)::INT
+    )
+GROUP BY op.session_id,
+    op.guild_id,
+    op.channel_id,
+    op.ssrc
+HAVING MAX(op.created_at) < sqlc.arg(live_since)::TIMESTAMPTZ
+ORDER BY op.session_id,
+    start_time;
+
+-- name: GetSessionStreamPackets :many
+SELECT *
+FROM opus_packets
+WHERE session_id = sqlc.arg(session_id)
+    AND guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND ssrc = sqlc.arg(ssrc)
+    AND created_at <= sqlc.arg(end_time)
+ORDER BY created_at;
+
+-- name: DeleteSessionStreamPackets :execrows
+DELETE FROM opus_packets
+WHERE session_id = sqlc.arg(session_id)
+    AND guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND ssrc = sqlc.arg(ssrc)
+    AND created_at <= sqlc.arg(end_time);
+
+-- name: InsertOpusArchive :one
+INSERT INTO opus_archives (
+        session_id,
+        guild_id,
+        channel_id,
+        ssrc,
+        start_time,
+        end_time,
+        packet_count,
+        size_bytes,
+        location
+    )
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id;
+
+-- name: GetOpusArchivesForTimeRange :many
+SELECT *
+FROM opus_archives
+WHERE guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND ssrc = sqlc.arg(ssrc)
+    AND start_time <= sqlc.arg(end_time)
+    AND end_time >= sqlc.arg(start_time)
+ORDER BY start_time;
+
+-- name: RefreshTranscriptionSegmentContent :exec
+SELECT refresh_transcription_segment_content(sqlc.arg(segment_id)::BIGINT);
+
+-- name: RefreshTranscriptSentences :exec
+SELECT refresh_transcript_sentences(sqlc.arg(segment_id)::BIGINT);
+
+-- name: GetTranscriptSentences :many
+SELECT *
+FROM transcript_sentences
+WHERE start_time >= sqlc.arg(start_time)::TIMESTAMPTZ
+    AND start_time < sqlc.arg(end_time)::TIMESTAMPTZ
+    AND (
+        sqlc.narg(session_id)::BIGINT IS NULL
+        OR session_id = sqlc.narg(session_id)::BIGINT
+    )
+    AND (
+        sqlc.narg(guild_id)::TEXT IS NULL
+        OR guild_id = sqlc.narg(guild_id)::TEXT
+    )
+    AND (
+        sqlc.narg(channel_id)::TEXT IS NULL
+        OR channel_id = sqlc.narg(channel_id)::TEXT
+    )
+    AND (
+        sqlc.narg(user_id)::TEXT IS NULL
+        OR user_id = sqlc.narg(user_id)::TEXT
+    )
+    AND (
+        sqlc.narg(min_confidence)::REAL IS NULL
+        OR confidence >= sqlc.narg(min_confidence)::REAL
+    )
+    AND (
+        sqlc.narg(session_id)::BIGINT IS NOT NULL
+        OR transcript_session_visible(session_id, sqlc.narg(version)::INT)
+    )
+ORDER BY start_time,
+    id
+LIMIT sqlc.narg(max_results)::INT OFFSET sqlc.arg(skip)::INT;
+
+-- name: ListTranscriptGuilds :many
+SELECT guild_id,
+    COUNT(*)::BIGINT AS sentence_count,
+    MIN(start_time)::TIMESTAMPTZ AS first_spoken,
+    MAX(start_time)::TIMESTAMPTZ AS last_spoken
+FROM transcript_sentences
+WHERE transcript_session_visible(session_id, NULL::INT)
+GROUP BY guild_id
+ORDER BY last_spoken DESC;
+
+-- name: ListTranscriptChannels :many
+SELECT channel_id,
+    COUNT(*)::BIGINT AS sentence_count,
+    MIN(start_time)::TIMESTAMPTZ AS first_spoken,
+    MAX(start_time)::TIMESTAMPTZ AS last_spoken
+FROM transcript_sentences
+WHERE guild_id = sqlc.arg(guild_id)
+    AND transcript_session_visible(session_id, NULL::INT)
+GROUP BY channel_id
+ORDER BY last_spoken DESC;
+
+-- name: ListTranscriptDays :many
+SELECT (start_time AT TIME ZONE 'UTC')::DATE AS day,
+    COUNT(*)::BIGINT AS sentence_count,
+    MIN(start_time)::TIMESTAMPTZ AS first_spoken,
+    MAX(start_time)::TIMESTAMPTZ AS last_spoken
+FROM transcript_sentences
+WHERE guild_id = sqlc.arg(guild_id)
+    AND channel_id = sqlc.arg(channel_id)
+    AND transcript_session_visible(session_id, NULL::INT)
+GROUP BY day
+ORDER BY day DESC;
+
+-- name: SearchTranscripts :many
+SELECT ts.id AS sentence_id,
+    ts.session_id,
+    ts.guild_id,
+    ts.channel_id,
+    ts.user_id,
+    ts.start_time,
+    ts.end_time,
+    ts.content,
+    ts_headline(
+        'english',
+        ts.content,
+        websearch_to_tsquery('english', sqlc.arg(query)::TEXT),
+        'StartSel=<<, StopSel=>>, HighlightAll=true'
+    )::TEXT AS headline,
+    ts_rank(
+        ts.search,
+        websearch_to_tsquery('english', sqlc.arg(query)::TEXT)
+    )::REAL AS rank,
+    COALESCE(before.user_id, '')::TEXT AS before_user_id,
+    COALESCE(before.content, '')::TEXT AS before_content,
+    COALESCE(after.user_id, '')::TEXT AS after_user_id,
+    COALESCE(after.content, '')::TEXT AS after_content
+FROM transcript_sentences ts
+    LEFT JOIN LATERAL (
+        SELECT p.user_id,
+            p.content
+        FROM transcript_sentences p
+        WHERE p.guild_id = ts.guild_id
+            AND p.channel_id = ts.channel_id
+            AND p.id <> ts.id
+            AND p.start_time <= ts.start_time
+            AND p.start_time > ts.start_time - INTERVAL '2 minutes'
+            AND transcript_session_visible(p.session_id, sqlc.narg(version)::INT)
+        ORDER BY p.start_time DESC,
+            p.id DESC
+        LIMIT 1
+    ) before ON TRUE
+    LEFT JOIN LATERAL (
+        SELECT n.user_id,
+            n.content
+        FROM transcript_sentences n
+        WHERE n.guild_id = ts.guild_id
+            AND n.channel_id = ts.channel_id
+            AND n.id <> ts.id
+            AND n.start_time >= ts.start_time
+            AND n.start_time < ts.start_time + INTERVAL '2 minutes'
+            AND transcript_session_visible(n.session_id, sqlc.narg(version)::INT)
+        ORDER BY n.start_time,
+            n.id
+        LIMIT 1
+    ) after ON TRUE
+WHERE ts.search @@ websearch_to_tsquery('english', sqlc.arg(query)::TEXT)
+    AND (
+        sqlc.narg(user_id)::TEXT IS NULL
+        OR ts.user_id = sqlc.narg(user_id)::TEXT
+    )
+    AND (
+        sqlc.narg(guild_id)::TEXT IS NULL
+        OR ts.guild_id = sqlc.narg(guild_id)::TEXT
+    )
+    AND (
+        sqlc.narg(guild_ids)::TEXT [] IS NULL
+        OR ts.guild_id = ANY(sqlc.narg(guild_ids)::TEXT [])
+    )
+    AND (
+        sqlc.narg(since)::TIMESTAMPTZ IS NULL
+        OR ts.start_time >= sqlc.narg(since)::TIMESTAMPTZ
+    )
+    AND transcript_session_visible(ts.session_id, sqlc.narg(version)::INT)
+ORDER BY ts.start_time DESC
+LIMIT sqlc.arg(max_results)::INT;
+
+-- name: ListTranscriptionSessions :many
+SELECT *
+FROM transcription_sessions
+WHERE (
+        sqlc.narg(guild_id)::TEXT IS NULL
+        OR guild_id = sqlc.narg(guild_id)::TEXT
+    )
+    AND (
+        sqlc.narg(guild_ids)::TEXT [] IS NULL
+        OR guild_id = ANY(sqlc.narg(guild_ids)::TEXT [])
+    )
+    AND (
+        sqlc.narg(channel_id)::TEXT IS NULL
+        OR channel_id = sqlc.narg(channel_id)::TEXT
+    )
+    AND (
+        sqlc.narg(user_id)::TEXT IS NULL
+        OR user_id = sqlc.narg(user_id)::TEXT
+    )
+    AND (
+        sqlc.narg(from_time)::TIMESTAMPTZ IS NULL
+        OR start_time >= sqlc.narg(from_time)::TIMESTAMPTZ
+    )
+    AND (
+        sqlc.narg(to_time)::TIMESTAMPTZ IS NULL
+        OR start_time < sqlc.narg(to_time)::TIMESTAMPTZ
+    )
+    AND transcript_session_visible(id, sqlc.narg(version)::INT)
+ORDER BY start_time DESC,
+    id DESC
+LIMIT sqlc.arg(max_results)::INT OFFSET sqlc.arg(skip)::INT;
+
+-- name: GetTranscriptionSession :one
+SELECT *
+FROM transcription_sessions
+WHERE id = $1;
+
+-- name: ListTranscriptionSegments :many
+SELECT id,
+    session_id,
+    is_final,
+    version,
+    content,
+    start_time,
+    end_time,
+    created_at
+FROM transcription_segments
+WHERE session_id = sqlc.arg(session_id)
+ORDER BY id
+LIMIT sqlc.arg(max_results)::INT OFFSET sqlc.arg(skip)::INT;
+
+-- name: GetTranscriptionSegment :one
+SELECT id,
+    session_id,
+    is_final,
+    version,
+    content,
+    start_time,
+    end_time,
+    created_at
+FROM transcription_segments
+WHERE id = $1;
+
+-- name: ListSegmentVersions :many
+SELECT version,
+    COUNT(*)::BIGINT AS word_count,
+    MIN(created_at)::TIMESTAMPTZ AS created_at
+FROM transcription_words
+WHERE segment_id = $1
+GROUP BY version
+ORDER BY version;
+
+-- name: GetSegmentWords :many
+SELECT tw.id AS word_id,
+    tw.version,
+    (s.start_time + tw.start_time)::TIMESTAMPTZ AS start_time,
+    (s.start_time + tw.start_time + tw.duration)::TIMESTAMPTZ AS end_time,
+    tw.is_eos,
+    tw.attaches_to,
+    wa.content,
+    wa.confidence,
+    correction.content AS corrected_content
+FROM transcription_words tw
+    JOIN transcription_segments ts ON ts.id = tw.segment_id
+    JOIN transcription_sessions s ON s.id = ts.session_id
+    JOIN word_alternatives wa ON wa.word_id = tw.id
+    LEFT JOIN LATERAL (
+        SELECT tc.content
+        FROM transcript_corrections tc
+        WHERE tc.word_id = tw.id
+        ORDER BY tc.id DESC
+        LIMIT 1
+    ) correction ON TRUE
+WHERE tw.segment_id = sqlc.arg(segment_id)
+    AND tw.version = COALESCE(sqlc.narg(version)::INT, ts.version)
+ORDER BY tw.start_time,
+    tw.id,
+    wa.confidence DESC;
+
+-- name: GetGuildVoiceActivityReport :many
+SELECT u.user_id,
+    COUNT(DISTINCT op.id) AS packet_count,
+    MIN(op.created_at)::TIMESTAMPTZ AS first_packet,
+    MAX(op.created_at)::TIMESTAMPTZ AS last_packet,
+    SUM(LENGTH(op.opus_data)) AS total_bytes
+FROM opus_packets op
+    JOIN ssrc_mappings u ON op.ssrc = u.ssrc
+    AND op.guild_id = u.guild_id
+    AND op.channel_id = u.channel_id
+    AND op.session_id = u.session_id
+WHERE op.guild_id = sqlc.arg(guild_id)
+    AND op.created_at BETWEEN sqlc.arg(from_time) AND sqlc.arg(to_time)
+GROUP BY u.user_id
+ORDER BY packet_count DESC;
+
+-- name: UpsertWebUser :exec
+INSERT INTO web_users (id, username)
+VALUES ($1, $2) ON CONFLICT (id) DO
+UPDATE
+SET username = EXCLUDED.username,
+    last_login_at = CURRENT_TIMESTAMP;
+
+-- name: GetWebUser :one
+SELECT *
+FROM web_users
+WHERE id = $1;
+
+-- name: SetWebUserGuilds :exec
+WITH removed AS (
+    DELETE FROM web_user_guilds
+    WHERE user_id = sqlc.arg(user_id)
+        AND guild_id <> ALL(sqlc.arg(guild_ids)::TEXT [])
+)
+INSERT INTO web_user_guilds (user_id, guild_id)
+SELECT sqlc.arg(user_id),
+    unnest(sqlc.arg(guild_ids)::TEXT []) ON CONFLICT DO NOTHING;
+
+-- name: ListWebUserGuilds :many
+SELECT guild_id
+FROM web_user_guilds
+WHERE user_id = $1
+ORDER BY guild_id;
+
+-- name: CreateWebSession :exec
+INSERT INTO web_sessions (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: GetWebSessionUser :one
+SELECT u.*
+FROM web_sessions s
+    JOIN web_users u ON u.id = s.user_id
+WHERE s.token_hash = $1
+    AND s.expires_at > CURRENT_TIMESTAMP;
+
+-- name: DeleteWebSession :exec
+DELETE FROM web_sessions
+WHERE token_hash = $1;
+
+-- name: DeleteExpiredWebSessions :exec
+DELETE FROM web_sessions
+WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_hash)
+VALUES ($1, $2, $3)
+RETURNING id;
+
+-- name: GetAPITokenUser :one
+SELECT t.id AS token_id,
+    u.*
+FROM api_tokens t
+    JOIN web_users u ON u.id = t.user_id
+WHERE t.token_hash = $1
+    AND t.revoked_at IS NULL;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- name: ListAPITokens :many
+SELECT id,
+    user_id,
+    name,
+    created_at,
+    last_used_at,
+    revoked_at
+FROM api_tokens
+WHERE sqlc.narg(user_id)::TEXT IS NULL
+    OR user_id = sqlc.narg(user_id)::TEXT
+ORDER BY id;
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1
+    AND revoked_at IS NULL;
+
+-- name: GetSentenceWords :many
+SELECT w.segment_id,
+    w.sentence_index,
+    w.id AS word_id,
+    (s.start_time + w.start_time)::TIMESTAMPTZ AS start_time,
+    (s.start_time + w.start_time + w.duration)::TIMESTAMPTZ AS end_time,
+    w.attaches_to,
+    w.confidence,
+    w.content
+FROM current_transcript_words w
+    JOIN transcription_segments seg ON seg.id = w.segment_id
+    JOIN transcription_sessions s ON s.id = seg.session_id
+WHERE w.segment_id = ANY(sqlc.arg(segment_ids)::BIGINT [])
+ORDER BY w.segment_id,
+    w.start_time,
+    w.id;
+
+-- name: GetTranscriptSentence :one
+SELECT *
+FROM transcript_sentences
+WHERE id = $1;
+
+-- name: GetTranscriptSentenceAt :one
+SELECT *
+FROM transcript_sentences
+WHERE segment_id = $1
+    AND sentence_index = $2;
+
+-- name: GetCurrentWord :one
+SELECT w.id,
+    w.segment_id,
+    w.sentence_index,
+    w.attaches_to,
+    w.content,
+    s.guild_id,
+    s.channel_id
+FROM current_transcript_words w
+    JOIN transcription_segments seg ON seg.id = w.segment_id
+    JOIN transcription_sessions s ON s.id = seg.session_id
+WHERE w.segment_id = (
+        SELECT segment_id
+        FROM transcription_words
+        WHERE transcription_words.id = sqlc.arg(word_id)
+    )
+    AND w.id = sqlc.arg(word_id);
+
+-- name: InsertTranscriptCorrection :one
+INSERT INTO transcript_corrections (
+        segment_id,
+        segment_version,
+        sentence_index,
+        word_id,
+        content,
+        previous_content,
+        author_id
+    )
+SELECT ts.id,
+    COALESCE(tw.version, ts.version),
+    sqlc.arg(sentence_index)::INT,
+    sqlc.narg(word_id)::BIGINT,
+    sqlc.arg(content)::TEXT,
+    sqlc.arg(previous_content)::TEXT,
+    sqlc.arg(author_id)::TEXT
+FROM transcription_segments ts
+    LEFT JOIN transcription_words tw ON tw.id = sqlc.narg(word_id)::BIGINT
+WHERE ts.id = sqlc.arg(segment_id)
+RETURNING id;
+
+-- name: ListTranscriptCorrections :many
+SELECT tc.*,
+    (tc.segment_version <> ts.version)::BOOLEAN AS outdated
+FROM transcript_corrections tc
+    JOIN transcription_segments ts ON ts.id = tc.segment_id
+WHERE tc.segment_id = $1
+    AND tc.sentence_index = $2
+ORDER BY tc.id DESC;
+
+-- name: UpsertGuildVocabulary :exec
+INSERT INTO guild_vocabulary (guild_id, content)
+VALUES ($1, $2) ON CONFLICT (guild_id, content) DO
+UPDATE
+SET uses = guild_vocabulary.uses + 1,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: ListGuildVocabulary :many
+SELECT *
+FROM guild_vocabulary
+WHERE guild_id = $1
+ORDER BY uses DESC,
+    updated_at DESC,
+    content
+LIMIT sqlc.arg(max_results)::INT;
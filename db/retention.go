@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	DefaultPruneBatchSize  = 5000
+	defaultPruneBatchPause = 100 * time.Millisecond
+)
+
+// Pruner deletes raw audio older than each guild's retention period.
+// Transcripts are kept regardless. Whole day partitions are dropped once
+// every guild in them is past retention; what remains, such as audio of
+// guilds with shorter retention than their neighbours, is deleted in
+// small batches, each its own statement, so that no lock is held for
+// long and the bot can keep inserting while a prune runs.
+type Pruner struct {
+	Queries          *Queries
+	DefaultRetention time.Duration // For guilds without a policy; zero keeps audio forever
+	BatchSize        int32
+	BatchPause       time.Duration
+}
+
+// PruneResult summarizes a prune. Bytes counts the full size of dropped
+// partitions plus the Opus payloads deleted from the others; Postgres
+// reuses the latter after vacuuming rather than shrinking the table, so
+// the table size may not drop by as much.
+type PruneResult struct {
+	Partitions      int
+	Packets         int64
+	Bytes           int64
+	TableSizeBefore int64
+	TableSizeAfter  int64
+}
+
+// RetentionDays converts a retention period in days to a duration.
+func RetentionDays(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Prune deletes every packet that is past its guild's retention period
+// as of now.
+func (p *Pruner) Prune(ctx context.Context, now time.Time) (PruneResult, error) {
+	var result PruneResult
+
+	size, err := p.Queries.GetOpusPacketsTableSize(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to get table size: %w", err)
+	}
+	result.TableSizeBefore = size
+
+	policies, err := p.Queries.ListGuildRetentionPolicies(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	retention := make(map[string]int32, len(policies))
+	for _, policy := range policies {
+		retention[policy.GuildID] = policy.AudioRetentionDays
+	}
+	cutoff := func(guildID string) (time.Time, bool) {
+		if days, ok := retention[guildID]; ok {
+			if days == 0 {
+				return time.Time{}, false
+			}
+			return now.Add(-RetentionDays(int(days))), true
+		}
+		if p.DefaultRetention == 0 {
+			return time.Time{}, false
+		}
+		return now.Add(-p.DefaultRetention), true
+	}
+
+	if err := p.dropExpiredPartitions(ctx, now, cutoff, &result); err != nil {
+		return result, err
+	}
+
+	for _, policy := range policies {
+		if policy.AudioRetentionDays == 0 {
+			continue
+		}
+		cutoff := now.Add(-RetentionDays(int(policy.AudioRetentionDays)))
+		err := p.inBatches(ctx, &result, func() (int64, int64, error) {
+			row, err := p.Queries.PruneGuildOpusPackets(
+				ctx,
+				PruneGuildOpusPacketsParams{
+					GuildID:   policy.GuildID,
+					Cutoff:    pgtype.Timestamptz{Time: cutoff, Valid: true},
+					BatchSize: p.batchSize(),
+				},
+			)
+			return row.Packets, row.Bytes, err
+		})
+		if err != nil {
+			return result, fmt.Errorf(
+				"failed to prune guild %s: %w",
+				policy.GuildID,
+				err,
+			)
+		}
+	}
+
+	if p.DefaultRetention > 0 {
+		cutoff := now.Add(-p.DefaultRetention)
+		err := p.inBatches(ctx, &result, func() (int64, int64, error) {
+			row, err := p.Queries.PruneDefaultOpusPackets(
+				ctx,
+				PruneDefaultOpusPacketsParams{
+					Cutoff:    pgtype.Timestamptz{Time: cutoff, Valid: true},
+					BatchSize: p.batchSize(),
+				},
+			)
+			return row.Packets, row.Bytes, err
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to prune other guilds: %w", err)
+		}
+	}
+
+	size, err = p.Queries.GetOpusPacketsTableSize(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to get table size: %w", err)
+	}
+	result.TableSizeAfter = size
+
+	return result, nil
+}
+
+// Run prunes every interval until the context is canceled.
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := p.Prune(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Error("Failed to prune opus packets", "error", err)
+		} else if result.Packets > 0 {
+			log.Info(
+				"Pruned opus packets",
+				"partitions", result.Partitions,
+				"packets", result.Packets,
+				"bytes", result.Bytes,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// inBatches calls prune until it deletes less than a full batch,
+// pausing between batches to leave room for other work.
+func (p *Pruner) inBatches(
+	ctx context.Context,
+	result *PruneResult,
+	prune func() (packets int64, bytes int64, err error),
+) error {
+	for {
+		packets, bytes, err := prune()
+		if err != nil {
+			return err
+		}
+		result.Packets += packets
+		result.Bytes += bytes
+
+		if packets < int64(p.batchSize()) {
+			return nil
+		}
+
+		pause := p.BatchPause
+		if pause == 0 {
+			pause = defaultPruneBatchPause
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
+
+func (p *Pruner) batchSize() int32 {
+	if p.BatchSize <= 0 {
+		return DefaultPruneBatchSize
+	}
+	return p.BatchSize
+}
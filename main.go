@@ -11,8 +11,10 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"node.town/archive"
 	"node.town/bot"
 	"node.town/snd"
 	"node.town/tts"
@@ -35,8 +37,6 @@ import (
 	"node.town/speechmatics"
 )
 
-var pgPool *pgxpool.Pool
-
 func initConfig() {
 	viper.SetConfigFile(".env")
 	viper.AutomaticEnv()
@@ -46,10 +46,6 @@ func initConfig() {
 	}
 }
 
-func initPgPool() (*pgxpool.Pool, *db.Queries, error) {
-	return db.OpenDatabase()
-}
-
 func handleError(err error, message string) {
 	if err != nil {
 		log.Fatal(message, "error", err)
@@ -78,16 +74,32 @@ var listenCmd = &cobra.Command{
 
 		discord.LogLevel = discordgo.LogInformational
 
+		// The session is recorded before the gateway opens, as handlers
+		// save voice connections under it as soon as guilds arrive
+		user, err := discord.User("@me")
+		handleError(err, "Error looking up the bot user")
+
+		sessionID, err := queries.InsertDiscordSession(
+			context.Background(),
+			user.ID,
+		)
+		handleError(err, "Failed to insert discord session")
+
 		bot := &bot.Bot{
-			Discord: discord,
-			Queries: queries,
+			Discord:     discord,
+			Queries:     queries,
+			Connections: bot.NewConnectionManager(),
+			SessionID:   sessionID,
 		}
+		bot.ClearStaleConnections(context.Background(), user.ID)
 
 		discord.AddHandler(bot.HandleEvent)
 		discord.AddHandler(bot.HandleGuildCreate)
 		discord.AddHandler(bot.HandleVoiceStateUpdate)
 		discord.AddHandler(bot.HandleVoiceServerUpdate)
 		discord.AddHandler(bot.HandleInteractionCreate)
+		discord.AddHandler(bot.HandleDisconnect)
+		discord.AddHandler(bot.HandleConnect)
 
 		err = discord.Open()
 		handleError(err, "Error opening Discord session")
@@ -101,49 +113,57 @@ var listenCmd = &cobra.Command{
 
 		log.Info("discord", "status", discord.State.User.Username)
 
-		// Insert a record into the discord_sessions table
-		sessionID, err := bot.Queries.InsertDiscordSession(
-			context.Background(),
-			db.InsertDiscordSessionParams{
-				BotToken: viper.GetString("DISCORD_TOKEN"),
-				UserID:   discord.State.User.ID,
-			},
-		)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-		if err != nil {
-			log.Error("Failed to insert discord session", "error", err)
+		go db.MaintainPartitions(ctx, queries)
+
+		pruneInterval, _ := cmd.Flags().GetDuration("prune-interval")
+		if pruneInterval > 0 {
+			go newPruner(queries).Run(ctx, pruneInterval)
 		}
-		bot.SessionID = sessionID
 
 		// wait for CTRL-C
 		log.Info("Jamie is now listening. Press CTRL-C to exit.")
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, os.Interrupt)
 		<-sig
+
+		bot.StopSupervising(context.Background())
 	},
 }
 
 var listenPacketsCmd = &cobra.Command{
 	Use:   "packets",
 	Short: "Listen for new opus packets",
-	Long:  `This command listens for new opus packets and prints information about each new packet.`,
+	Long:  `This command listens for new opus packets and prints information about each new packet. With --file it reads them from a capture or Ogg file instead, without a database.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		sqlDB, queries, err := db.OpenDatabase()
-		if err != nil {
-			log.Fatal("Failed to open database", "error", err)
-		}
-
 		ctx := context.Background()
-		defer sqlDB.Close()
 
-		pool, err := pgxpool.New(ctx, viper.GetString("DATABASE_URL"))
-		if err != nil {
-			log.Fatal("Failed to create connection pool", "error", err)
+		var streamer snd.PacketStreamer
+		var cache snd.UserIDCache
+		if path, _ := cmd.Flags().GetString("file"); path != "" {
+			speed, _ := cmd.Flags().GetFloat64("speed")
+			fileStreamer := snd.NewFilePacketStreamer(
+				path,
+				log.Default(),
+				snd.FileStreamOptions{Speed: speed},
+			)
+			streamer, cache = fileStreamer, fileStreamer
+		} else {
+			pool, queries, err := db.OpenDatabase()
+			if err != nil {
+				log.Fatal("Failed to open database", "error", err)
+			}
+			defer pool.Close()
+
+			ssrcCache := snd.NewSSRCUserIDCache(queries)
+			err = ssrcCache.ListenForChanges(ctx, pool, log.Default())
+			handleError(err, "Failed to listen for SSRC mapping changes")
+			cache = ssrcCache
+			streamer = snd.NewPostgresPacketStreamer(pool, cache, log.Default())
 		}
-		defer pool.Close()
 
-		cache := snd.NewSSRCUserIDCache(queries)
-		streamer := snd.NewPostgresPacketStreamer(pool, cache, log.Default())
 		packetChan, err := snd.StreamOpusPackets(ctx, streamer)
 		if err != nil {
 			log.Fatal("Error setting up opus packet stream", "error", err)
@@ -156,8 +176,12 @@ var listenPacketsCmd = &cobra.Command{
 			"Listening for demuxed Opus packet streams. Press CTRL-C to exit.",
 		)
 
+		var wg sync.WaitGroup
 		for stream := range streamChan {
+			wg.Add(1)
 			go func(s <-chan snd.OpusPacketNotification) {
+				defer wg.Done()
+
 				var lastPrintTime time.Time
 				packetCount := 0
 
@@ -179,6 +203,51 @@ var listenPacketsCmd = &cobra.Command{
 				}
 			}(stream)
 		}
+		wg.Wait()
+	},
+}
+
+var captureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Record live opus packets to a capture file",
+	Long:  `This command records new opus packets to a JSON-lines capture file until interrupted, so that "packets" and "transcribe" can later be run on them with --file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		duration, _ := cmd.Flags().GetDuration("duration")
+
+		pool, queries, err := db.OpenDatabase()
+		handleError(err, "Failed to open database")
+		defer pool.Close()
+
+		file, err := os.Create(output)
+		handleError(err, "Failed to create capture file")
+		defer file.Close()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		if duration > 0 {
+			ctx, cancel = context.WithTimeout(ctx, duration)
+			defer cancel()
+		}
+
+		cache := snd.NewSSRCUserIDCache(queries)
+		err = cache.ListenForChanges(ctx, pool, log.Default())
+		handleError(err, "Failed to listen for SSRC mapping changes")
+		streamer := snd.NewPostgresPacketStreamer(pool, cache, log.Default())
+		packetChan, err := snd.StreamOpusPackets(ctx, streamer)
+		handleError(err, "Error setting up opus packet stream")
+
+		log.Info("Capturing opus packets. Press CTRL-C to stop.", "output", output)
+
+		writer := snd.NewCaptureWriter(file)
+		count := 0
+		for packet := range packetChan {
+			err := writer.Write(packet)
+			handleError(err, "Failed to write capture")
+			count++
+		}
+
+		log.Info("Capture finished", "packets", count, "output", output)
 	},
 }
 
@@ -303,11 +372,25 @@ var packetInfoCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(listenCmd)
+	listenCmd.Flags().
+		Duration("prune-interval", 0, "Prune audio past its retention period this often (0 to disable)")
 	rootCmd.AddCommand(listenPacketsCmd)
+	listenPacketsCmd.Flags().
+		String("file", "", "Read packets from a capture or Ogg file instead of the database")
+	listenPacketsCmd.Flags().
+		Float64("speed", 1, "Pace of --file, 1 for real time, 0 for as fast as possible")
+	rootCmd.AddCommand(captureCmd)
+	captureCmd.Flags().
+		StringP("output", "o", "capture.jsonl", "Capture file path")
+	captureCmd.Flags().
+		Duration("duration", 0, "Stop after this long (0 to run until interrupted)")
 	rootCmd.AddCommand(packetInfoCmd)
 	rootCmd.AddCommand(tts.TranscribeCmd)
 	rootCmd.AddCommand(tts.StreamCmd)
 	rootCmd.AddCommand(tts.HTTPCmd)
+	rootCmd.AddCommand(tts.SearchCmd)
+	rootCmd.AddCommand(tts.ExportCmd)
+	rootCmd.AddCommand(tts.TokenCmd)
 
 	packetInfoCmd.Flags().Int64P("ssrc", "s", 0, "SSRC to filter packets")
 	packetInfoCmd.Flags().
@@ -331,6 +414,321 @@ func init() {
 		StringP("end", "e", time.Now().Format(time.RFC3339), "End time (RFC3339 format)")
 
 	rootCmd.AddCommand(reportCmd)
+
+	recordingsCmd := &cobra.Command{
+		Use:   "recordings",
+		Short: "List active voice recordings",
+		Long:  `This command lists the voice channels the bot is recording in, across all guilds.`,
+		Run:   runRecordings,
+	}
+
+	rootCmd.AddCommand(recordingsCmd)
+
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the database schema",
+	}
+	dbCmd.AddCommand(&cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending migrations",
+		Run:   runDBMigrate,
+	})
+	dbCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "List migrations and whether they are applied",
+		Run:   runDBStatus,
+	})
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Revert the most recently applied migrations",
+		Run:   runDBRollback,
+	}
+	rollbackCmd.Flags().IntP("steps", "n", 1, "Number of migrations to revert")
+	dbCmd.AddCommand(rollbackCmd)
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete raw audio past its retention period",
+		Long:  `This command deletes opus packets older than each guild's audio retention period. Transcripts are kept.`,
+		Run:   runDBPrune,
+	}
+	pruneCmd.Flags().
+		Int32("batch-size", db.DefaultPruneBatchSize, "Packets to delete per statement")
+	dbCmd.AddCommand(pruneCmd)
+
+	retentionCmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Show or set per-guild audio retention",
+		Long:  `Without flags, this command lists audio retention policies. With --guild and --days it sets a guild's policy, where 0 days keeps audio forever; --reset returns the guild to the AUDIO_RETENTION_DAYS default.`,
+		Run:   runDBRetention,
+	}
+	retentionCmd.Flags().StringP("guild", "g", "", "Guild ID")
+	retentionCmd.Flags().IntP("days", "d", -1, "Days of audio to keep (0 keeps forever)")
+	retentionCmd.Flags().Bool("reset", false, "Remove the guild's policy")
+	dbCmd.AddCommand(retentionCmd)
+
+	rootCmd.AddCommand(dbCmd)
+
+	archiveCmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Move completed sessions' audio into Ogg archives",
+		Long:  `This command rolls the opus packets of completed sessions into one Ogg file per speaker, stores them in a local directory or S3-compatible bucket, and deletes the archived packets from the database.`,
+		Run:   runArchive,
+	}
+	archiveCmd.Flags().
+		Int32("session", 0, "Only archive this Discord session (default all completed streams)")
+	archiveCmd.Flags().
+		String("to", "", "Archive directory, file:// or s3://bucket/prefix URL (default $ARCHIVE_URL or ./archive)")
+
+	rootCmd.AddCommand(archiveCmd)
+}
+
+func runArchive(cmd *cobra.Command, args []string) {
+	sessionID, _ := cmd.Flags().GetInt32("session")
+	to, _ := cmd.Flags().GetString("to")
+	if to == "" {
+		to = viper.GetString("ARCHIVE_URL")
+	}
+	if to == "" {
+		to = "archive"
+	}
+
+	pool, queries, err := db.OpenDatabase()
+	handleError(err, "Failed to open database")
+	defer pool.Close()
+
+	store, err := archive.OpenStore(to)
+	handleError(err, "Failed to open archive store")
+
+	archiver := &archive.Archiver{
+		Pool:    pool,
+		Queries: queries,
+		Store:   store,
+	}
+	result, err := archiver.ArchiveCompleted(context.Background(), sessionID)
+	handleError(err, "Error archiving sessions")
+
+	fmt.Printf(
+		"Archived %d packets from %d streams into %.1f MB of Ogg files.\n",
+		result.Packets,
+		result.Streams,
+		float64(result.Bytes)/(1<<20),
+	)
+}
+
+// openMigrator connects without migrating, so that the db commands can
+// inspect and roll back the schema.
+func openMigrator() (*pgxpool.Pool, *db.Migrator) {
+	pool, err := db.Connect(context.Background())
+	handleError(err, "Failed to open database")
+
+	migrator, err := db.NewMigrator(pool)
+	handleError(err, "Failed to load migrations")
+
+	return pool, migrator
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) {
+	pool, migrator := openMigrator()
+	defer pool.Close()
+
+	applied, err := migrator.Migrate(context.Background())
+	handleError(err, "Error applying migrations")
+
+	if len(applied) == 0 {
+		fmt.Println("Database is up to date.")
+		return
+	}
+	for _, m := range applied {
+		fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+	}
+}
+
+func runDBStatus(cmd *cobra.Command, args []string) {
+	pool, migrator := openMigrator()
+	defer pool.Close()
+
+	statuses, err := migrator.Status(context.Background())
+	handleError(err, "Error reading migration status")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Version", "Name", "Applied"})
+
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		table.Append([]string{
+			fmt.Sprintf("%04d", s.Version),
+			s.Name,
+			applied,
+		})
+	}
+
+	table.Render()
+}
+
+// newPruner builds a pruner using AUDIO_RETENTION_DAYS for guilds
+// without a retention policy.
+func newPruner(queries *db.Queries) *db.Pruner {
+	return &db.Pruner{
+		Queries:          queries,
+		DefaultRetention: db.RetentionDays(viper.GetInt("AUDIO_RETENTION_DAYS")),
+	}
+}
+
+func runDBPrune(cmd *cobra.Command, args []string) {
+	batchSize, _ := cmd.Flags().GetInt32("batch-size")
+
+	sqlDB, queries, err := db.OpenDatabase()
+	handleError(err, "Failed to open database")
+	defer sqlDB.Close()
+
+	pruner := newPruner(queries)
+	pruner.BatchSize = batchSize
+
+	result, err := pruner.Prune(context.Background(), time.Now())
+	handleError(err, "Error pruning opus packets")
+
+	fmt.Printf(
+		"Deleted %d packets (%.1f MB), dropping %d day partitions.\n",
+		result.Packets,
+		float64(result.Bytes)/(1<<20),
+		result.Partitions,
+	)
+	fmt.Printf(
+		"opus_packets is %.1f MB, was %.1f MB; freed space is reused after vacuum.\n",
+		float64(result.TableSizeAfter)/(1<<20),
+		float64(result.TableSizeBefore)/(1<<20),
+	)
+}
+
+func runDBRetention(cmd *cobra.Command, args []string) {
+	guildID, _ := cmd.Flags().GetString("guild")
+	days, _ := cmd.Flags().GetInt("days")
+	reset, _ := cmd.Flags().GetBool("reset")
+
+	sqlDB, queries, err := db.OpenDatabase()
+	handleError(err, "Failed to open database")
+	defer sqlDB.Close()
+
+	ctx := context.Background()
+
+	switch {
+	case guildID != "" && reset:
+		err := queries.DeleteGuildRetentionPolicy(ctx, guildID)
+		handleError(err, "Error removing retention policy")
+	case guildID != "" && days >= 0:
+		err := queries.UpsertGuildRetentionPolicy(
+			ctx,
+			db.UpsertGuildRetentionPolicyParams{
+				GuildID:            guildID,
+				AudioRetentionDays: int32(days),
+			},
+		)
+		handleError(err, "Error saving retention policy")
+	case guildID != "" || days >= 0 || reset:
+		handleError(
+			errors.New("--guild needs --days or --reset"),
+			"Invalid flags",
+		)
+	}
+
+	policies, err := queries.ListGuildRetentionPolicies(ctx)
+	handleError(err, "Error listing retention policies")
+
+	describe := func(days int) string {
+		if days == 0 {
+			return "forever"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Guild", "Audio Kept", "Updated"})
+	for _, p := range policies {
+		table.Append([]string{
+			p.GuildID,
+			describe(int(p.AudioRetentionDays)),
+			p.UpdatedAt.Time.Format(time.RFC3339),
+		})
+	}
+	table.Append([]string{
+		"(default)",
+		describe(viper.GetInt("AUDIO_RETENTION_DAYS")),
+		"",
+	})
+	table.Render()
+}
+
+func runDBRollback(cmd *cobra.Command, args []string) {
+	steps, _ := cmd.Flags().GetInt("steps")
+
+	pool, migrator := openMigrator()
+	defer pool.Close()
+
+	reverted, err := migrator.Rollback(context.Background(), steps)
+	handleError(err, "Error rolling back migrations")
+
+	if len(reverted) == 0 {
+		fmt.Println("No migrations to roll back.")
+		return
+	}
+	for _, m := range reverted {
+		fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+	}
+}
+
+func runRecordings(cmd *cobra.Command, args []string) {
+	sqlDB, queries, err := db.OpenDatabase()
+	handleError(err, "Failed to open database")
+	defer sqlDB.Close()
+
+	connections, err := queries.ListBotVoiceConnections(
+		context.Background(),
+		pgtype.Timestamptz{
+			Time:  time.Now().Add(-db.VoiceConnectionStaleAfter),
+			Valid: true,
+		},
+	)
+	handleError(err, "Error listing voice connections")
+
+	if len(connections) == 0 {
+		fmt.Println("No active voice recordings.")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(
+		[]string{
+			"Guild",
+			"Channel",
+			"State",
+			"Joined",
+			"Packets",
+			"Last Packet",
+			"Updated",
+		},
+	)
+
+	for _, c := range connections {
+		lastPacket := "-"
+		if c.LastPacketAt.Valid {
+			lastPacket = c.LastPacketAt.Time.Format(time.RFC3339)
+		}
+		table.Append([]string{
+			fmt.Sprintf("%s (%s)", c.GuildName, c.GuildID),
+			fmt.Sprintf("%s (%s)", c.ChannelName, c.ChannelID),
+			c.State,
+			c.JoinedAt.Time.Format(time.RFC3339),
+			fmt.Sprintf("%d", c.PacketCount),
+			lastPacket,
+			time.Since(c.UpdatedAt.Time).Round(time.Second).String() + " ago",
+		})
+	}
+
+	table.Render()
 }
 
 func runReport(cmd *cobra.Command, args []string) {
@@ -385,6 +783,45 @@ func runReport(cmd *cobra.Command, args []string) {
 		endTime.Format(time.RFC3339),
 	)
 	table.Render()
+
+	interruptions, err := queries.GetRecordingInterruptions(
+		context.Background(),
+		db.GetRecordingInterruptionsParams{
+			StartTime: pgtype.Timestamptz{Time: startTime, Valid: true},
+			EndTime:   pgtype.Timestamptz{Time: endTime, Valid: true},
+		},
+	)
+	handleError(err, "Error loading recording interruptions")
+
+	if len(interruptions) == 0 {
+		return
+	}
+
+	gaps := tablewriter.NewWriter(os.Stdout)
+	gaps.SetHeader(
+		[]string{
+			"Guild ID",
+			"Channel ID",
+			"Started",
+			"Ended",
+			"Gap",
+			"Reason",
+		},
+	)
+
+	for _, i := range interruptions {
+		gaps.Append([]string{
+			i.GuildID,
+			i.ChannelID,
+			i.StartedAt.Time.Format(time.RFC3339),
+			i.EndedAt.Time.Format(time.RFC3339),
+			i.EndedAt.Time.Sub(i.StartedAt.Time).Round(time.Second).String(),
+			i.Reason,
+		})
+	}
+
+	fmt.Printf("\nRecording interruptions\n\n")
+	gaps.Render()
 }
 
 func uploadFile(
@@ -455,17 +892,6 @@ func convertOggToMp3(inputFile, outputFile string) error {
 func main() {
 	initConfig()
 
-	ctx := context.Background()
-	var err error
-	pgPool, _, err := func() (*pgxpool.Pool, *db.Queries, error) {
-		var _ context.Context = ctx
-		return initPgPool()
-	}()
-	if err != nil {
-		log.Fatal("Failed to initialize connection pool", "error", err)
-	}
-	defer pgPool.Close()
-
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal("Error executing root command", "error", err)
 	}
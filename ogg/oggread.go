@@ -0,0 +1,129 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	errBadPageSignature = errors.New("bad ogg page signature")
+	errBadPageChecksum  = errors.New("bad ogg page checksum")
+)
+
+// OggReader reads Opus packets back out of an Ogg container, such as
+// the ones written by OggWriter. Packets are reassembled from their
+// lacing segments, so they may span pages, and the OpusHead and
+// OpusTags header packets are skipped.
+type OggReader struct {
+	stream        io.Reader
+	checksumTable *[256]uint32
+	partial       []byte
+	pending       [][]byte
+}
+
+// NewReaderWith initializes a new Ogg Opus reader over an io.Reader
+func NewReaderWith(in io.Reader) (*OggReader, error) {
+	if in == nil {
+		return nil, errFileNotOpened
+	}
+
+	return &OggReader{
+		stream:        in,
+		checksumTable: generateChecksumTable(),
+	}, nil
+}
+
+// ReadPacket returns the next Opus audio packet, or io.EOF once the
+// stream is exhausted.
+func (r *OggReader) ReadPacket() ([]byte, error) {
+	for {
+		for len(r.pending) > 0 {
+			packet := r.pending[0]
+			r.pending = r.pending[1:]
+			if isHeaderPacket(packet) {
+				continue
+			}
+			return packet, nil
+		}
+
+		if err := r.readPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readPage reads one page and queues the packets it completes.
+func (r *OggReader) readPage() error {
+	header := make([]byte, pageHeaderSize)
+	if _, err := io.ReadFull(r.stream, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("truncated ogg page header: %w", err)
+		}
+		return err
+	}
+	if string(header[:4]) != pageHeaderSignature {
+		return errBadPageSignature
+	}
+
+	segmentTable := make([]byte, header[26])
+	if _, err := io.ReadFull(r.stream, segmentTable); err != nil {
+		return fmt.Errorf("truncated ogg segment table: %w", err)
+	}
+
+	payloadSize := 0
+	for _, lacing := range segmentTable {
+		payloadSize += int(lacing)
+	}
+	payload := make([]byte, payloadSize)
+	if _, err := io.ReadFull(r.stream, payload); err != nil {
+		return fmt.Errorf("truncated ogg page payload: %w", err)
+	}
+
+	if !r.validChecksum(header, segmentTable, payload) {
+		return errBadPageChecksum
+	}
+
+	offset := 0
+	for _, lacing := range segmentTable {
+		r.partial = append(r.partial, payload[offset:offset+int(lacing)]...)
+		offset += int(lacing)
+
+		// A lacing value below 255 terminates the packet.
+		if lacing < 255 {
+			if len(r.partial) > 0 {
+				r.pending = append(r.pending, r.partial)
+			}
+			r.partial = nil
+		}
+	}
+
+	return nil
+}
+
+func (r *OggReader) validChecksum(header, segmentTable, payload []byte) bool {
+	expected := binary.LittleEndian.Uint32(header[22:])
+
+	var checksum uint32
+	update := func(data []byte) {
+		for _, b := range data {
+			checksum = (checksum << 8) ^ r.checksumTable[byte(checksum>>24)^b]
+		}
+	}
+
+	// The checksum is computed with the checksum field zeroed.
+	update(header[:22])
+	update([]byte{0, 0, 0, 0})
+	update(header[26:])
+	update(segmentTable)
+	update(payload)
+
+	return checksum == expected
+}
+
+func isHeaderPacket(packet []byte) bool {
+	return bytes.HasPrefix(packet, []byte(idPageSignature)) ||
+		bytes.HasPrefix(packet, []byte(commentPageSignature))
+}
@@ -0,0 +1,80 @@
+package ogg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestOggReaderRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		{0xF8, 0xFF, 0xFE},
+		bytes.Repeat([]byte{0x01}, 255),
+		bytes.Repeat([]byte{0x02}, 600),
+		{0xFC, 0xFD, 0xFE},
+	}
+
+	var buf bytes.Buffer
+	writer, err := NewWith(&buf, 48000, 2)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for i, payload := range payloads {
+		err := writer.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				SequenceNumber: uint16(i + 1),
+				Timestamp:      uint32((i + 1) * 960),
+			},
+			Payload: payload,
+		})
+		if err != nil {
+			t.Fatalf("Failed to write packet %d: %v", i, err)
+		}
+	}
+
+	reader, err := NewReaderWith(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+
+	for i, expected := range payloads {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("Failed to read packet %d: %v", i, err)
+		}
+		if !bytes.Equal(packet, expected) {
+			t.Errorf(
+				"Packet %d mismatch.\nExpected: %d bytes\nGot: %d bytes",
+				i,
+				len(expected),
+				len(packet),
+			)
+		}
+	}
+
+	if _, err := reader.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF after last packet, got %v", err)
+	}
+}
+
+func TestOggReaderRejectsCorruptPage(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewWith(&buf, 48000, 2); err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xFF
+
+	reader, err := NewReaderWith(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	if _, err := reader.ReadPacket(); !errors.Is(err, errBadPageChecksum) {
+		t.Errorf("Expected checksum error, got %v", err)
+	}
+}
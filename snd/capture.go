@@ -0,0 +1,280 @@
+package snd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"node.town/ogg"
+)
+
+// CapturedPacket is one line of a packet capture. Captures are JSON
+// lines, one packet per line, in the order the packets were received.
+type CapturedPacket struct {
+	ID        int64     `json:"id"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	SessionID int32     `json:"session_id,omitempty"`
+	UserID    string    `json:"user_id"`
+	Ssrc      int64     `json:"ssrc"`
+	Sequence  int32     `json:"sequence"`
+	Timestamp int64     `json:"timestamp"`
+	CreatedAt time.Time `json:"created_at"`
+	OpusData  []byte    `json:"opus_data"`
+}
+
+// CaptureWriter records packets to a capture file.
+type CaptureWriter struct {
+	encoder *json.Encoder
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{encoder: json.NewEncoder(w)}
+}
+
+func (w *CaptureWriter) Write(packet OpusPacketNotification) error {
+	createdAt, err := time.Parse(time.RFC3339Nano, packet.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to parse createdAt: %w", err)
+	}
+
+	err = w.encoder.Encode(CapturedPacket{
+		ID:        packet.ID,
+		GuildID:   packet.GuildID,
+		ChannelID: packet.ChannelID,
+		SessionID: packet.SessionID,
+		UserID:    packet.UserID,
+		Ssrc:      packet.Ssrc,
+		Sequence:  packet.Sequence,
+		Timestamp: packet.Timestamp,
+		CreatedAt: createdAt,
+		OpusData:  []byte(packet.OpusData),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write captured packet: %w", err)
+	}
+	return nil
+}
+
+// FileStreamOptions configures a FilePacketStreamer.
+type FileStreamOptions struct {
+	// Speed scales the pace of the stream as for ReplayOptions.
+	Speed float64
+
+	// Ssrc, GuildID, ChannelID and UserID label the packets of an Ogg
+	// file, which carries none of them. Captures have their own.
+	Ssrc      int64
+	GuildID   string
+	ChannelID string
+	UserID    string
+}
+
+// FilePacketStreamer delivers packets from a capture written by
+// CaptureWriter or from an Ogg Opus file, so that the pipeline can run
+// without a bot or live voice traffic. Packets of an Ogg file are taken
+// to be 20 ms apart, starting when the stream starts.
+//
+// It also serves as the UserIDCache for its packets, answering from the
+// user IDs recorded in the capture.
+type FilePacketStreamer struct {
+	path    string
+	logger  Logger
+	options FileStreamOptions
+
+	mu      sync.RWMutex
+	userIDs map[int64]string
+}
+
+func NewFilePacketStreamer(
+	path string,
+	logger Logger,
+	options FileStreamOptions,
+) *FilePacketStreamer {
+	return &FilePacketStreamer{
+		path:    path,
+		logger:  logger,
+		options: options,
+		userIDs: make(map[int64]string),
+	}
+}
+
+// Get answers by SSRC alone, since a file holds one recording.
+func (s *FilePacketStreamer) Get(key SSRCKey) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userIDs[key.Ssrc], nil
+}
+
+func (s *FilePacketStreamer) Stream(
+	ctx context.Context,
+) (<-chan OpusPacketNotification, error) {
+	if s.options.Speed < 0 {
+		return nil, errors.New("speed must not be negative")
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet file: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, fmt.Errorf("failed to read packet file: %w", err)
+	}
+
+	var next func() (CapturedPacket, error)
+	if bytes.Equal(magic, []byte("OggS")) {
+		next, err = s.oggPackets(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else {
+		next = capturedPackets(reader)
+	}
+
+	packetChan := make(chan OpusPacketNotification)
+
+	go func() {
+		defer close(packetChan)
+		defer file.Close()
+
+		count, err := s.stream(ctx, next, packetChan)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error(
+				"Failed to stream packet file",
+				"path", s.path,
+				"packets", count,
+				"error", err,
+			)
+			return
+		}
+		s.logger.Info("Packet file finished", "path", s.path, "packets", count)
+	}()
+
+	return packetChan, nil
+}
+
+func (s *FilePacketStreamer) stream(
+	ctx context.Context,
+	next func() (CapturedPacket, error),
+	packetChan chan<- OpusPacketNotification,
+) (int, error) {
+	var first time.Time
+	started := time.Now()
+	count := 0
+
+	for {
+		packet, err := next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if first.IsZero() {
+			first = packet.CreatedAt
+		}
+		delay := time.Until(
+			started.Add(replayOffset(first, packet.CreatedAt, s.options.Speed)),
+		)
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+		}
+
+		if packet.UserID != "" {
+			s.mu.Lock()
+			s.userIDs[packet.Ssrc] = packet.UserID
+			s.mu.Unlock()
+		}
+
+		select {
+		case packetChan <- OpusPacketNotification{
+			ID:        packet.ID,
+			GuildID:   packet.GuildID,
+			ChannelID: packet.ChannelID,
+			SessionID: packet.SessionID,
+			UserID:    packet.UserID,
+			Ssrc:      packet.Ssrc,
+			Sequence:  packet.Sequence,
+			Timestamp: packet.Timestamp,
+			OpusData:  string(packet.OpusData),
+			CreatedAt: packet.CreatedAt.Format(time.RFC3339Nano),
+		}:
+			count++
+		case <-ctx.Done():
+			return count, ctx.Err()
+		}
+	}
+}
+
+// capturedPackets reads the packets of a capture one line at a time.
+func capturedPackets(r io.Reader) func() (CapturedPacket, error) {
+	decoder := json.NewDecoder(r)
+	return func() (CapturedPacket, error) {
+		var packet CapturedPacket
+		if err := decoder.Decode(&packet); err != nil {
+			if errors.Is(err, io.EOF) {
+				return packet, err
+			}
+			return packet, fmt.Errorf("failed to read captured packet: %w", err)
+		}
+		return packet, nil
+	}
+}
+
+// oggPackets reads the packets of an Ogg file, labelled as the options
+// say and laid out every 20 ms from now.
+func (s *FilePacketStreamer) oggPackets(
+	r io.Reader,
+) (func() (CapturedPacket, error), error) {
+	reader, err := ogg.NewReaderWith(r)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	ssrc := s.options.Ssrc
+	if ssrc == 0 {
+		ssrc = 1
+	}
+	i := 0
+
+	return func() (CapturedPacket, error) {
+		data, err := reader.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return CapturedPacket{}, err
+			}
+			return CapturedPacket{}, fmt.Errorf("failed to read Ogg packet: %w", err)
+		}
+
+		packet := CapturedPacket{
+			ID:        int64(i + 1),
+			GuildID:   s.options.GuildID,
+			ChannelID: s.options.ChannelID,
+			UserID:    s.options.UserID,
+			Ssrc:      ssrc,
+			Sequence:  int32(uint16(i)),
+			Timestamp: int64(uint32(i * 960)),
+			CreatedAt: start.Add(time.Duration(i) * OpusFrameDuration),
+			OpusData:  data,
+		}
+		i++
+		return packet, nil
+	}, nil
+}
@@ -0,0 +1,65 @@
+package snd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var packets []OpusPacketNotification
+	for i := 0; i < 3; i++ {
+		packets = append(packets, OpusPacketNotification{
+			ID:        int64(100 + i),
+			GuildID:   "guild",
+			ChannelID: "channel",
+			UserID:    "user",
+			Ssrc:      42,
+			Sequence:  int32(i),
+			Timestamp: int64(i * 960),
+			// Opus data is binary and need not be valid UTF-8
+			OpusData:  string([]byte{0xfc, 0xff, byte(i)}),
+			CreatedAt: start.Add(time.Duration(i) * OpusFrameDuration).Format(time.RFC3339Nano),
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewCaptureWriter(file)
+	for _, packet := range packets {
+		if err := writer.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	streamer := NewFilePacketStreamer(path, log.Default(), FileStreamOptions{})
+	packetChan, err := streamer.Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []OpusPacketNotification
+	for packet := range packetChan {
+		got = append(got, packet)
+	}
+
+	if !reflect.DeepEqual(got, packets) {
+		t.Errorf("Expected:\n%v\nGot:\n%v", packets, got)
+	}
+
+	userID, _ := streamer.Get(SSRCKey{Ssrc: 42})
+	if userID != "user" {
+		t.Errorf("Expected user ID %q for SSRC 42, got %q", "user", userID)
+	}
+}
@@ -0,0 +1,67 @@
+package snd
+
+import "time"
+
+// packetCursor tracks the last opus packet delivered, so packets are
+// passed on in ID order and each exactly once.
+//
+// IDs come from a sequence, so a missing ID means either a transaction
+// that has not committed yet or one that never will. A gap therefore
+// holds back everything after it until the missing rows show up. Packets
+// are inserted one per transaction, which commits right away, so once
+// the packet after a gap is commitHorizon old the missing ones are given
+// up on. gapTimeout, measured from when the gap was first seen, bounds
+// the wait should the clocks of the database and this process disagree.
+type packetCursor struct {
+	lastID        int64
+	lastAt        time.Time
+	gapSince      time.Time
+	gapTimeout    time.Duration
+	commitHorizon time.Duration
+}
+
+// packetPosition is where a fetched packet sits in the stream.
+type packetPosition struct {
+	ID        int64
+	CreatedAt time.Time
+}
+
+// ready takes the positions of fetched packets, in ascending ID order
+// and all after lastID, and returns how many of them can be delivered
+// now along with the number of IDs given up on to get there. It advances
+// lastID and lastAt past the packets it lets through.
+func (c *packetCursor) ready(
+	packets []packetPosition,
+	now time.Time,
+) (n int, skipped int64) {
+	for _, packet := range packets {
+		if packet.ID != c.lastID+1 {
+			if c.gapSince.IsZero() {
+				c.gapSince = now
+			}
+			if now.Sub(packet.CreatedAt) < c.commitHorizon &&
+				now.Sub(c.gapSince) < c.gapTimeout {
+				return n, skipped
+			}
+			skipped += packet.ID - c.lastID - 1
+		}
+
+		c.lastID = packet.ID
+		c.lastAt = packet.CreatedAt
+		c.gapSince = time.Time{}
+		n++
+	}
+
+	return n, skipped
+}
+
+// since returns the earliest creation time a packet after the cursor can
+// have. Packets are created in roughly ID order, and slack allows for
+// the ones that are not, so the time only narrows fetches down to the
+// recent partitions.
+func (c *packetCursor) since(now time.Time, slack time.Duration) time.Time {
+	if c.lastAt.IsZero() {
+		return now.Add(-slack)
+	}
+	return c.lastAt.Add(-slack)
+}
@@ -0,0 +1,141 @@
+package snd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketCursor(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		ids         []int64
+		after       time.Duration
+		createdAt   time.Time
+		wantN       int
+		wantSkipped int64
+		wantLastID  int64
+	}{
+		{
+			name:       "contiguous",
+			ids:        []int64{11, 12, 13},
+			wantN:      3,
+			wantLastID: 13,
+		},
+		{
+			name:       "gap holds back later packets",
+			ids:        []int64{11, 13, 14},
+			wantN:      1,
+			wantLastID: 11,
+		},
+		{
+			name:       "gap at start",
+			ids:        []int64{12, 13},
+			after:      100 * time.Millisecond,
+			wantN:      0,
+			wantLastID: 10,
+		},
+		{
+			name:        "gap past commit horizon",
+			ids:         []int64{12, 13},
+			after:       500 * time.Millisecond,
+			wantN:       2,
+			wantSkipped: 1,
+			wantLastID:  13,
+		},
+		{
+			name:        "gap given up on",
+			ids:         []int64{12, 13},
+			after:       2 * time.Second,
+			createdAt:   start.Add(time.Hour),
+			wantN:       2,
+			wantSkipped: 1,
+			wantLastID:  13,
+		},
+		{
+			name:       "empty",
+			wantN:      0,
+			wantLastID: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &packetCursor{
+				lastID:        10,
+				gapTimeout:    2 * time.Second,
+				commitHorizon: 500 * time.Millisecond,
+			}
+
+			createdAt := tt.createdAt
+			if createdAt.IsZero() {
+				createdAt = start
+			}
+			packets := make([]packetPosition, len(tt.ids))
+			for i, id := range tt.ids {
+				packets[i] = packetPosition{ID: id, CreatedAt: createdAt}
+			}
+
+			// The first look at a gap starts its timer
+			cursor.ready(packets, start)
+			cursor.lastID = 10
+
+			n, skipped := cursor.ready(packets, start.Add(tt.after))
+			if n != tt.wantN || skipped != tt.wantSkipped {
+				t.Errorf(
+					"Expected %d ready and %d skipped, got %d and %d",
+					tt.wantN, tt.wantSkipped, n, skipped,
+				)
+			}
+			if cursor.lastID != tt.wantLastID {
+				t.Errorf("Expected last ID %d, got %d", tt.wantLastID, cursor.lastID)
+			}
+		})
+	}
+}
+
+func TestPacketCursorGapFilled(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cursor := &packetCursor{
+		lastID:        10,
+		gapTimeout:    2 * time.Second,
+		commitHorizon: 500 * time.Millisecond,
+	}
+
+	later := packetPosition{ID: 12, CreatedAt: start}
+	if n, _ := cursor.ready([]packetPosition{later}, start); n != 0 {
+		t.Fatalf("Expected packet after gap to be held back, got %d ready", n)
+	}
+
+	// The missing packet commits before the horizon
+	missing := packetPosition{ID: 11, CreatedAt: start}
+	n, skipped := cursor.ready(
+		[]packetPosition{missing, later},
+		start.Add(100*time.Millisecond),
+	)
+	if n != 2 || skipped != 0 {
+		t.Errorf("Expected 2 ready and 0 skipped, got %d and %d", n, skipped)
+	}
+	if !cursor.gapSince.IsZero() {
+		t.Errorf("Expected gap timer to be reset")
+	}
+	if !cursor.lastAt.Equal(start) {
+		t.Errorf("Expected last time %s, got %s", start, cursor.lastAt)
+	}
+}
+
+func TestPacketCursorSince(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cursor := &packetCursor{}
+
+	if got := cursor.since(now, time.Minute); !got.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected %s before any packet, got %s", now.Add(-time.Minute), got)
+	}
+
+	cursor.lastAt = now.Add(-time.Hour)
+	expected := now.Add(-time.Hour - time.Minute)
+	if got := cursor.since(now, time.Minute); !got.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,167 @@
+package snd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+	seenSetSize      = 4096
+)
+
+// pgListener holds a LISTEN on a Postgres channel across connection
+// failures. Notifications sent while no connection is listening are
+// lost, so after every connect it runs a catch-up query for rows
+// committed in the meantime; LISTEN is issued first so that nothing
+// slips between the catch-up and the first notification. If
+// pollInterval is set, catch-up also runs whenever that long passes
+// without a notification.
+type pgListener struct {
+	pool         *pgxpool.Pool
+	channel      string
+	logger       Logger
+	pollInterval time.Duration
+}
+
+func (l *pgListener) connect(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to acquire database connection: %w",
+			err,
+		)
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+l.channel)
+	if err != nil {
+		l.discard(conn)
+		return nil, fmt.Errorf(
+			"failed to listen for %s: %w",
+			l.channel,
+			err,
+		)
+	}
+
+	return conn, nil
+}
+
+// run receives notifications on conn, and on whatever connections
+// replace it, until ctx is canceled or handle fails.
+func (l *pgListener) run(
+	ctx context.Context,
+	conn *pgxpool.Conn,
+	catchUp func(ctx context.Context) error,
+	handle func(ctx context.Context, payload string) error,
+) {
+	backoff := minListenBackoff
+
+	for {
+		err := l.receive(ctx, conn, catchUp, handle)
+		l.discard(conn)
+		if ctx.Err() != nil {
+			return
+		}
+
+		l.logger.Warn(
+			"Lost notification connection, reconnecting",
+			"channel", l.channel,
+			"error", err,
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			conn, err = l.connect(ctx)
+			if err == nil {
+				break
+			}
+			backoff = min(backoff*2, maxListenBackoff)
+			l.logger.Error(
+				"Failed to reconnect",
+				"channel", l.channel,
+				"retry_in", backoff,
+				"error", err,
+			)
+		}
+
+		l.logger.Info("Reconnected", "channel", l.channel)
+		backoff = minListenBackoff
+	}
+}
+
+func (l *pgListener) receive(
+	ctx context.Context,
+	conn *pgxpool.Conn,
+	catchUp func(ctx context.Context) error,
+	handle func(ctx context.Context, payload string) error,
+) error {
+	if err := catchUp(ctx); err != nil {
+		return fmt.Errorf("failed to catch up: %w", err)
+	}
+
+	for {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if l.pollInterval > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, l.pollInterval)
+		}
+		notification, err := conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && pgconn.Timeout(err) {
+				if err := catchUp(ctx); err != nil {
+					return fmt.Errorf("failed to catch up: %w", err)
+				}
+				continue
+			}
+			return err
+		}
+		if err := handle(ctx, notification.Payload); err != nil {
+			return err
+		}
+	}
+}
+
+// discard closes a listening connection rather than returning it to the
+// pool, where it would keep collecting notifications nobody reads.
+func (l *pgListener) discard(conn *pgxpool.Conn) {
+	conn.Conn().Close(context.Background())
+	conn.Release()
+}
+
+// seenSet remembers the most recent keys delivered, so that rows
+// delivered by both a catch-up query and a notification are only passed
+// on once.
+type seenSet[K comparable] struct {
+	keys  map[K]struct{}
+	order []K
+}
+
+func newSeenSet[K comparable]() *seenSet[K] {
+	return &seenSet[K]{keys: make(map[K]struct{})}
+}
+
+// add records a key and reports whether it was new.
+func (s *seenSet[K]) add(key K) bool {
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+
+	s.keys[key] = struct{}{}
+	s.order = append(s.order, key)
+	if len(s.order) > seenSetSize {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	return true
+}
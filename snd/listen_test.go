@@ -0,0 +1,29 @@
+package snd
+
+import "testing"
+
+func TestSeenSet(t *testing.T) {
+	seen := newSeenSet[int64]()
+
+	if !seen.add(1) {
+		t.Errorf("Expected first add of 1 to be new")
+	}
+	if seen.add(1) {
+		t.Errorf("Expected second add of 1 to be a duplicate")
+	}
+
+	// Push 1 out of the window
+	for i := int64(2); i <= seenSetSize+1; i++ {
+		seen.add(i)
+	}
+
+	if len(seen.keys) != seenSetSize {
+		t.Errorf("Expected %d keys, got %d", seenSetSize, len(seen.keys))
+	}
+	if !seen.add(1) {
+		t.Errorf("Expected 1 to be forgotten after %d newer keys", seenSetSize)
+	}
+	if seen.add(seenSetSize + 1) {
+		t.Errorf("Expected %d to still be remembered", seenSetSize+1)
+	}
+}
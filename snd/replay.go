@@ -0,0 +1,162 @@
+package snd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+// ReplayOptions selects the packets a ReplayPacketStreamer delivers and
+// how fast it delivers them.
+type ReplayOptions struct {
+	From, To time.Time
+
+	// GuildID and ChannelID narrow the replay when set.
+	GuildID   string
+	ChannelID string
+
+	// Speed scales the pace of the replay: 1 delivers packets as far
+	// apart as they were recorded, 2 twice as fast, and 0 as fast as
+	// they can be read.
+	Speed float64
+}
+
+// ReplayPacketStreamer delivers packets recorded in the past, in the
+// order they were recorded, so that they can be run through the same
+// pipeline as live packets. The stream ends after the last packet.
+type ReplayPacketStreamer struct {
+	queries *db.Queries
+	cache   UserIDCache
+	logger  Logger
+	options ReplayOptions
+
+	// err is why the replay stopped early, set before the stream closes
+	err error
+}
+
+func NewReplayPacketStreamer(
+	queries *db.Queries,
+	cache UserIDCache,
+	logger Logger,
+	options ReplayOptions,
+) *ReplayPacketStreamer {
+	return &ReplayPacketStreamer{
+		queries: queries,
+		cache:   cache,
+		logger:  logger,
+		options: options,
+	}
+}
+
+func (s *ReplayPacketStreamer) Stream(
+	ctx context.Context,
+) (<-chan OpusPacketNotification, error) {
+	if !s.options.To.After(s.options.From) {
+		return nil, errors.New("replay must end after it starts")
+	}
+	if s.options.Speed < 0 {
+		return nil, errors.New("replay speed must not be negative")
+	}
+
+	packetChan := make(chan OpusPacketNotification)
+
+	go func() {
+		defer close(packetChan)
+
+		count, err := s.replay(ctx, packetChan)
+		s.err = err
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("Replay failed", "packets", count, "error", err)
+			return
+		}
+		s.logger.Info("Replay finished", "packets", count)
+	}()
+
+	return packetChan, nil
+}
+
+// Err reports why the replay stopped before its last packet, or nil if
+// it delivered them all. It is only meaningful once the stream is closed.
+func (s *ReplayPacketStreamer) Err() error {
+	return s.err
+}
+
+func (s *ReplayPacketStreamer) replay(
+	ctx context.Context,
+	packetChan chan<- OpusPacketNotification,
+) (int, error) {
+	params := db.GetOpusPacketsForReplayParams{
+		AfterTime: pgtype.Timestamptz{Time: s.options.From, Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: s.options.To, Valid: true},
+		GuildID: pgtype.Text{
+			String: s.options.GuildID,
+			Valid:  s.options.GuildID != "",
+		},
+		ChannelID: pgtype.Text{
+			String: s.options.ChannelID,
+			Valid:  s.options.ChannelID != "",
+		},
+		BatchSize: catchUpBatchSize,
+	}
+
+	var first time.Time
+	started := time.Now()
+	count := 0
+
+	for {
+		packets, err := s.queries.GetOpusPacketsForReplay(ctx, params)
+		if err != nil {
+			return count, err
+		}
+
+		for _, packet := range packets {
+			if first.IsZero() {
+				first = packet.CreatedAt.Time
+			}
+
+			delay := time.Until(
+				started.Add(replayOffset(first, packet.CreatedAt.Time, s.options.Speed)),
+			)
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return count, ctx.Err()
+				}
+			}
+
+			notification := notificationFromPacket(packet)
+			notification.UserID, err = s.cache.Get(notification.SSRCKey())
+			if err != nil {
+				s.logger.Error("Error looking up user ID in cache", "error", err)
+			}
+
+			select {
+			case packetChan <- notification:
+				count++
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+		}
+
+		if len(packets) < int(params.BatchSize) {
+			return count, nil
+		}
+
+		last := packets[len(packets)-1]
+		params.AfterTime = last.CreatedAt
+		params.AfterID = last.ID
+	}
+}
+
+// replayOffset is how long after the start of a replay a packet
+// recorded at the given time is due.
+func replayOffset(first, recorded time.Time, speed float64) time.Duration {
+	if speed == 0 {
+		return 0
+	}
+	return time.Duration(float64(recorded.Sub(first)) / speed)
+}
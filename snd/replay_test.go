@@ -0,0 +1,32 @@
+package snd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayOffset(t *testing.T) {
+	first := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		recorded time.Time
+		speed    float64
+		expected time.Duration
+	}{
+		{"real time", first.Add(3 * time.Second), 1, 3 * time.Second},
+		{"double speed", first.Add(3 * time.Second), 2, 1500 * time.Millisecond},
+		{"half speed", first.Add(time.Second), 0.5, 2 * time.Second},
+		{"unpaced", first.Add(time.Hour), 0, 0},
+		{"first packet", first, 4, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replayOffset(first, tt.recorded, tt.speed)
+			if got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package snd
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"node.town/db"
+)
+
+// unknownSSRCRetry is how long an SSRC with no known user is remembered
+// as unknown before it is looked up again.
+const unknownSSRCRetry = 5 * time.Second
+
+// SSRCKey identifies an SSRC at a point in time. Discord reuses SSRCs
+// across sessions and users, so an SSRC alone does not say who spoke.
+type SSRCKey struct {
+	GuildID   string
+	ChannelID string
+	SessionID int32 // Bot session; zero if unknown
+	Ssrc      int64
+	At        time.Time
+}
+
+type UserIDCache interface {
+	Get(key SSRCKey) (string, error)
+}
+
+// ssrcScope is the part of an SSRCKey that selects mappings.
+type ssrcScope struct {
+	guildID   string
+	channelID string
+	sessionID int32
+	ssrc      int64
+}
+
+func (k SSRCKey) scope() ssrcScope {
+	return ssrcScope{k.GuildID, k.ChannelID, k.SessionID, k.Ssrc}
+}
+
+// ssrcMapping is a user an SSRC was assigned to, from when it was first
+// seen speaking with it.
+type ssrcMapping struct {
+	userID string
+	since  time.Time
+}
+
+type ssrcEntry struct {
+	mappings []ssrcMapping // oldest first
+	expires  time.Time     // zero for entries that never expire
+}
+
+// SSRCUserIDCache looks up who an SSRC belongs to in ssrc_mappings.
+// Mappings are cached until ListenForChanges hears of a new one for the
+// same SSRC; SSRCs with no mapping yet are looked up again after a
+// while, since the mapping often arrives just after the first packets.
+type SSRCUserIDCache struct {
+	mu      sync.RWMutex
+	cache   map[ssrcScope]ssrcEntry
+	queries *db.Queries
+	now     func() time.Time
+
+	// generation counts invalidations, so that a lookup racing with one
+	// does not cache what it read before it.
+	generation uint64
+}
+
+func NewSSRCUserIDCache(queries *db.Queries) *SSRCUserIDCache {
+	return &SSRCUserIDCache{
+		cache:   make(map[ssrcScope]ssrcEntry),
+		queries: queries,
+		now:     time.Now,
+	}
+}
+
+func (c *SSRCUserIDCache) Get(key SSRCKey) (string, error) {
+	scope := key.scope()
+
+	c.mu.RLock()
+	entry, ok := c.cache[scope]
+	c.mu.RUnlock()
+
+	if ok && (entry.expires.IsZero() || c.now().Before(entry.expires)) {
+		return resolveSSRCMapping(entry.mappings, key.At), nil
+	}
+
+	c.mu.RLock()
+	generation := c.generation
+	c.mu.RUnlock()
+
+	// If not in cache, look up in the database
+	rows, err := c.queries.ListSSRCMappings(
+		context.Background(),
+		db.ListSSRCMappingsParams{
+			GuildID:   key.GuildID,
+			ChannelID: key.ChannelID,
+			Ssrc:      key.Ssrc,
+			SessionID: pgtype.Int4{
+				Int32: key.SessionID,
+				Valid: key.SessionID != 0,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	entry = ssrcEntry{}
+	for _, row := range rows {
+		entry.mappings = append(entry.mappings, ssrcMapping{
+			userID: row.UserID,
+			since:  row.CreatedAt.Time,
+		})
+	}
+	if len(entry.mappings) == 0 {
+		entry.expires = c.now().Add(unknownSSRCRetry)
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.cache[scope] = entry
+	}
+	c.mu.Unlock()
+
+	return resolveSSRCMapping(entry.mappings, key.At), nil
+}
+
+// Invalidate forgets the mappings of an SSRC in every session, or those
+// of one session if sessionID is nonzero.
+func (c *SSRCUserIDCache) Invalidate(
+	guildID, channelID string,
+	sessionID int32,
+	ssrc int64,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.cache, ssrcScope{guildID, channelID, 0, ssrc})
+	if sessionID != 0 {
+		delete(c.cache, ssrcScope{guildID, channelID, sessionID, ssrc})
+		return
+	}
+	for scope := range c.cache {
+		if scope.guildID == guildID && scope.channelID == channelID &&
+			scope.ssrc == ssrc {
+			delete(c.cache, scope)
+		}
+	}
+}
+
+func (c *SSRCUserIDCache) clear() {
+	c.mu.Lock()
+	c.generation++
+	c.cache = make(map[ssrcScope]ssrcEntry)
+	c.mu.Unlock()
+}
+
+// ssrcMappingChange is the payload of an ssrc_mapping_change
+// notification.
+type ssrcMappingChange struct {
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	SessionID int32  `json:"session_id"`
+	Ssrc      int64  `json:"ssrc"`
+}
+
+// ListenForChanges invalidates cached mappings as the bot records new
+// ones, until ctx is canceled. After a reconnect the whole cache is
+// dropped, since changes may have been missed in between.
+func (c *SSRCUserIDCache) ListenForChanges(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	logger Logger,
+) error {
+	listener := &pgListener{
+		pool:    pool,
+		channel: "ssrc_mapping_change",
+		logger:  logger,
+	}
+	conn, err := listener.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	connected := false
+	catchUp := func(ctx context.Context) error {
+		if connected {
+			c.clear()
+		}
+		connected = true
+		return nil
+	}
+
+	handle := func(ctx context.Context, payload string) error {
+		var change ssrcMappingChange
+		if err := json.Unmarshal([]byte(payload), &change); err != nil {
+			logger.Error("Error unmarshalling payload", "error", err)
+			return nil
+		}
+
+		logger.Debug(
+			"SSRC mapping changed",
+			"ssrc", change.Ssrc,
+			"session", change.SessionID,
+		)
+		c.Invalidate(
+			change.GuildID,
+			change.ChannelID,
+			change.SessionID,
+			change.Ssrc,
+		)
+		return nil
+	}
+
+	go listener.run(ctx, conn, catchUp, handle)
+
+	return nil
+}
+
+// resolveSSRCMapping picks the user an SSRC belonged to at a time: the
+// latest mapping first seen by then. Speaking updates can trail the
+// first packets slightly, so a time before every mapping gets the
+// earliest one.
+func resolveSSRCMapping(mappings []ssrcMapping, at time.Time) string {
+	if len(mappings) == 0 {
+		return ""
+	}
+
+	userID := mappings[0].userID
+	for _, mapping := range mappings[1:] {
+		if mapping.since.After(at) {
+			break
+		}
+		userID = mapping.userID
+	}
+	return userID
+}
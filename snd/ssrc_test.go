@@ -0,0 +1,88 @@
+package snd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveSSRCMapping(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	mappings := []ssrcMapping{
+		{userID: "alice", since: start},
+		{userID: "bob", since: start.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		mappings []ssrcMapping
+		at       time.Time
+		expected string
+	}{
+		{"unknown", nil, start, ""},
+		{"before first mapping", mappings, start.Add(-time.Second), "alice"},
+		{"first mapping", mappings, start.Add(time.Minute), "alice"},
+		{"reassigned", mappings, start.Add(time.Hour), "bob"},
+		{"after reassignment", mappings, start.Add(2 * time.Hour), "bob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveSSRCMapping(tt.mappings, tt.at)
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSSRCUserIDCacheInvalidate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewSSRCUserIDCache(nil)
+	cache.now = func() time.Time { return now }
+
+	known := SSRCKey{GuildID: "g", ChannelID: "c", SessionID: 1, Ssrc: 7, At: now}
+	other := SSRCKey{GuildID: "g", ChannelID: "c", SessionID: 2, Ssrc: 7, At: now}
+	cache.cache[known.scope()] = ssrcEntry{
+		mappings: []ssrcMapping{{userID: "alice", since: now}},
+	}
+	cache.cache[other.scope()] = ssrcEntry{
+		mappings: []ssrcMapping{{userID: "bob", since: now}},
+	}
+
+	userID, err := cache.Get(known)
+	if err != nil || userID != "alice" {
+		t.Fatalf("Expected cached alice, got %q (%v)", userID, err)
+	}
+
+	cache.Invalidate("g", "c", 1, 7)
+
+	if _, ok := cache.cache[known.scope()]; ok {
+		t.Errorf("Expected session 1 mapping to be invalidated")
+	}
+	if _, ok := cache.cache[other.scope()]; !ok {
+		t.Errorf("Expected session 2 mapping to be kept")
+	}
+}
+
+func TestSSRCUserIDCacheUnknownExpires(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewSSRCUserIDCache(nil)
+	cache.now = func() time.Time { return now }
+
+	key := SSRCKey{GuildID: "g", ChannelID: "c", SessionID: 1, Ssrc: 7, At: now}
+	cache.cache[key.scope()] = ssrcEntry{expires: now.Add(unknownSSRCRetry)}
+
+	userID, err := cache.Get(key)
+	if err != nil || userID != "" {
+		t.Errorf("Expected cached unknown SSRC, got %q (%v)", userID, err)
+	}
+
+	// Past its expiry the SSRC is looked up again, which needs a database
+	now = now.Add(unknownSSRCRetry)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected an expired unknown SSRC to be looked up again")
+		}
+	}()
+	cache.Get(key)
+}
@@ -2,23 +2,15 @@ package snd
 
 import (
 	"context"
-	"database/sql"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"strings"
-	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"node.town/db"
 )
 
-type UserIDCache interface {
-	Get(ssrc int64) (string, error)
-}
-
 type PacketStreamer interface {
 	Stream(ctx context.Context) (<-chan OpusPacketNotification, error)
 }
@@ -34,49 +26,11 @@ type TranscriptionChangeListener interface {
 	Listen(ctx context.Context) (<-chan TranscriptionUpdate, error)
 }
 
-type SSRCUserIDCache struct {
-	mu      sync.RWMutex
-	cache   map[int64]string
-	queries *db.Queries
-}
-
-func NewSSRCUserIDCache(queries *db.Queries) *SSRCUserIDCache {
-	return &SSRCUserIDCache{
-		cache:   make(map[int64]string),
-		queries: queries,
-	}
-}
-
-func (c *SSRCUserIDCache) Get(ssrc int64) (string, error) {
-	c.mu.RLock()
-	userID, ok := c.cache[ssrc]
-	c.mu.RUnlock()
-
-	if ok {
-		return userID, nil
-	}
-
-	// If not in cache, look up in the database
-	dbUserID, err := c.queries.GetUserIDBySSRC(context.Background(), ssrc)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", nil // No error, but no user ID found
-		}
-		return "", err
-	}
-
-	// If found in database, add to cache
-	c.mu.Lock()
-	c.cache[ssrc] = dbUserID
-	c.mu.Unlock()
-
-	return dbUserID, nil
-}
-
 type OpusPacketNotification struct {
 	ID        int64  `json:"id"`
 	GuildID   string `json:"guild_id"`
 	ChannelID string `json:"channel_id"`
+	SessionID int32  `json:"session_id"`
 	UserID    string `json:"user_id"`
 	Ssrc      int64  `json:"ssrc"`
 	Sequence  int32  `json:"sequence"`
@@ -85,17 +39,58 @@ type OpusPacketNotification struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// SSRCKey identifies the SSRC the packet was sent with, for looking up
+// its speaker.
+func (p OpusPacketNotification) SSRCKey() SSRCKey {
+	at, err := time.Parse(time.RFC3339Nano, p.CreatedAt)
+	if err != nil {
+		at = time.Now()
+	}
+	return SSRCKey{
+		GuildID:   p.GuildID,
+		ChannelID: p.ChannelID,
+		SessionID: p.SessionID,
+		Ssrc:      p.Ssrc,
+		At:        at,
+	}
+}
+
 type TranscriptionUpdate struct {
 	Operation string `json:"operation"`
 	ID        int64  `json:"id"`
 	SessionID int64  `json:"session_id"`
 	IsFinal   bool   `json:"is_final"`
+	Version   int32  `json:"version"`
 }
 
+const (
+	// catchUpBatchSize is how many rows a catch-up query fetches at a time.
+	catchUpBatchSize = 1000
+
+	// packetPollInterval is how often the packet streamer looks for
+	// packets when no notifications arrive, which lets it move past
+	// gaps and pick up packets whose notifications were missed.
+	packetPollInterval = time.Second
+
+	// packetGapTimeout is the longest the packet streamer waits for a
+	// missing packet before it gives up on it and delivers the packets
+	// after it.
+	packetGapTimeout = 2 * time.Second
+
+	// packetCommitHorizon is how long after the packet that follows a
+	// missing one the missing packet is still expected to commit.
+	packetCommitHorizon = 250 * time.Millisecond
+
+	// packetFetchSlack is how much earlier than the last packet delivered
+	// the packets after it may have been created.
+	packetFetchSlack = time.Minute
+)
+
 type PostgresPacketStreamer struct {
-	pool   *pgxpool.Pool
-	cache  UserIDCache
-	logger Logger
+	pool    *pgxpool.Pool
+	queries *db.Queries
+	cache   UserIDCache
+	logger  Logger
 }
 
 func NewPostgresPacketStreamer(
@@ -104,92 +99,147 @@ func NewPostgresPacketStreamer(
 	logger Logger,
 ) *PostgresPacketStreamer {
 	return &PostgresPacketStreamer{
-		pool:   pool,
-		cache:  cache,
-		logger: logger,
+		pool:    pool,
+		queries: db.New(pool),
+		cache:   cache,
+		logger:  logger,
 	}
 }
 
+// packetNotice is the payload of a new_opus_packet notification. The
+// notification only says that a packet exists; its data is fetched
+// from opus_packets.
+type packetNotice struct {
+	ID int64 `json:"id"`
+}
+
+// Stream delivers new opus packets in ID order as they are inserted.
+// Notifications only wake it up: packets are fetched in batches of
+// everything after the last one delivered, so a burst of inserts costs
+// one query. If the connection drops it reconnects and carries on from
+// the last packet delivered, so the stream only ends when ctx is
+// canceled.
 func (s *PostgresPacketStreamer) Stream(
 	ctx context.Context,
 ) (<-chan OpusPacketNotification, error) {
-	conn, err := s.pool.Acquire(ctx)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to acquire database connection: %w",
-			err,
-		)
+	listener := &pgListener{
+		pool:         s.pool,
+		channel:      "new_opus_packet",
+		logger:       s.logger,
+		pollInterval: packetPollInterval,
 	}
-
-	_, err = conn.Exec(ctx, "LISTEN new_opus_packet")
+	conn, err := listener.connect(ctx)
 	if err != nil {
-		conn.Release()
-		return nil, fmt.Errorf(
-			"failed to listen for new_opus_packet: %w",
-			err,
-		)
+		return nil, err
 	}
 
 	packetChan := make(chan OpusPacketNotification)
+	cursor := &packetCursor{
+		gapTimeout:    packetGapTimeout,
+		commitHorizon: packetCommitHorizon,
+	}
 
-	go func() {
-		defer close(packetChan)
-		defer conn.Release()
+	fetch := func(ctx context.Context) error {
+		// Until the first notification there is no position to fetch from
+		if cursor.lastID == 0 {
+			return nil
+		}
 
 		for {
-			notification, err := conn.Conn().WaitForNotification(ctx)
-			if err != nil {
-				if err == context.Canceled {
-					return
-				}
-				s.logger.Error("Error waiting for notification", "error", err)
-				return
-			}
-
-			var packet OpusPacketNotification
-			err = json.Unmarshal([]byte(notification.Payload), &packet)
+			packets, err := s.queries.GetOpusPacketsAfterID(
+				ctx,
+				db.GetOpusPacketsAfterIDParams{
+					AfterID: int32(cursor.lastID),
+					Since: pgtype.Timestamptz{
+						Time:  cursor.since(time.Now(), packetFetchSlack),
+						Valid: true,
+					},
+					BatchSize: catchUpBatchSize,
+				},
+			)
 			if err != nil {
-				s.logger.Error("Error unmarshalling payload", "error", err)
-				continue
+				return err
 			}
 
-			// Decode the hex-encoded opus data
-			decodedData, err := hex.DecodeString(
-				strings.TrimPrefix(packet.OpusData, "\\x"),
-			)
-			if err != nil {
-				s.logger.Error("Error decoding hex string", "error", err)
-				continue
+			positions := make([]packetPosition, len(packets))
+			for i, packet := range packets {
+				positions[i] = packetPosition{
+					ID:        int64(packet.ID),
+					CreatedAt: packet.CreatedAt.Time,
+				}
 			}
-			packet.OpusData = string(decodedData)
-
-			// Log the first few bytes of the decoded opus data
-			if len(packet.OpusData) > 0 {
-				s.logger.Debug(
-					"Decoded opus packet data",
-					"first_bytes",
-					fmt.Sprintf(
-						"%x",
-						packet.OpusData[:min(4, len(packet.OpusData))],
-					),
+			n, skipped := cursor.ready(positions, time.Now())
+			if skipped > 0 {
+				s.logger.Warn(
+					"Gave up waiting for missing opus packets",
+					"count", skipped,
+					"before_id", positions[0].ID,
 				)
 			}
 
-			packet.UserID = s.getUserIDFromCache(packet.Ssrc)
+			for _, packet := range packets[:n] {
+				notification := notificationFromPacket(packet)
+				notification.UserID = s.getUserIDFromCache(notification.SSRCKey())
 
-			select {
-			case packetChan <- packet:
-			case <-ctx.Done():
-				return
+				select {
+				case packetChan <- notification:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
+
+			// Stop at a gap, or once there is nothing more to fetch
+			if n < len(packets) || len(packets) < catchUpBatchSize {
+				return nil
+			}
+		}
+	}
+
+	handle := func(ctx context.Context, payload string) error {
+		var notice packetNotice
+		err := json.Unmarshal([]byte(payload), &notice)
+		if err != nil {
+			s.logger.Error("Error unmarshalling payload", "error", err)
+			return nil
+		}
+
+		if cursor.lastID == 0 {
+			cursor.lastID = notice.ID - 1
+		}
+		// Already delivered by an earlier batch
+		if notice.ID <= cursor.lastID {
+			return nil
 		}
+
+		return fetch(ctx)
+	}
+
+	go func() {
+		defer close(packetChan)
+		listener.run(ctx, conn, fetch, handle)
 	}()
 
 	return packetChan, nil
 }
 
-func (s *PostgresPacketStreamer) getUserIDFromCache(ssrc int64) string {
-	userID, err := s.cache.Get(ssrc)
+// notificationFromPacket converts a stored packet into the form
+// packet streamers deliver.
+func notificationFromPacket(packet db.OpusPacket) OpusPacketNotification {
+	return OpusPacketNotification{
+		ID:        int64(packet.ID),
+		GuildID:   packet.GuildID,
+		ChannelID: packet.ChannelID,
+		SessionID: packet.SessionID,
+		Ssrc:      packet.Ssrc,
+		Sequence:  packet.Sequence,
+		Timestamp: packet.Timestamp,
+		OpusData:  string(packet.OpusData),
+		CreatedAt: packet.CreatedAt.Time.Format(time.RFC3339Nano),
+	}
+}
+
+func (s *PostgresPacketStreamer) getUserIDFromCache(key SSRCKey) string {
+	userID, err := s.cache.Get(key)
 	if err != nil {
 		s.logger.Error("Error looking up user ID in cache", "error", err)
 		return ""
@@ -197,21 +247,80 @@ func (s *PostgresPacketStreamer) getUserIDFromCache(ssrc int64) string {
 	return userID
 }
 
+// OverflowPolicy says what a demuxer does with a packet for a stream
+// whose buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropWhenFull drops the packet, so that a slow stream cannot hold
+	// up the others. It suits live audio.
+	DropWhenFull OverflowPolicy = iota
+
+	// BlockWhenFull waits for room, so that no packet is lost. It suits
+	// replays, which can deliver packets faster than they are consumed.
+	BlockWhenFull
+)
+
+// DemuxerOptions configures a DefaultPacketDemuxer.
+type DemuxerOptions struct {
+	// BufferSize is the capacity of each stream's channel.
+	BufferSize int
+
+	Overflow OverflowPolicy
+
+	// IdleTimeout ends a stream once its SSRC has been silent this
+	// long, so that the next packet from it starts a new stream. Silence
+	// is measured both between the recorded times of packets and on the
+	// clock since the last packet arrived. Zero keeps streams open until
+	// the input ends.
+	IdleTimeout time.Duration
+}
+
+func DefaultDemuxerOptions() DemuxerOptions {
+	return DemuxerOptions{
+		BufferSize: 1000,
+		Overflow:   DropWhenFull,
+	}
+}
+
 type DefaultPacketDemuxer struct {
-	cache  UserIDCache
-	logger Logger
+	cache   UserIDCache
+	logger  Logger
+	options DemuxerOptions
 }
 
 func NewDefaultPacketDemuxer(
 	cache UserIDCache,
 	logger Logger,
 ) *DefaultPacketDemuxer {
+	return NewPacketDemuxerWithOptions(cache, logger, DefaultDemuxerOptions())
+}
+
+func NewPacketDemuxerWithOptions(
+	cache UserIDCache,
+	logger Logger,
+	options DemuxerOptions,
+) *DefaultPacketDemuxer {
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultDemuxerOptions().BufferSize
+	}
 	return &DefaultPacketDemuxer{
-		cache:  cache,
-		logger: logger,
+		cache:   cache,
+		logger:  logger,
+		options: options,
 	}
 }
 
+// demuxedStream is the demuxer's state for one SSRC's stream.
+type demuxedStream struct {
+	packets    chan OpusPacketNotification
+	lastAt     time.Time // recorded time of the last packet
+	lastSeen   time.Time // when the last packet arrived
+	delivered  int
+	dropped    int
+	lastPacket int64
+}
+
 func (d *DefaultPacketDemuxer) Demux(
 	ctx context.Context,
 	inputChan <-chan OpusPacketNotification,
@@ -221,55 +330,86 @@ func (d *DefaultPacketDemuxer) Demux(
 	go func() {
 		defer close(outputChan)
 
-		streams := make(map[int64]chan OpusPacketNotification)
+		streams := make(map[int64]*demuxedStream)
+		defer func() {
+			// Close all remaining streams when the input ends or the
+			// context is cancelled
+			for ssrc, stream := range streams {
+				d.closeStream(ssrc, stream, "input ended")
+			}
+		}()
+
+		var idleCheck <-chan time.Time
+		if d.options.IdleTimeout > 0 {
+			ticker := time.NewTicker(min(d.options.IdleTimeout/2, time.Second))
+			defer ticker.Stop()
+			idleCheck = ticker.C
+		}
 
 		for {
 			select {
 			case packet, ok := <-inputChan:
 				if !ok {
-					// Close all existing streams when input channel is closed
-					for _, streamChan := range streams {
-						close(streamChan)
-					}
 					return
 				}
 
-				streamChan, exists := streams[packet.Ssrc]
+				now := time.Now()
+				at, err := time.Parse(time.RFC3339Nano, packet.CreatedAt)
+				if err != nil {
+					at = now
+				}
+
+				stream, exists := streams[packet.Ssrc]
+				if exists && d.idle(at.Sub(stream.lastAt)) {
+					d.closeStream(packet.Ssrc, stream, "idle")
+					delete(streams, packet.Ssrc)
+					exists = false
+				}
+
 				if !exists {
-					streamChan = make(
-						chan OpusPacketNotification,
-						1000,
-					) // Buffer size of 1000, adjust as needed
-					streams[packet.Ssrc] = streamChan
-					outputChan <- streamChan
+					stream = &demuxedStream{
+						packets: make(
+							chan OpusPacketNotification,
+							d.options.BufferSize,
+						),
+					}
+					streams[packet.Ssrc] = stream
 
-					// Log the new stream with UserID from cache
-					userID, _ := d.cache.Get(packet.Ssrc)
-					if userID != "" {
-						d.logger.Info(
-							"New stream started",
-							"ssrc", packet.Ssrc,
-							"userID", userID,
-						)
-					} else {
-						d.logger.Info("New stream started", "ssrc", packet.Ssrc, "userID", "unknown")
+					select {
+					case outputChan <- stream.packets:
+					case <-ctx.Done():
+						return
 					}
-				}
 
-				select {
-				case streamChan <- packet:
-				default:
-					d.logger.Warn(
-						"Stream channel buffer full, dropping packet",
+					// Log the new stream with UserID from cache
+					userID, _ := d.cache.Get(packet.SSRCKey())
+					if userID == "" {
+						userID = "unknown"
+					}
+					d.logger.Info(
+						"New stream started",
 						"ssrc", packet.Ssrc,
+						"userID", userID,
 					)
 				}
 
-			case <-ctx.Done():
-				// Close all existing streams when context is cancelled
-				for _, streamChan := range streams {
-					close(streamChan)
+				stream.lastAt = at
+				stream.lastSeen = now
+				stream.lastPacket = packet.ID
+
+				if !d.send(ctx, stream, packet) {
+					return
+				}
+
+			case now := <-idleCheck:
+				for ssrc, stream := range streams {
+					if d.idle(now.Sub(stream.lastSeen)) {
+						d.closeStream(ssrc, stream, "idle")
+						delete(streams, ssrc)
+					}
 				}
+
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -278,6 +418,67 @@ func (d *DefaultPacketDemuxer) Demux(
 	return outputChan
 }
 
+func (d *DefaultPacketDemuxer) idle(silence time.Duration) bool {
+	return d.options.IdleTimeout > 0 && silence >= d.options.IdleTimeout
+}
+
+// send passes a packet on to its stream according to the overflow
+// policy, and reports false if the context was cancelled while waiting.
+func (d *DefaultPacketDemuxer) send(
+	ctx context.Context,
+	stream *demuxedStream,
+	packet OpusPacketNotification,
+) bool {
+	if d.options.Overflow == BlockWhenFull {
+		select {
+		case stream.packets <- packet:
+			stream.delivered++
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case stream.packets <- packet:
+		stream.delivered++
+	default:
+		// Warn once per stream; the total is logged when it closes
+		if stream.dropped == 0 {
+			d.logger.Warn(
+				"Stream channel buffer full, dropping packets",
+				"ssrc", packet.Ssrc,
+				"buffer_size", d.options.BufferSize,
+			)
+		}
+		stream.dropped++
+	}
+	return true
+}
+
+func (d *DefaultPacketDemuxer) closeStream(
+	ssrc int64,
+	stream *demuxedStream,
+	reason string,
+) {
+	close(stream.packets)
+
+	d.logger.Info(
+		"Stream ended",
+		"ssrc", ssrc,
+		"reason", reason,
+		"packets", stream.delivered,
+		"last_packet", stream.lastPacket,
+	)
+	if stream.dropped > 0 {
+		d.logger.Warn(
+			"Stream dropped packets",
+			"ssrc", ssrc,
+			"dropped", stream.dropped,
+		)
+	}
+}
+
 func StreamOpusPackets(
 	ctx context.Context,
 	streamer PacketStreamer,
@@ -294,8 +495,9 @@ func DemuxOpusPackets(
 }
 
 type PostgresTranscriptionChangeListener struct {
-	pool   *pgxpool.Pool
-	logger Logger
+	pool    *pgxpool.Pool
+	queries *db.Queries
+	logger  Logger
 }
 
 func NewPostgresTranscriptionChangeListener(
@@ -303,29 +505,27 @@ func NewPostgresTranscriptionChangeListener(
 	logger Logger,
 ) *PostgresTranscriptionChangeListener {
 	return &PostgresTranscriptionChangeListener{
-		pool:   pool,
-		logger: logger,
+		pool:    pool,
+		queries: db.New(pool),
+		logger:  logger,
 	}
 }
 
+// Listen delivers transcription segment changes. If the connection
+// drops it reconnects and catches up on segments inserted since the last
+// one seen, as well as on the open segments seen so far, since those are
+// the only ones that get updated.
 func (l *PostgresTranscriptionChangeListener) Listen(
 	ctx context.Context,
 ) (<-chan TranscriptionUpdate, error) {
-	conn, err := l.pool.Acquire(ctx)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed to acquire database connection: %w",
-			err,
-		)
+	listener := &pgListener{
+		pool:    l.pool,
+		channel: "transcription_change",
+		logger:  l.logger,
 	}
-
-	_, err = conn.Exec(ctx, "LISTEN transcription_change")
+	conn, err := listener.connect(ctx)
 	if err != nil {
-		conn.Release()
-		return nil, fmt.Errorf(
-			"failed to listen for transcription_change: %w",
-			err,
-		)
+		return nil, err
 	}
 
 	updateChan := make(
@@ -333,41 +533,96 @@ func (l *PostgresTranscriptionChangeListener) Listen(
 		100,
 	) // Buffered channel with capacity of 100
 
-	go func() {
-		defer close(updateChan)
-		defer conn.Release()
+	type segmentVersion struct {
+		id      int64
+		version int32
+	}
+	seen := newSeenSet[segmentVersion]()
+	open := make(map[int64]struct{})
+	var lastID int64
+
+	deliver := func(ctx context.Context, update TranscriptionUpdate) error {
+		lastID = max(lastID, update.ID)
+		if !seen.add(segmentVersion{update.ID, update.Version}) {
+			return nil
+		}
+		if update.IsFinal {
+			delete(open, update.ID)
+		} else {
+			open[update.ID] = struct{}{}
+		}
 
-		for {
-			notification, err := conn.Conn().WaitForNotification(ctx)
-			if err != nil {
-				if err == context.Canceled {
-					return
-				}
-				l.logger.Error("Error waiting for notification", "error", err)
-				return
-			}
+		select {
+		case updateChan <- update:
+			l.logger.Debug("Sent update to channel", "update", update)
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			l.logger.Warn(
+				"Update channel full, dropping update",
+				"update", update,
+			)
+		}
+		return nil
+	}
 
-			var update TranscriptionUpdate
-			err = json.Unmarshal([]byte(notification.Payload), &update)
-			if err != nil {
-				l.logger.Error("Error unmarshalling payload", "error", err)
-				continue
-			}
+	catchUp := func(ctx context.Context) error {
+		if lastID == 0 {
+			return nil
+		}
 
-			l.logger.Info("Received update", "update", update)
+		openIDs := make([]int64, 0, len(open))
+		for id := range open {
+			openIDs = append(openIDs, id)
+		}
 
-			select {
-			case updateChan <- update:
-				l.logger.Debug("Sent update to channel", "update", update)
-			case <-ctx.Done():
-				return
-			default:
-				l.logger.Warn(
-					"Update channel full, dropping update",
-					"update", update,
-				)
+		segments, err := l.queries.GetTranscriptionSegmentsForCatchUp(
+			ctx,
+			db.GetTranscriptionSegmentsForCatchUpParams{
+				AfterID: lastID,
+				OpenIds: openIDs,
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		since := lastID
+		for _, segment := range segments {
+			operation := "UPDATE"
+			if segment.ID > since {
+				operation = "INSERT"
+			}
+			err := deliver(ctx, TranscriptionUpdate{
+				Operation: operation,
+				ID:        segment.ID,
+				SessionID: segment.SessionID,
+				IsFinal:   segment.IsFinal,
+				Version:   segment.Version,
+			})
+			if err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+
+	handle := func(ctx context.Context, payload string) error {
+		var update TranscriptionUpdate
+		err := json.Unmarshal([]byte(payload), &update)
+		if err != nil {
+			l.logger.Error("Error unmarshalling payload", "error", err)
+			return nil
+		}
+
+		l.logger.Info("Received update", "update", update)
+
+		return deliver(ctx, update)
+	}
+
+	go func() {
+		defer close(updateChan)
+		listener.run(ctx, conn, catchUp, handle)
 	}()
 
 	return updateChan, nil
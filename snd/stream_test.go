@@ -0,0 +1,153 @@
+package snd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type staticUserIDCache map[int64]string
+
+func (c staticUserIDCache) Get(key SSRCKey) (string, error) {
+	return c[key.Ssrc], nil
+}
+
+func demuxTestPacket(id int64, ssrc int64, at time.Time) OpusPacketNotification {
+	return OpusPacketNotification{
+		ID:        id,
+		Ssrc:      ssrc,
+		CreatedAt: at.Format(time.RFC3339Nano),
+	}
+}
+
+// collectStreams drains every stream of a demuxer and returns the packet
+// IDs of each, in the order the streams started.
+func collectStreams(
+	streamChan <-chan (<-chan OpusPacketNotification),
+	delay time.Duration,
+) [][]int64 {
+	var streams []chan []int64
+	for stream := range streamChan {
+		done := make(chan []int64, 1)
+		streams = append(streams, done)
+		go func(s <-chan OpusPacketNotification) {
+			var ids []int64
+			for packet := range s {
+				time.Sleep(delay)
+				ids = append(ids, packet.ID)
+			}
+			done <- ids
+		}(stream)
+	}
+
+	var result [][]int64
+	for _, done := range streams {
+		result = append(result, <-done)
+	}
+	return result
+}
+
+func TestDemuxIdleTimeoutSplitsStreams(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	input := make(chan OpusPacketNotification, 10)
+	input <- demuxTestPacket(1, 7, start)
+	input <- demuxTestPacket(2, 7, start.Add(20*time.Millisecond))
+	input <- demuxTestPacket(3, 8, start.Add(40*time.Millisecond))
+	input <- demuxTestPacket(4, 7, start.Add(5*time.Second))
+	close(input)
+
+	demuxer := NewPacketDemuxerWithOptions(
+		staticUserIDCache{},
+		log.Default(),
+		DemuxerOptions{BufferSize: 10, IdleTimeout: time.Second},
+	)
+	streams := collectStreams(demuxer.Demux(context.Background(), input), 0)
+
+	expected := [][]int64{{1, 2}, {3}, {4}}
+	if len(streams) != len(expected) {
+		t.Fatalf("Expected %d streams, got %v", len(expected), streams)
+	}
+	for i := range expected {
+		if len(streams[i]) != len(expected[i]) {
+			t.Errorf("Expected stream %d to be %v, got %v", i, expected[i], streams[i])
+			continue
+		}
+		for j := range expected[i] {
+			if streams[i][j] != expected[i][j] {
+				t.Errorf("Expected stream %d to be %v, got %v", i, expected[i], streams[i])
+				break
+			}
+		}
+	}
+}
+
+func TestDemuxIdleTimeoutClosesSilentStream(t *testing.T) {
+	input := make(chan OpusPacketNotification, 1)
+	input <- demuxTestPacket(1, 7, time.Now())
+	defer close(input)
+
+	demuxer := NewPacketDemuxerWithOptions(
+		staticUserIDCache{},
+		log.Default(),
+		DemuxerOptions{BufferSize: 10, IdleTimeout: 50 * time.Millisecond},
+	)
+	stream := <-demuxer.Demux(context.Background(), input)
+	<-stream
+
+	select {
+	case _, ok := <-stream:
+		if ok {
+			t.Errorf("Expected no more packets")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the stream to close after going silent")
+	}
+}
+
+func TestDemuxOverflow(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		expected int
+	}{
+		{"block keeps every packet", BlockWhenFull, 20},
+		{"drop loses packets", DropWhenFull, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := make(chan OpusPacketNotification, 20)
+			for i := 0; i < 20; i++ {
+				input <- demuxTestPacket(
+					int64(i+1),
+					7,
+					start.Add(time.Duration(i)*OpusFrameDuration),
+				)
+			}
+			close(input)
+
+			demuxer := NewPacketDemuxerWithOptions(
+				staticUserIDCache{},
+				log.Default(),
+				DemuxerOptions{BufferSize: 1, Overflow: tt.overflow},
+			)
+			streamChan := demuxer.Demux(context.Background(), input)
+
+			// Hold the stream back so that its buffer fills up
+			stream := <-streamChan
+			time.Sleep(50 * time.Millisecond)
+
+			got := 0
+			for range stream {
+				got++
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %d packets, got %d", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,898 @@
+package tts
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+// openAPISpec describes the API served under /api/v1.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+const (
+	defaultAPILimit = 100
+	maxAPILimit     = 1000
+)
+
+// Resources of the API. Times are RFC3339 and absent when unknown.
+
+type apiGuild struct {
+	GuildID       string    `json:"guild_id"`
+	SentenceCount int64     `json:"sentence_count"`
+	FirstSpoken   time.Time `json:"first_spoken"`
+	LastSpoken    time.Time `json:"last_spoken"`
+}
+
+type apiChannel struct {
+	ChannelID     string    `json:"channel_id"`
+	SentenceCount int64     `json:"sentence_count"`
+	FirstSpoken   time.Time `json:"first_spoken"`
+	LastSpoken    time.Time `json:"last_spoken"`
+}
+
+type apiSession struct {
+	ID                int64     `json:"id"`
+	GuildID           string    `json:"guild_id"`
+	ChannelID         string    `json:"channel_id"`
+	UserID            string    `json:"user_id"`
+	Ssrc              int64     `json:"ssrc"`
+	StartTime         time.Time `json:"start_time"`
+	TranscriptVersion int32     `json:"transcript_version"`
+}
+
+type apiSegment struct {
+	ID        int64      `json:"id"`
+	SessionID int64      `json:"session_id"`
+	IsFinal   bool       `json:"is_final"`
+	Version   int32      `json:"version"`
+	Text      *string    `json:"text,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	Versions []apiSegmentVersion `json:"versions,omitempty"`
+}
+
+type apiSegmentVersion struct {
+	Version   int32     `json:"version"`
+	WordCount int64     `json:"word_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiWord struct {
+	ID           int64            `json:"id"`
+	Version      int32            `json:"version"`
+	StartTime    time.Time        `json:"start_time"`
+	EndTime      time.Time        `json:"end_time"`
+	IsEOS        bool             `json:"is_eos"`
+	AttachesTo   string           `json:"attaches_to,omitempty"`
+	Corrected    *string          `json:"corrected,omitempty"`
+	Alternatives []apiAlternative `json:"alternatives"`
+}
+
+type apiSentence struct {
+	ID           int64     `json:"id"`
+	SessionID    int64     `json:"session_id"`
+	SegmentID    int64     `json:"segment_id"`
+	GuildID      string    `json:"guild_id"`
+	ChannelID    string    `json:"channel_id"`
+	UserID       string    `json:"user_id"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	Text         string    `json:"text"`
+	OriginalText string    `json:"original_text"`
+	Corrected    bool      `json:"corrected"`
+	Confidence   float32   `json:"confidence"`
+}
+
+type apiCorrection struct {
+	ID           int64     `json:"id"`
+	WordID       *int64    `json:"word_id,omitempty"`
+	Text         string    `json:"text"`
+	PreviousText string    `json:"previous_text"`
+	AuthorID     string    `json:"author_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Outdated     bool      `json:"outdated"`
+}
+
+type apiCorrectionRequest struct {
+	Text string `json:"text"`
+}
+
+type apiVocabulary struct {
+	Content   string    `json:"content"`
+	Uses      int32     `json:"uses"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type apiAlternative struct {
+	Content    string  `json:"content"`
+	Confidence float64 `json:"confidence"`
+}
+
+type apiVoiceActivity struct {
+	UserID      string    `json:"user_id"`
+	PacketCount int64     `json:"packet_count"`
+	FirstPacket time.Time `json:"first_packet"`
+	LastPacket  time.Time `json:"last_packet"`
+	TotalBytes  int64     `json:"total_bytes"`
+}
+
+func optionalText(t pgtype.Text) *string {
+	if !t.Valid {
+		return nil
+	}
+	return &t.String
+}
+
+func optionalTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Failed to write API response", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]string{"error": message})
+}
+
+// writeAPIQueryError answers for a failed query, as not found if there
+// was nothing to find.
+func writeAPIQueryError(w http.ResponseWriter, err error, what string) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, what+" not found")
+		return
+	}
+	log.Error("API query failed", "what", what, "error", err)
+	writeAPIError(
+		w,
+		http.StatusInternalServerError,
+		"failed to load "+strings.ToLower(what),
+	)
+}
+
+// apiPage reads the limit and offset query parameters.
+func apiPage(query url.Values) (limit, offset int32, err error) {
+	limit = defaultAPILimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAPILimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxAPILimit)
+		}
+		limit = int32(n)
+	}
+	if s := query.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must not be negative")
+		}
+		offset = int32(n)
+	}
+	return limit, offset, nil
+}
+
+// apiTime reads an optional RFC3339 time parameter.
+func apiTime(query url.Values, name string) (pgtype.Timestamptz, error) {
+	s := query.Get(name)
+	if s == "" {
+		return pgtype.Timestamptz{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return pgtype.Timestamptz{}, fmt.Errorf("%s must be an RFC3339 time", name)
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}, nil
+}
+
+func apiText(query url.Values, name string) pgtype.Text {
+	s := query.Get(name)
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// handleAPI serves the JSON API under /api/v1. Everything is read-only
+// but corrections.
+func handleAPI(
+	corrector *Corrector,
+	queries *db.Queries,
+	clips *clipCache,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		post := r.Method == http.MethodPost
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !post {
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		parts := strings.Split(
+			strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/"),
+			"/",
+		)
+		for i, part := range parts {
+			unescaped, err := url.PathUnescape(part)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "not found")
+				return
+			}
+			parts[i] = unescaped
+		}
+
+		corrections := len(parts) == 3 && parts[2] == "corrections"
+		if post && !corrections {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if corrections && parts[0] == "words" && !post {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		api := apiHandler{corrector: corrector, queries: queries, clips: clips}
+		switch {
+		case len(parts) == 1 && parts[0] == "openapi.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(openAPISpec)
+		case len(parts) == 1 && parts[0] == "guilds":
+			api.guilds(w, r)
+		case len(parts) == 3 && parts[0] == "guilds" && parts[2] == "channels":
+			api.channels(w, r, parts[1])
+		case len(parts) == 3 && parts[0] == "guilds" && parts[2] == "vocabulary":
+			api.vocabulary(w, r, parts[1])
+		case len(parts) == 1 && parts[0] == "sessions":
+			api.sessions(w, r)
+		case len(parts) >= 2 && parts[0] == "sessions":
+			sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "session not found")
+				return
+			}
+			switch {
+			case len(parts) == 2:
+				api.session(w, r, sessionID)
+			case len(parts) == 3 && parts[2] == "segments":
+				api.segments(w, r, sessionID)
+			case len(parts) == 3 && parts[2] == "audio":
+				api.audio(w, r, sessionID)
+			default:
+				writeAPIError(w, http.StatusNotFound, "not found")
+			}
+		case len(parts) >= 2 && parts[0] == "segments":
+			segmentID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "segment not found")
+				return
+			}
+			switch {
+			case len(parts) == 2:
+				api.segment(w, r, segmentID)
+			case len(parts) == 3 && parts[2] == "words":
+				api.words(w, r, segmentID)
+			default:
+				writeAPIError(w, http.StatusNotFound, "not found")
+			}
+		case len(parts) >= 2 && parts[0] == "sentences":
+			sentenceID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "sentence not found")
+				return
+			}
+			switch {
+			case len(parts) == 2:
+				api.sentence(w, r, sentenceID)
+			case corrections && post:
+				api.correctSentence(w, r, sentenceID)
+			case corrections:
+				api.corrections(w, r, sentenceID)
+			default:
+				writeAPIError(w, http.StatusNotFound, "not found")
+			}
+		case corrections && parts[0] == "words":
+			wordID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "word not found")
+				return
+			}
+			api.correctWord(w, r, wordID)
+		case len(parts) == 2 && parts[0] == "reports" && parts[1] == "voice-activity":
+			api.voiceActivity(w, r)
+		default:
+			writeAPIError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
+
+type apiHandler struct {
+	corrector *Corrector
+	queries   *db.Queries
+	clips     *clipCache
+}
+
+func (a apiHandler) guilds(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.queries.ListTranscriptGuilds(r.Context())
+	if err != nil {
+		writeAPIQueryError(w, err, "Guilds")
+		return
+	}
+
+	viewer := viewerFrom(r.Context())
+	guilds := make([]apiGuild, 0, len(rows))
+	for _, row := range rows {
+		if !viewer.CanSeeGuild(row.GuildID) {
+			continue
+		}
+		guilds = append(guilds, apiGuild{
+			GuildID:       row.GuildID,
+			SentenceCount: row.SentenceCount,
+			FirstSpoken:   row.FirstSpoken.Time,
+			LastSpoken:    row.LastSpoken.Time,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, guilds)
+}
+
+func (a apiHandler) channels(
+	w http.ResponseWriter,
+	r *http.Request,
+	guildID string,
+) {
+	viewer := viewerFrom(r.Context())
+	if !viewer.CanSeeGuild(guildID) {
+		writeAPIError(w, http.StatusNotFound, "guild not found")
+		return
+	}
+
+	rows, err := a.queries.ListTranscriptChannels(r.Context(), guildID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Channels")
+		return
+	}
+
+	channels := make([]apiChannel, 0, len(rows))
+	for _, row := range rows {
+		if !viewer.CanSeeChannel(r.Context(), guildID, row.ChannelID) {
+			continue
+		}
+		channels = append(channels, apiChannel{
+			ChannelID:     row.ChannelID,
+			SentenceCount: row.SentenceCount,
+			FirstSpoken:   row.FirstSpoken.Time,
+			LastSpoken:    row.LastSpoken.Time,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, channels)
+}
+
+func sessionFromDB(session db.TranscriptionSession) apiSession {
+	return apiSession{
+		ID:                session.ID,
+		GuildID:           session.GuildID,
+		ChannelID:         session.ChannelID,
+		UserID:            session.UserID,
+		Ssrc:              session.Ssrc,
+		StartTime:         session.StartTime.Time,
+		TranscriptVersion: session.TranscriptVersion,
+	}
+}
+
+func (a apiHandler) sessions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, offset, err := apiPage(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, err := apiTime(query, "from")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := apiTime(query, "to")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var version pgtype.Int4
+	if s := query.Get("transcript_version"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid transcript_version")
+			return
+		}
+		version = pgtype.Int4{Int32: int32(n), Valid: true}
+	}
+
+	viewer := viewerFrom(r.Context())
+	rows, err := a.queries.ListTranscriptionSessions(
+		r.Context(),
+		db.ListTranscriptionSessionsParams{
+			GuildID:    apiText(query, "guild"),
+			GuildIds:   viewer.GuildIDs(),
+			ChannelID:  apiText(query, "channel"),
+			UserID:     apiText(query, "user"),
+			FromTime:   from,
+			ToTime:     to,
+			Version:    version,
+			MaxResults: limit,
+			Skip:       offset,
+		},
+	)
+	if err != nil {
+		writeAPIQueryError(w, err, "Sessions")
+		return
+	}
+
+	sessions := make([]apiSession, 0, len(rows))
+	for _, row := range rows {
+		if !viewer.CanSeeChannel(r.Context(), row.GuildID, row.ChannelID) {
+			continue
+		}
+		sessions = append(sessions, sessionFromDB(row))
+	}
+	writeAPIJSON(w, http.StatusOK, sessions)
+}
+
+func (a apiHandler) session(
+	w http.ResponseWriter,
+	r *http.Request,
+	sessionID int64,
+) {
+	session, err := visibleSession(r.Context(), a.queries, sessionID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Session")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, sessionFromDB(session))
+}
+
+func (a apiHandler) segments(
+	w http.ResponseWriter,
+	r *http.Request,
+	sessionID int64,
+) {
+	limit, offset, err := apiPage(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := visibleSession(r.Context(), a.queries, sessionID); err != nil {
+		writeAPIQueryError(w, err, "Session")
+		return
+	}
+
+	rows, err := a.queries.ListTranscriptionSegments(
+		r.Context(),
+		db.ListTranscriptionSegmentsParams{
+			SessionID:  sessionID,
+			MaxResults: limit,
+			Skip:       offset,
+		},
+	)
+	if err != nil {
+		writeAPIQueryError(w, err, "Segments")
+		return
+	}
+
+	segments := make([]apiSegment, 0, len(rows))
+	for _, row := range rows {
+		segments = append(segments, apiSegment{
+			ID:        row.ID,
+			SessionID: row.SessionID,
+			IsFinal:   row.IsFinal,
+			Version:   row.Version,
+			Text:      optionalText(row.Content),
+			StartTime: optionalTime(row.StartTime),
+			EndTime:   optionalTime(row.EndTime),
+			CreatedAt: row.CreatedAt.Time,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, segments)
+}
+
+// segment serves a segment with the versions it went through, each a
+// revision of the partial transcript until the final one.
+func (a apiHandler) segment(
+	w http.ResponseWriter,
+	r *http.Request,
+	segmentID int64,
+) {
+	row, err := a.visibleSegment(r.Context(), segmentID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Segment")
+		return
+	}
+
+	versions, err := a.queries.ListSegmentVersions(r.Context(), segmentID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Segment versions")
+		return
+	}
+
+	segment := apiSegment{
+		ID:        row.ID,
+		SessionID: row.SessionID,
+		IsFinal:   row.IsFinal,
+		Version:   row.Version,
+		Text:      optionalText(row.Content),
+		StartTime: optionalTime(row.StartTime),
+		EndTime:   optionalTime(row.EndTime),
+		CreatedAt: row.CreatedAt.Time,
+	}
+	for _, version := range versions {
+		segment.Versions = append(segment.Versions, apiSegmentVersion{
+			Version:   version.Version,
+			WordCount: version.WordCount,
+			CreatedAt: version.CreatedAt.Time,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, segment)
+}
+
+// words serves the words of a segment, with their alternatives most
+// confident first, at its current version or the one asked for.
+func (a apiHandler) words(
+	w http.ResponseWriter,
+	r *http.Request,
+	segmentID int64,
+) {
+	params := db.GetSegmentWordsParams{SegmentID: segmentID}
+	if s := r.URL.Query().Get("version"); s != "" {
+		version, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "version must be a number")
+			return
+		}
+		params.Version = pgtype.Int4{Int32: int32(version), Valid: true}
+	}
+
+	if _, err := a.visibleSegment(r.Context(), segmentID); err != nil {
+		writeAPIQueryError(w, err, "Segment")
+		return
+	}
+
+	rows, err := a.queries.GetSegmentWords(r.Context(), params)
+	if err != nil {
+		writeAPIQueryError(w, err, "Words")
+		return
+	}
+
+	words := []apiWord{}
+	for _, row := range rows {
+		if n := len(words); n == 0 || words[n-1].ID != row.WordID {
+			words = append(words, apiWord{
+				ID:         row.WordID,
+				Version:    row.Version,
+				StartTime:  row.StartTime.Time,
+				EndTime:    row.EndTime.Time,
+				IsEOS:      row.IsEos,
+				AttachesTo: row.AttachesTo.String,
+				Corrected:  optionalText(row.CorrectedContent),
+			})
+		}
+		word := &words[len(words)-1]
+		word.Alternatives = append(word.Alternatives, apiAlternative{
+			Content:    row.Content,
+			Confidence: row.Confidence,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, words)
+}
+
+// visibleSegment loads a segment of a session the viewer may see.
+func (a apiHandler) visibleSegment(
+	ctx context.Context,
+	segmentID int64,
+) (db.GetTranscriptionSegmentRow, error) {
+	segment, err := a.queries.GetTranscriptionSegment(ctx, segmentID)
+	if err != nil {
+		return segment, err
+	}
+	if _, err := visibleSession(ctx, a.queries, segment.SessionID); err != nil {
+		return db.GetTranscriptionSegmentRow{}, err
+	}
+	return segment, nil
+}
+
+func (a apiHandler) audio(
+	w http.ResponseWriter,
+	r *http.Request,
+	sessionID int64,
+) {
+	query := r.URL.Query()
+	from, err := apiTime(query, "from")
+	if err != nil || !from.Valid {
+		writeAPIError(w, http.StatusBadRequest, "from must be an RFC3339 time")
+		return
+	}
+	to, err := apiTime(query, "to")
+	if err != nil || !to.Valid {
+		writeAPIError(w, http.StatusBadRequest, "to must be an RFC3339 time")
+		return
+	}
+	serveAudioClip(w, r, a.queries, a.clips, sessionID, from.Time, to.Time)
+}
+
+func (a apiHandler) voiceActivity(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := apiTime(query, "from")
+	if err != nil || !from.Valid {
+		writeAPIError(w, http.StatusBadRequest, "from must be an RFC3339 time")
+		return
+	}
+	to, err := apiTime(query, "to")
+	if err != nil || !to.Valid {
+		writeAPIError(w, http.StatusBadRequest, "to must be an RFC3339 time")
+		return
+	}
+
+	// Only those who can see every guild get a report across guilds
+	viewer := viewerFrom(r.Context())
+	guildID := query.Get("guild")
+	if guildID == "" && viewer.GuildIDs() != nil {
+		writeAPIError(w, http.StatusBadRequest, "guild is required")
+		return
+	}
+	if guildID != "" && !viewer.CanSeeGuild(guildID) {
+		writeAPIError(w, http.StatusNotFound, "guild not found")
+		return
+	}
+
+	report := []apiVoiceActivity{}
+	if guildID == "" {
+		rows, err := a.queries.GetVoiceActivityReport(
+			r.Context(),
+			db.GetVoiceActivityReportParams{CreatedAt: from, CreatedAt_2: to},
+		)
+		if err != nil {
+			writeAPIQueryError(w, err, "Voice activity")
+			return
+		}
+		for _, row := range rows {
+			report = append(report, apiVoiceActivity{
+				UserID:      row.UserID,
+				PacketCount: row.PacketCount,
+				FirstPacket: row.FirstPacket.Time,
+				LastPacket:  row.LastPacket.Time,
+				TotalBytes:  row.TotalBytes,
+			})
+		}
+	} else {
+		rows, err := a.queries.GetGuildVoiceActivityReport(
+			r.Context(),
+			db.GetGuildVoiceActivityReportParams{
+				GuildID:  guildID,
+				FromTime: from,
+				ToTime:   to,
+			},
+		)
+		if err != nil {
+			writeAPIQueryError(w, err, "Voice activity")
+			return
+		}
+		for _, row := range rows {
+			report = append(report, apiVoiceActivity{
+				UserID:      row.UserID,
+				PacketCount: row.PacketCount,
+				FirstPacket: row.FirstPacket.Time,
+				LastPacket:  row.LastPacket.Time,
+				TotalBytes:  row.TotalBytes,
+			})
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, report)
+}
+
+func sentenceFromDB(sentence db.TranscriptSentence) apiSentence {
+	return apiSentence{
+		ID:           sentence.ID,
+		SessionID:    sentence.SessionID,
+		SegmentID:    sentence.SegmentID,
+		GuildID:      sentence.GuildID,
+		ChannelID:    sentence.ChannelID,
+		UserID:       sentence.UserID,
+		StartTime:    sentence.StartTime.Time,
+		EndTime:      sentence.EndTime.Time,
+		Text:         sentence.Content,
+		OriginalText: sentence.OriginalContent,
+		Corrected:    sentence.Content != sentence.OriginalContent,
+		Confidence:   sentence.Confidence,
+	}
+}
+
+// sentence serves a sentence with its corrected and recognized text.
+func (a apiHandler) sentence(
+	w http.ResponseWriter,
+	r *http.Request,
+	sentenceID int64,
+) {
+	sentence, err := visibleSentence(r.Context(), a.queries, sentenceID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Sentence")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, sentenceFromDB(sentence))
+}
+
+// corrections serves the edit history of a sentence, latest first.
+func (a apiHandler) corrections(
+	w http.ResponseWriter,
+	r *http.Request,
+	sentenceID int64,
+) {
+	sentence, err := visibleSentence(r.Context(), a.queries, sentenceID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Sentence")
+		return
+	}
+
+	rows, err := a.queries.ListTranscriptCorrections(
+		r.Context(),
+		db.ListTranscriptCorrectionsParams{
+			SegmentID:     sentence.SegmentID,
+			SentenceIndex: sentence.SentenceIndex,
+		},
+	)
+	if err != nil {
+		writeAPIQueryError(w, err, "Corrections")
+		return
+	}
+
+	corrections := []apiCorrection{}
+	for _, row := range rows {
+		correction := apiCorrection{
+			ID:           row.ID,
+			Text:         row.Content,
+			PreviousText: row.PreviousContent,
+			AuthorID:     row.AuthorID,
+			CreatedAt:    row.CreatedAt.Time,
+			Outdated:     row.Outdated,
+		}
+		if row.WordID.Valid {
+			correction.WordID = &row.WordID.Int64
+		}
+		corrections = append(corrections, correction)
+	}
+	writeAPIJSON(w, http.StatusOK, corrections)
+}
+
+// readCorrection reads the body of a correction request.
+func readCorrection(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request apiCorrectionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "body must be JSON with a text")
+		return "", false
+	}
+	return request.Text, true
+}
+
+// writeCorrectionError answers for a correction that wasn't made.
+func writeCorrectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEmptyCorrection), errors.Is(err, errNothingChanged):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errSentenceRewritten):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error("Failed to correct transcript", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to save correction")
+	}
+}
+
+// correctSentence corrects the text of a sentence and answers with the
+// corrected sentence.
+func (a apiHandler) correctSentence(
+	w http.ResponseWriter,
+	r *http.Request,
+	sentenceID int64,
+) {
+	sentence, err := visibleSentence(r.Context(), a.queries, sentenceID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Sentence")
+		return
+	}
+	text, ok := readCorrection(w, r)
+	if !ok {
+		return
+	}
+
+	corrected, err := a.corrector.CorrectSentence(
+		r.Context(),
+		sentence,
+		text,
+		correctionAuthor(viewerFrom(r.Context())),
+	)
+	if err != nil {
+		writeCorrectionError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, sentenceFromDB(corrected))
+}
+
+// correctWord corrects the text of a word and answers with the sentence
+// it is in.
+func (a apiHandler) correctWord(
+	w http.ResponseWriter,
+	r *http.Request,
+	wordID int64,
+) {
+	word, err := visibleWord(r.Context(), a.queries, wordID)
+	if err != nil {
+		writeAPIQueryError(w, err, "Word")
+		return
+	}
+	text, ok := readCorrection(w, r)
+	if !ok {
+		return
+	}
+
+	corrected, err := a.corrector.CorrectWord(
+		r.Context(),
+		word,
+		text,
+		correctionAuthor(viewerFrom(r.Context())),
+	)
+	if err != nil {
+		writeCorrectionError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, sentenceFromDB(corrected))
+}
+
+// vocabulary serves the words learned from corrections to a guild's
+// transcripts, most needed first.
+func (a apiHandler) vocabulary(
+	w http.ResponseWriter,
+	r *http.Request,
+	guildID string,
+) {
+	if !viewerFrom(r.Context()).CanSeeGuild(guildID) {
+		writeAPIError(w, http.StatusNotFound, "guild not found")
+		return
+	}
+
+	rows, err := a.queries.ListGuildVocabulary(
+		r.Context(),
+		db.ListGuildVocabularyParams{
+			GuildID:    guildID,
+			MaxResults: maxGuildVocabulary,
+		},
+	)
+	if err != nil {
+		writeAPIQueryError(w, err, "Vocabulary")
+		return
+	}
+
+	vocabulary := []apiVocabulary{}
+	for _, row := range rows {
+		vocabulary = append(vocabulary, apiVocabulary{
+			Content:   row.Content,
+			Uses:      row.Uses,
+			UpdatedAt: row.UpdatedAt.Time,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, vocabulary)
+}
@@ -0,0 +1,119 @@
+package tts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAPIPage(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedLimit  int32
+		expectedOffset int32
+		wantErr        bool
+	}{
+		{"Defaults", "", defaultAPILimit, 0, false},
+		{"Both", "limit=10&offset=20", 10, 20, false},
+		{"Largest Limit", "limit=1000", 1000, 0, false},
+		{"Limit Too Large", "limit=1001", 0, 0, true},
+		{"Zero Limit", "limit=0", 0, 0, true},
+		{"Negative Offset", "offset=-1", 0, 0, true},
+		{"Not A Number", "limit=ten", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			limit, offset, err := apiPage(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if limit != tt.expectedLimit || offset != tt.expectedOffset {
+				t.Errorf(
+					"Expected limit %d and offset %d, got %d and %d",
+					tt.expectedLimit,
+					tt.expectedOffset,
+					limit,
+					offset,
+				)
+			}
+		})
+	}
+}
+
+func TestAPIRequestsWithoutDatabase(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"OpenAPI", http.MethodGet, "/api/v1/openapi.json", http.StatusOK},
+		{"Post", http.MethodPost, "/api/v1/guilds", http.StatusMethodNotAllowed},
+		{"Unknown Resource", http.MethodGet, "/api/v1/people", http.StatusNotFound},
+		{"Bad Session ID", http.MethodGet, "/api/v1/sessions/abc", http.StatusNotFound},
+		{"Bad Segment ID", http.MethodGet, "/api/v1/segments/abc/words", http.StatusNotFound},
+		{"Bad Limit", http.MethodGet, "/api/v1/sessions?limit=0", http.StatusBadRequest},
+		{"Bad Version", http.MethodGet, "/api/v1/segments/1/words?version=x", http.StatusBadRequest},
+		{"Audio Without Range", http.MethodGet, "/api/v1/sessions/1/audio", http.StatusBadRequest},
+		{"Report Without Range", http.MethodGet, "/api/v1/reports/voice-activity", http.StatusBadRequest},
+		{"Bad Sentence ID", http.MethodPost, "/api/v1/sentences/abc/corrections", http.StatusNotFound},
+		{"Get Word Corrections", http.MethodGet, "/api/v1/words/1/corrections", http.StatusMethodNotAllowed},
+		{"Post Sentence", http.MethodPost, "/api/v1/sentences/1", http.StatusMethodNotAllowed},
+	}
+
+	handler := handleAPI(nil, nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Expected a JSON response, got %s", got)
+			}
+		})
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("Expected the OpenAPI document to parse, got %v", err)
+	}
+
+	for _, path := range []string{
+		"/guilds",
+		"/guilds/{guild}/channels",
+		"/sessions",
+		"/sessions/{id}",
+		"/sessions/{id}/segments",
+		"/sessions/{id}/audio",
+		"/segments/{id}",
+		"/segments/{id}/words",
+		"/sentences/{id}",
+		"/sentences/{id}/corrections",
+		"/words/{id}/corrections",
+		"/guilds/{guild}/vocabulary",
+		"/reports/voice-activity",
+		"/openapi.json",
+	} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("Expected the OpenAPI document to describe %s", path)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package tts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/viper"
+	"node.town/db"
+)
+
+const (
+	sessionCookie = "jamie_session"
+	stateCookie   = "jamie_oauth_state"
+
+	// sessionLifetime is how long a login lasts. The guilds a user can
+	// see are those they were in when they logged in.
+	sessionLifetime = 30 * 24 * time.Hour
+
+	// stateLifetime is how long a login may take at Discord.
+	stateLifetime = 10 * time.Minute
+
+	// apiTokenPrefix marks Jamie's API tokens, so that they are easy to
+	// spot in scripts and logs.
+	apiTokenPrefix = "jamie_"
+)
+
+// Viewer is who is using the HTTP server and what they may see. The
+// zero Viewer sees nothing.
+type Viewer struct {
+	UserID   string
+	Username string
+
+	guilds   map[string]bool
+	all      bool           // Without authentication everything is visible
+	channels channelChecker // Nil if channel permissions aren't checked
+}
+
+// CanSeeGuild tells whether the viewer may see a guild's transcripts.
+func (v *Viewer) CanSeeGuild(guildID string) bool {
+	return v.all || v.guilds[guildID]
+}
+
+// CanSeeChannel tells whether the viewer may see a channel's
+// transcripts: they must be in its guild and, if the bot can tell,
+// able to see the channel in Discord.
+func (v *Viewer) CanSeeChannel(
+	ctx context.Context,
+	guildID, channelID string,
+) bool {
+	if v.all {
+		return true
+	}
+	if !v.guilds[guildID] {
+		return false
+	}
+	if v.channels == nil {
+		return true
+	}
+
+	allowed, err := v.channels.canViewChannel(ctx, guildID, channelID, v.UserID)
+	if err != nil {
+		log.Error(
+			"Failed to check channel permissions",
+			"guild", guildID,
+			"channel", channelID,
+			"user", v.UserID,
+			"error", err,
+		)
+		return false
+	}
+	return allowed
+}
+
+// GuildIDs lists the guilds the viewer may see, or is nil if they may
+// see every guild.
+func (v *Viewer) GuildIDs() []string {
+	if v.all {
+		return nil
+	}
+	guildIDs := make([]string, 0, len(v.guilds))
+	for guildID := range v.guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	sort.Strings(guildIDs)
+	return guildIDs
+}
+
+type viewerKey struct{}
+
+func withViewer(ctx context.Context, viewer *Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, viewer)
+}
+
+// viewerFrom is the viewer of a request, or the zero Viewer if the
+// request didn't come through the authentication middleware.
+func viewerFrom(ctx context.Context) *Viewer {
+	if viewer, ok := ctx.Value(viewerKey{}).(*Viewer); ok {
+		return viewer
+	}
+	return &Viewer{}
+}
+
+// openAccess lets everyone see everything, for servers only reachable
+// by people who may.
+func openAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer := &Viewer{all: true}
+		next.ServeHTTP(w, r.WithContext(withViewer(r.Context(), viewer)))
+	})
+}
+
+// webAuth logs people in with Discord and lets them see the guilds
+// they are in.
+type webAuth struct {
+	queries  *db.Queries
+	discord  *discordClient
+	channels channelChecker
+	secure   bool // Whether cookies are only sent over HTTPS
+}
+
+// newWebAuth sets up logging in from DISCORD_CLIENT_ID,
+// DISCORD_CLIENT_SECRET and HTTP_PUBLIC_URL, the address the server is
+// reached at. DISCORD_OAUTH_URL can point at a stand-in for Discord.
+// With DISCORD_TOKEN, channel permissions are checked as well.
+func newWebAuth(queries *db.Queries) (*webAuth, error) {
+	clientID := viper.GetString("DISCORD_CLIENT_ID")
+	clientSecret := viper.GetString("DISCORD_CLIENT_SECRET")
+	publicURL := strings.TrimSuffix(viper.GetString("HTTP_PUBLIC_URL"), "/")
+	if clientID == "" || clientSecret == "" || publicURL == "" {
+		return nil, errors.New(
+			"DISCORD_CLIENT_ID, DISCORD_CLIENT_SECRET and HTTP_PUBLIC_URL must be set",
+		)
+	}
+
+	baseURL := strings.TrimSuffix(viper.GetString("DISCORD_OAUTH_URL"), "/")
+	if baseURL == "" {
+		baseURL = defaultDiscordURL
+	}
+
+	auth := &webAuth{
+		queries: queries,
+		discord: &discordClient{
+			baseURL:      baseURL,
+			clientID:     clientID,
+			clientSecret: clientSecret,
+			redirectURL:  publicURL + "/auth/callback",
+			botToken:     viper.GetString("DISCORD_TOKEN"),
+			httpClient:   &http.Client{Timeout: 10 * time.Second},
+		},
+		secure: strings.HasPrefix(publicURL, "https://"),
+	}
+	if auth.discord.botToken != "" {
+		auth.channels = newCachedChannelChecker(auth.discord, channelAccessTTL)
+	}
+	return auth, nil
+}
+
+// newToken makes a random secret for a cookie or API token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored of a secret, so that the database doesn't
+// hold anything that can be used to log in.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// bearerToken is the API token of a request, if it has one.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// viewer works out who made a request from its API token or session
+// cookie. It is nil if the request has neither or they aren't valid.
+func (a *webAuth) viewer(r *http.Request) (*Viewer, error) {
+	ctx := r.Context()
+
+	var user db.WebUser
+	if token := bearerToken(r); token != "" {
+		row, err := a.queries.GetAPITokenUser(ctx, hashToken(token))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up API token: %w", err)
+		}
+		if err := a.queries.TouchAPIToken(ctx, row.TokenID); err != nil {
+			log.Warn("Failed to record API token use", "error", err)
+		}
+		user = db.WebUser{ID: row.ID, Username: row.Username}
+	} else if cookie, err := r.Cookie(sessionCookie); err == nil {
+		user, err = a.queries.GetWebSessionUser(ctx, hashToken(cookie.Value))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up session: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	guildIDs, err := a.queries.ListWebUserGuilds(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list guilds of user: %w", err)
+	}
+
+	viewer := &Viewer{
+		UserID:   user.ID,
+		Username: user.Username,
+		guilds:   make(map[string]bool, len(guildIDs)),
+		channels: a.channels,
+	}
+	for _, guildID := range guildIDs {
+		viewer.guilds[guildID] = true
+	}
+	return viewer, nil
+}
+
+// middleware lets through requests from people who are logged in or
+// have an API token. Others are sent to log in, or turned away from the
+// API.
+func (a *webAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/auth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		viewer, err := a.viewer(r)
+		if err != nil {
+			log.Error("Failed to authenticate request", "error", err)
+			http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		if viewer == nil {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/api/"):
+				writeAPIError(w, http.StatusUnauthorized, "authentication required")
+			case r.Method == http.MethodGet &&
+				r.Header.Get("Accept") != "text/event-stream":
+				http.Redirect(
+					w,
+					r,
+					"/auth/login?next="+url.QueryEscape(r.URL.RequestURI()),
+					http.StatusFound,
+				)
+			default:
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withViewer(r.Context(), viewer)))
+	})
+}
+
+// localRedirect is where to go after logging in, if it stays on this
+// server.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin sends the browser to Discord to log in. The state passed
+// along carries where to go afterwards.
+func (a *webAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	nonce, err := newToken()
+	if err != nil {
+		log.Error("Failed to start login", "error", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	state := nonce + ":" + localRedirect(r.URL.Query().Get("next"))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    url.QueryEscape(state),
+		Path:     "/auth/",
+		MaxAge:   int(stateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.discord.authorizeURL(state), http.StatusFound)
+}
+
+// handleCallback finishes a login: it checks the state, learns who the
+// user is and what guilds they are in, and starts a session.
+func (a *webAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, "Login was not completed", http.StatusForbidden)
+		return
+	}
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "Login expired, try again", http.StatusBadRequest)
+		return
+	}
+	state, err := url.QueryUnescape(cookie.Value)
+	if err != nil || state == "" || state != query.Get("state") {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+
+	ctx := r.Context()
+	accessToken, err := a.discord.exchangeCode(ctx, query.Get("code"))
+	if err != nil {
+		log.Error("Failed to log in", "error", err)
+		http.Error(w, "Failed to log in with Discord", http.StatusBadGateway)
+		return
+	}
+	user, err := a.discord.currentUser(ctx, accessToken)
+	if err != nil {
+		log.Error("Failed to log in", "error", err)
+		http.Error(w, "Failed to log in with Discord", http.StatusBadGateway)
+		return
+	}
+	guildIDs, err := a.discord.currentUserGuilds(ctx, accessToken)
+	if err != nil {
+		log.Error("Failed to log in", "error", err)
+		http.Error(w, "Failed to log in with Discord", http.StatusBadGateway)
+		return
+	}
+
+	token, err := a.startSession(ctx, user, guildIDs)
+	if err != nil {
+		log.Error("Failed to start session", "user", user.ID, "error", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	log.Info("User logged in", "user", user.ID, "guilds", len(guildIDs))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionLifetime),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	_, next, _ := strings.Cut(state, ":")
+	http.Redirect(w, r, localRedirect(next), http.StatusFound)
+}
+
+// startSession records a user and their guilds and returns the secret
+// of a new session for them.
+func (a *webAuth) startSession(
+	ctx context.Context,
+	user discordUser,
+	guildIDs []string,
+) (string, error) {
+	err := a.queries.UpsertWebUser(ctx, db.UpsertWebUserParams{
+		ID:       user.ID,
+		Username: user.Name(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save user: %w", err)
+	}
+
+	if guildIDs == nil {
+		guildIDs = []string{}
+	}
+	err = a.queries.SetWebUserGuilds(ctx, db.SetWebUserGuildsParams{
+		UserID:   user.ID,
+		GuildIds: guildIDs,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save guilds of user: %w", err)
+	}
+
+	if err := a.queries.DeleteExpiredWebSessions(ctx); err != nil {
+		log.Warn("Failed to delete expired sessions", "error", err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	err = a.queries.CreateWebSession(ctx, db.CreateWebSessionParams{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(sessionLifetime),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	return token, nil
+}
+
+// handleLogout ends the session of the browser.
+func (a *webAuth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		err := a.queries.DeleteWebSession(r.Context(), hashToken(cookie.Value))
+		if err != nil {
+			log.Error("Failed to delete session", "error", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// visibleSession loads a transcription session the viewer may see. A
+// session they may not see is reported as missing, like one that
+// doesn't exist, so as not to give away what is there.
+func visibleSession(
+	ctx context.Context,
+	queries *db.Queries,
+	sessionID int64,
+) (db.TranscriptionSession, error) {
+	session, err := queries.GetTranscriptionSession(ctx, sessionID)
+	if err != nil {
+		return session, err
+	}
+	if !viewerFrom(ctx).CanSeeChannel(ctx, session.GuildID, session.ChannelID) {
+		return db.TranscriptionSession{}, pgx.ErrNoRows
+	}
+	return session, nil
+}
+
+// visibleSentence loads a transcript sentence the viewer may see, or
+// reports it missing like visibleSession.
+func visibleSentence(
+	ctx context.Context,
+	queries *db.Queries,
+	sentenceID int64,
+) (db.TranscriptSentence, error) {
+	sentence, err := queries.GetTranscriptSentence(ctx, sentenceID)
+	if err != nil {
+		return sentence, err
+	}
+	if !viewerFrom(ctx).CanSeeChannel(ctx, sentence.GuildID, sentence.ChannelID) {
+		return db.TranscriptSentence{}, pgx.ErrNoRows
+	}
+	return sentence, nil
+}
+
+// visibleWord loads a current word of a transcript the viewer may see,
+// or reports it missing like visibleSession.
+func visibleWord(
+	ctx context.Context,
+	queries *db.Queries,
+	wordID int64,
+) (db.GetCurrentWordRow, error) {
+	word, err := queries.GetCurrentWord(ctx, wordID)
+	if err != nil {
+		return word, err
+	}
+	if !viewerFrom(ctx).CanSeeChannel(ctx, word.GuildID, word.ChannelID) {
+		return db.GetCurrentWordRow{}, pgx.ErrNoRows
+	}
+	return word, nil
+}
@@ -0,0 +1,418 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChannelPermissions(t *testing.T) {
+	const (
+		guildID = "100"
+		userID  = "200"
+		roleID  = "300"
+	)
+	view := strconv.FormatUint(permissionViewChannel, 10)
+	admin := strconv.FormatUint(permissionAdministrator, 10)
+
+	tests := []struct {
+		name       string
+		guild      discordGuild
+		overwrites []discordOverwrite
+		roles      []string
+		expected   bool
+	}{
+		{
+			"Everyone Can View",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: view}}},
+			nil,
+			nil,
+			true,
+		},
+		{
+			"Nobody Can View",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: "0"}}},
+			nil,
+			nil,
+			false,
+		},
+		{
+			"Owner",
+			discordGuild{ID: guildID, OwnerID: userID},
+			nil,
+			nil,
+			true,
+		},
+		{
+			"Administrator Role",
+			discordGuild{ID: guildID, Roles: []discordRole{
+				{ID: guildID, Permissions: "0"},
+				{ID: roleID, Permissions: admin},
+			}},
+			[]discordOverwrite{{ID: guildID, Type: 0, Deny: view}},
+			[]string{roleID},
+			true,
+		},
+		{
+			"Everyone Denied",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: view}}},
+			[]discordOverwrite{{ID: guildID, Type: 0, Deny: view}},
+			nil,
+			false,
+		},
+		{
+			"Role Allowed Over Everyone Denied",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: view}}},
+			[]discordOverwrite{
+				{ID: guildID, Type: 0, Deny: view},
+				{ID: roleID, Type: 0, Allow: view},
+			},
+			[]string{roleID},
+			true,
+		},
+		{
+			"Role Of Someone Else",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: view}}},
+			[]discordOverwrite{
+				{ID: guildID, Type: 0, Deny: view},
+				{ID: roleID, Type: 0, Allow: view},
+			},
+			nil,
+			false,
+		},
+		{
+			"Member Denied Over Role Allowed",
+			discordGuild{ID: guildID, Roles: []discordRole{{ID: guildID, Permissions: "0"}}},
+			[]discordOverwrite{
+				{ID: roleID, Type: 0, Allow: view},
+				{ID: userID, Type: 1, Deny: view},
+			},
+			[]string{roleID},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions := channelPermissions(
+				tt.guild,
+				discordChannel{GuildID: guildID, PermissionOverwrites: tt.overwrites},
+				discordMember{Roles: tt.roles},
+				userID,
+			)
+			if got := permissions&permissionViewChannel != 0; got != tt.expected {
+				t.Errorf("Expected view permission %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+type fakeChannelChecker struct {
+	allowed map[string]bool
+	calls   int
+}
+
+func (f *fakeChannelChecker) canViewChannel(
+	ctx context.Context,
+	guildID, channelID, userID string,
+) (bool, error) {
+	f.calls++
+	return f.allowed[channelID], nil
+}
+
+func TestViewer(t *testing.T) {
+	ctx := context.Background()
+
+	nobody := viewerFrom(ctx)
+	if nobody.CanSeeGuild("1") || nobody.CanSeeChannel(ctx, "1", "2") {
+		t.Errorf("Expected a request without a viewer to see nothing")
+	}
+	if got := nobody.GuildIDs(); got == nil || len(got) != 0 {
+		t.Errorf("Expected no guilds, got %v", got)
+	}
+
+	everyone := &Viewer{all: true}
+	if !everyone.CanSeeGuild("1") || !everyone.CanSeeChannel(ctx, "1", "2") {
+		t.Errorf("Expected open access to see everything")
+	}
+	if got := everyone.GuildIDs(); got != nil {
+		t.Errorf("Expected no guild restriction, got %v", got)
+	}
+
+	checker := &fakeChannelChecker{allowed: map[string]bool{"general": true}}
+	member := &Viewer{
+		UserID:   "42",
+		guilds:   map[string]bool{"b": true, "a": true},
+		channels: checker,
+	}
+	ctx = withViewer(ctx, member)
+	if viewerFrom(ctx) != member {
+		t.Errorf("Expected the viewer back from the context")
+	}
+	if !member.CanSeeGuild("a") || member.CanSeeGuild("c") {
+		t.Errorf("Expected the member to see only their guilds")
+	}
+	if !member.CanSeeChannel(ctx, "a", "general") {
+		t.Errorf("Expected the member to see a channel they can view")
+	}
+	if member.CanSeeChannel(ctx, "a", "secret") {
+		t.Errorf("Expected the member not to see a channel they can't view")
+	}
+	if member.CanSeeChannel(ctx, "c", "general") {
+		t.Errorf("Expected the member not to see channels of other guilds")
+	}
+	if got := member.GuildIDs(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Expected guilds [a b], got %v", got)
+	}
+}
+
+func TestCachedChannelChecker(t *testing.T) {
+	checker := &fakeChannelChecker{allowed: map[string]bool{"general": true}}
+	cached := newCachedChannelChecker(checker, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		allowed, err := cached.canViewChannel(context.Background(), "a", "general", "42")
+		if err != nil || !allowed {
+			t.Fatalf("Expected the channel to be visible, got %v (%v)", allowed, err)
+		}
+	}
+	if checker.calls != 1 {
+		t.Errorf("Expected 1 check, got %d", checker.calls)
+	}
+
+	cached.ttl = 0
+	cached.canViewChannel(context.Background(), "a", "general", "42")
+	if checker.calls != 2 {
+		t.Errorf("Expected an expired answer to be checked again, got %d checks", checker.calls)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		next     string
+		expected string
+	}{
+		{"", "/"},
+		{"/guilds/1?page=2", "/guilds/1?page=2"},
+		{"https://evil.example.com/", "/"},
+		{"//evil.example.com/", "/"},
+		{"/\\evil.example.com/", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.next, func(t *testing.T) {
+			if got := localRedirect(tt.next); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// standInDiscord serves the parts of Discord's OAuth2 and API that
+// logging in and checking channels use.
+func standInDiscord(t *testing.T, guildCount int) *httptest.Server {
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v10/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || clientID != "client" || secret != "secret" ||
+			r.FormValue("code") != "good-code" ||
+			r.FormValue("redirect_uri") != "http://jamie.test/auth/callback" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]string{"access_token": "user-token", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/api/v10/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, discordUser{ID: "42", Username: "jamie", GlobalName: "Jamie"})
+	})
+	mux.HandleFunc("/api/v10/users/@me/guilds", func(w http.ResponseWriter, r *http.Request) {
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		guilds := []discordGuild{}
+		for id := after + 1; id <= guildCount && len(guilds) < limit; id++ {
+			guilds = append(guilds, discordGuild{ID: strconv.Itoa(id)})
+		}
+		writeJSON(w, guilds)
+	})
+	mux.HandleFunc("/api/v10/guilds/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bot bot-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		view := strconv.FormatUint(permissionViewChannel, 10)
+		writeJSON(w, discordGuild{ID: "1", Roles: []discordRole{{ID: "1", Permissions: view}}})
+	})
+	mux.HandleFunc("/api/v10/guilds/1/members/42", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, discordMember{})
+	})
+	mux.HandleFunc("/api/v10/channels/", func(w http.ResponseWriter, r *http.Request) {
+		channel := discordChannel{
+			ID:      strings.TrimPrefix(r.URL.Path, "/api/v10/channels/"),
+			GuildID: "1",
+		}
+		if channel.ID == "secret" {
+			channel.PermissionOverwrites = []discordOverwrite{{
+				ID:   "1",
+				Type: 0,
+				Deny: strconv.FormatUint(permissionViewChannel, 10),
+			}}
+		}
+		writeJSON(w, channel)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiscordClient(t *testing.T) {
+	server := standInDiscord(t, discordGuildPageSize+5)
+	client := &discordClient{
+		baseURL:      server.URL,
+		clientID:     "client",
+		clientSecret: "secret",
+		redirectURL:  "http://jamie.test/auth/callback",
+		botToken:     "bot-token",
+	}
+	ctx := context.Background()
+
+	authorize, err := url.Parse(client.authorizeURL("nonce:/guilds/1"))
+	if err != nil {
+		t.Fatalf("Failed to parse authorize URL: %v", err)
+	}
+	if authorize.Path != "/oauth2/authorize" ||
+		authorize.Query().Get("client_id") != "client" ||
+		authorize.Query().Get("state") != "nonce:/guilds/1" ||
+		authorize.Query().Get("scope") != "identify guilds" {
+		t.Errorf("Unexpected authorize URL %s", authorize)
+	}
+
+	if _, err := client.exchangeCode(ctx, "bad-code"); err == nil {
+		t.Errorf("Expected a bad code to be refused")
+	}
+	accessToken, err := client.exchangeCode(ctx, "good-code")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+
+	user, err := client.currentUser(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.ID != "42" || user.Name() != "Jamie" {
+		t.Errorf("Expected user 42 called Jamie, got %+v", user)
+	}
+
+	guildIDs, err := client.currentUserGuilds(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("Failed to list guilds: %v", err)
+	}
+	if len(guildIDs) != discordGuildPageSize+5 {
+		t.Errorf("Expected %d guilds over two pages, got %d", discordGuildPageSize+5, len(guildIDs))
+	}
+
+	tests := []struct {
+		channelID string
+		userID    string
+		expected  bool
+	}{
+		{"general", "42", true},
+		{"secret", "42", false},
+		{"general", "43", false}, // Not a member
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s as %s", tt.channelID, tt.userID), func(t *testing.T) {
+			allowed, err := client.canViewChannel(ctx, "1", tt.channelID, tt.userID)
+			if err != nil {
+				t.Fatalf("Failed to check channel: %v", err)
+			}
+			if allowed != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestLoginRequests(t *testing.T) {
+	auth := &webAuth{discord: &discordClient{
+		baseURL:     "http://discord.test",
+		clientID:    "client",
+		redirectURL: "http://jamie.test/auth/callback",
+	}}
+
+	t.Run("Login", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		auth.handleLogin(w, httptest.NewRequest(http.MethodGet, "/auth/login?next=/sessions/7", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect, got %d", w.Code)
+		}
+		location, _ := url.Parse(w.Header().Get("Location"))
+		state := location.Query().Get("state")
+		if location.Host != "discord.test" || !strings.HasSuffix(state, ":/sessions/7") {
+			t.Errorf("Unexpected redirect to %s", location)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != stateCookie {
+			t.Fatalf("Expected a state cookie, got %v", cookies)
+		}
+		if got, _ := url.QueryUnescape(cookies[0].Value); got != state {
+			t.Errorf("Expected the cookie to hold state %q, got %q", state, got)
+		}
+	})
+
+	t.Run("Callback With Wrong State", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=x&state=forged", nil)
+		r.AddCookie(&http.Cookie{Name: stateCookie, Value: "real"})
+		w := httptest.NewRecorder()
+		auth.handleCallback(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"Page", http.MethodGet, "/guilds/1?page=2", http.StatusFound, "/auth/login?next=%2Fguilds%2F1%3Fpage%3D2"},
+		{"API", http.MethodGet, "/api/v1/guilds", http.StatusUnauthorized, ""},
+		{"Post", http.MethodPost, "/export/txt", http.StatusUnauthorized, ""},
+		{"Login", http.MethodGet, "/auth/login", http.StatusTeapot, ""},
+	}
+
+	handler := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	for _, tt := range tests {
+		t.Run("Anonymous "+tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.expectedLocation {
+				t.Errorf("Expected location %q, got %q", tt.expectedLocation, got)
+			}
+		})
+	}
+}
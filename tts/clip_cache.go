@@ -0,0 +1,73 @@
+package tts
+
+import (
+	"container/list"
+	"sync"
+)
+
+// clipCacheBytes bounds how much encoded audio the HTTP server keeps.
+const clipCacheBytes = 64 << 20
+
+// clipCache keeps the encoded Ogg files of finished clips by ETag, so
+// that the range requests of a player seeking in a clip don't query and
+// encode it again each time. The least recently used clips are dropped
+// once the cache holds more than its limit. A nil cache keeps nothing.
+type clipCache struct {
+	mu       sync.Mutex
+	clips    map[string]*list.Element
+	order    *list.List
+	size     int
+	maxBytes int
+}
+
+type cachedClip struct {
+	etag string
+	data []byte
+}
+
+func newClipCache(maxBytes int) *clipCache {
+	return &clipCache{
+		clips:    make(map[string]*list.Element),
+		order:    list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+func (c *clipCache) Get(etag string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.clips[etag]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedClip).data, true
+}
+
+// Put caches a clip, unless it alone is larger than the limit.
+func (c *clipCache) Put(etag string, data []byte) {
+	if c == nil || len(data) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.clips[etag]; ok {
+		c.size -= len(element.Value.(*cachedClip).data)
+		c.order.Remove(element)
+	}
+	c.clips[etag] = c.order.PushFront(&cachedClip{etag: etag, data: data})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		clip := oldest.Value.(*cachedClip)
+		c.order.Remove(oldest)
+		delete(c.clips, clip.etag)
+		c.size -= len(clip.data)
+	}
+}
@@ -0,0 +1,343 @@
+package tts
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"node.town/db"
+)
+
+// Utterance is a piece of one speaker's speech with its time: a word
+// when word timings are at hand, or a whole sentence when only
+// sentences are.
+type Utterance struct {
+	ID         int64 // Stored sentence it is or is part of; zero if none
+	GuildID    string
+	ChannelID  string
+	SessionID  int64
+	Speaker    string // User ID; empty if unknown
+	Content    string
+	Original   string // Text as recognized, if corrected since
+	AttachesTo string
+	Start, End time.Time
+	Confidence float64
+	IsEOS      bool // Ends a sentence
+	Partial    bool
+	Words      []UtteranceWord // Timed words of a sentence, if known
+
+	// SentenceStart is when the stored sentence started, and
+	// StartsSentence marks its first utterance.
+	SentenceStart  time.Time
+	StartsSentence bool
+}
+
+// Corrected says whether someone corrected the utterance.
+func (u Utterance) Corrected() bool {
+	return u.Original != ""
+}
+
+// Diff shows how the utterance was corrected, word by word.
+func (u Utterance) Diff() []DiffPart {
+	if !u.Corrected() {
+		return []DiffPart{{Op: DiffEqual, Text: u.Content}}
+	}
+	return DiffWords(u.Original, u.Content)
+}
+
+// UtteranceWord is a word of an utterance with when it was said, so
+// that playback can follow along.
+type UtteranceWord struct {
+	Content    string
+	AttachesTo string
+	Start, End time.Time
+	Confidence float64
+}
+
+// Clip is the recording an utterance is played from: a stretch of its
+// session's audio, and when that stretch starts.
+type Clip struct {
+	URL   string
+	Start time.Time
+}
+
+// Turn is a run of speech by one speaker, uninterrupted by anyone else.
+type Turn struct {
+	Speaker    string
+	SessionID  int64 // Session of the first utterance
+	Start, End time.Time
+	Utterances []Utterance
+
+	// Overlap is set when the turn starts before the previous one ends,
+	// and Interrupts when the previous speaker was mid-sentence then.
+	Overlap    bool
+	Interrupts bool
+}
+
+// Text is what was said in the turn.
+func (t Turn) Text() string {
+	var text strings.Builder
+	for i, utterance := range t.Utterances {
+		if i > 0 && utterance.AttachesTo != "previous" {
+			text.WriteByte(' ')
+		}
+		text.WriteString(utterance.Content)
+	}
+	return text.String()
+}
+
+// Label names the speaker of the turn and says whether it overlaps
+// the one before.
+func (t Turn) Label() string {
+	switch {
+	case t.Interrupts:
+		return strings.TrimSpace(t.Speaker + " (interrupting)")
+	case t.Overlap:
+		return strings.TrimSpace(t.Speaker + " (overlapping)")
+	default:
+		return t.Speaker
+	}
+}
+
+// Partial says whether the turn ends with speech not yet final.
+func (t Turn) Partial() bool {
+	return len(t.Utterances) > 0 && t.Utterances[len(t.Utterances)-1].Partial
+}
+
+// Clip is where to play an utterance of the turn from: the audio of its
+// session over all of the turn's utterances in that session, so that
+// the turn plays through. Clips are whole seconds, like audio URLs.
+func (t Turn) Clip(utterance Utterance) Clip {
+	start, end := utterance.Start, utterance.End
+	for _, other := range t.Utterances {
+		if other.SessionID != utterance.SessionID {
+			continue
+		}
+		if other.Start.Before(start) {
+			start = other.Start
+		}
+		if other.End.After(end) {
+			end = other.End
+		}
+	}
+
+	start = start.Truncate(time.Second)
+	if rounded := end.Truncate(time.Second); rounded.Before(end) {
+		end = rounded.Add(time.Second)
+	}
+	return Clip{URL: AudioURL(utterance.SessionID, start, end), Start: start}
+}
+
+// Conversation is the speech in one voice channel, in turns.
+type Conversation struct {
+	GuildID   string
+	ChannelID string
+	Turns     []Turn
+}
+
+type conversationKey struct {
+	guildID   string
+	channelID string
+}
+
+// AssembleConversations merges the speech of many sessions into one
+// conversation per voice channel. Utterances are ordered by when they
+// started, so that cross-talk interleaves word by word where word
+// timings are known, and consecutive utterances by the same speaker
+// make a turn. Conversations are ordered by when they started.
+func AssembleConversations(utterances []Utterance) []Conversation {
+	byChannel := make(map[conversationKey][]Utterance)
+	var keys []conversationKey
+	for _, utterance := range utterances {
+		key := conversationKey{utterance.GuildID, utterance.ChannelID}
+		if _, ok := byChannel[key]; !ok {
+			keys = append(keys, key)
+		}
+		byChannel[key] = append(byChannel[key], utterance)
+	}
+
+	conversations := make([]Conversation, 0, len(keys))
+	for _, key := range keys {
+		conversations = append(conversations, Conversation{
+			GuildID:   key.guildID,
+			ChannelID: key.channelID,
+			Turns:     assembleTurns(byChannel[key]),
+		})
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].Turns[0].Start.Before(
+			conversations[j].Turns[0].Start,
+		)
+	})
+	return conversations
+}
+
+// assembleTurns orders the utterances of one channel and groups them
+// into turns. Utterances starting together stay in their given order.
+func assembleTurns(utterances []Utterance) []Turn {
+	sorted := make([]Utterance, len(utterances))
+	copy(sorted, utterances)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	var turns []Turn
+	for _, utterance := range sorted {
+		if n := len(turns); n > 0 && sameSpeaker(turns[n-1], utterance) {
+			turn := &turns[n-1]
+			turn.Utterances = append(turn.Utterances, utterance)
+			if utterance.End.After(turn.End) {
+				turn.End = utterance.End
+			}
+			continue
+		}
+
+		turn := Turn{
+			Speaker:    utterance.Speaker,
+			SessionID:  utterance.SessionID,
+			Start:      utterance.Start,
+			End:        utterance.End,
+			Utterances: []Utterance{utterance},
+		}
+		if n := len(turns); n > 0 {
+			previous := turns[n-1]
+			turn.Overlap = utterance.Start.Before(previous.End)
+			turn.Interrupts = turn.Overlap &&
+				!previous.Utterances[len(previous.Utterances)-1].IsEOS
+		}
+		turns = append(turns, turn)
+	}
+	return turns
+}
+
+// sameSpeaker says whether an utterance continues a turn. Speakers not
+// known are told apart by session.
+func sameSpeaker(turn Turn, utterance Utterance) bool {
+	if turn.Speaker != utterance.Speaker {
+		return false
+	}
+	return turn.Speaker != "" || turn.SessionID == utterance.SessionID
+}
+
+// WordUtterances makes an utterance of each word of a session.
+// Punctuation goes with the word it attaches to, so that other speakers'
+// words can't come between them.
+func WordUtterances(
+	words []TranscriptWord,
+	guildID, channelID, speaker string,
+	partial bool,
+) []Utterance {
+	utterances := make([]Utterance, 0, len(words))
+	for _, word := range words {
+		if n := len(utterances); n > 0 && word.AttachesTo == "previous" {
+			previous := &utterances[n-1]
+			previous.Content += word.Content
+			previous.IsEOS = previous.IsEOS || word.IsEOS
+			continue
+		}
+		utterances = append(utterances, Utterance{
+			GuildID:    guildID,
+			ChannelID:  channelID,
+			SessionID:  word.SessionID,
+			Speaker:    speaker,
+			Content:    word.Content,
+			AttachesTo: word.AttachesTo,
+			Start:      word.AbsoluteStartTime,
+			End: word.AbsoluteStartTime.Add(time.Duration(
+				(word.RelativeEndTime - word.RelativeStartTime) * float64(time.Second),
+			)),
+			Confidence: word.Confidence,
+			IsEOS:      word.IsEOS,
+			Partial:    partial,
+		})
+	}
+	return utterances
+}
+
+// SentenceUtterances makes an utterance of each stored sentence, with
+// its corrections.
+func SentenceUtterances(sentences []db.TranscriptSentence) []Utterance {
+	utterances := make([]Utterance, 0, len(sentences))
+	for _, sentence := range sentences {
+		var original string
+		if sentence.OriginalContent != sentence.Content {
+			original = sentence.OriginalContent
+		}
+		utterances = append(utterances, Utterance{
+			ID:         sentence.ID,
+			GuildID:    sentence.GuildID,
+			ChannelID:  sentence.ChannelID,
+			SessionID:  sentence.SessionID,
+			Speaker:    sentence.UserID,
+			Content:    sentence.Content,
+			Original:   original,
+			Start:      sentence.StartTime.Time,
+			End:        sentence.EndTime.Time,
+			Confidence: float64(sentence.Confidence),
+			IsEOS:      true,
+
+			SentenceStart:  sentence.StartTime.Time,
+			StartsSentence: true,
+		})
+	}
+	return utterances
+}
+
+// TimedSentenceUtterances makes an utterance of each word of stored
+// sentences, from their timed words by sentence ID, so that cross-talk
+// interleaves word by word and a turn can tell when it cut into another
+// speaker's sentence. Sentences without words, as when rewritten as a
+// whole, make one utterance each.
+func TimedSentenceUtterances(
+	sentences []db.TranscriptSentence,
+	words map[int64][]UtteranceWord,
+) []Utterance {
+	utterances := make([]Utterance, 0, len(sentences))
+	for _, sentence := range sentences {
+		timed := words[sentence.ID]
+		if len(timed) == 0 {
+			utterances = append(
+				utterances,
+				SentenceUtterances([]db.TranscriptSentence{sentence})...,
+			)
+			continue
+		}
+
+		transcriptWords := make([]TranscriptWord, len(timed))
+		for i, word := range timed {
+			transcriptWords[i] = TranscriptWord{
+				Content:           word.Content,
+				RelativeEndTime:   word.End.Sub(word.Start).Seconds(),
+				Confidence:        word.Confidence,
+				AttachesTo:        word.AttachesTo,
+				AbsoluteStartTime: word.Start,
+				SessionID:         sentence.SessionID,
+			}
+		}
+
+		sentenceWords := WordUtterances(
+			transcriptWords,
+			sentence.GuildID,
+			sentence.ChannelID,
+			sentence.UserID,
+			false,
+		)
+		for i := range sentenceWords {
+			word := &sentenceWords[i]
+			word.ID = sentence.ID
+			word.SentenceStart = sentence.StartTime.Time
+			word.Words = []UtteranceWord{{
+				Content:    word.Content,
+				AttachesTo: word.AttachesTo,
+				Start:      word.Start,
+				End:        word.End,
+				Confidence: word.Confidence,
+			}}
+		}
+		sentenceWords[0].StartsSentence = true
+		sentenceWords[len(sentenceWords)-1].IsEOS = true
+		utterances = append(utterances, sentenceWords...)
+	}
+	return utterances
+}
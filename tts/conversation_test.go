@@ -0,0 +1,265 @@
+package tts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+func utterance(
+	speaker string,
+	sessionID int64,
+	content string,
+	start, end float64,
+	isEOS bool,
+) Utterance {
+	origin := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+	return Utterance{
+		GuildID:    "guild",
+		ChannelID:  "channel",
+		SessionID:  sessionID,
+		Speaker:    speaker,
+		Content:    content,
+		Start:      origin.Add(time.Duration(start * float64(time.Second))),
+		End:        origin.Add(time.Duration(end * float64(time.Second))),
+		Confidence: 1.0,
+		IsEOS:      isEOS,
+	}
+}
+
+func TestAssembleConversations(t *testing.T) {
+	t.Run("Turns By Speaker", func(t *testing.T) {
+		conversations := AssembleConversations([]Utterance{
+			utterance("alice", 1, "Hello", 0, 0.5, false),
+			utterance("alice", 1, "there.", 0.5, 1, true),
+			utterance("bob", 2, "Hi.", 2, 2.5, true),
+			utterance("alice", 1, "How", 3, 3.5, false),
+			utterance("alice", 1, "are", 3.5, 4, false),
+		})
+
+		if len(conversations) != 1 {
+			t.Fatalf("Expected 1 conversation, got %d", len(conversations))
+		}
+		turns := conversations[0].Turns
+		if len(turns) != 3 {
+			t.Fatalf("Expected 3 turns, got %d", len(turns))
+		}
+		expected := []string{"Hello there.", "Hi.", "How are"}
+		for i, turn := range turns {
+			if turn.Text() != expected[i] {
+				t.Errorf("Expected turn %d to be %q, got %q", i, expected[i], turn.Text())
+			}
+			if turn.Overlap || turn.Interrupts {
+				t.Errorf("Expected turn %d not to overlap", i)
+			}
+		}
+	})
+
+	t.Run("Cross-Talk Interleaves By Word", func(t *testing.T) {
+		// Bob's session comes first but alice started speaking first
+		conversations := AssembleConversations([]Utterance{
+			utterance("bob", 2, "wait", 1.5, 2, false),
+			utterance("bob", 2, "what?", 2, 2.5, true),
+			utterance("alice", 1, "So", 0, 0.5, false),
+			utterance("alice", 1, "I", 1, 1.8, false),
+			utterance("alice", 1, "think", 3, 3.5, true),
+		})
+
+		turns := conversations[0].Turns
+		if len(turns) != 3 {
+			t.Fatalf("Expected 3 turns, got %d", len(turns))
+		}
+		if turns[0].Speaker != "alice" || turns[0].Text() != "So I" {
+			t.Errorf("Expected alice to start with \"So I\", got %s: %q", turns[0].Speaker, turns[0].Text())
+		}
+		if !turns[1].Overlap || !turns[1].Interrupts {
+			t.Errorf("Expected bob to interrupt, got %+v", turns[1])
+		}
+		if turns[1].Label() != "bob (interrupting)" {
+			t.Errorf("Expected label \"bob (interrupting)\", got %q", turns[1].Label())
+		}
+		if turns[2].Overlap {
+			t.Errorf("Expected alice to resume without overlap")
+		}
+	})
+
+	t.Run("Overlap After A Finished Sentence", func(t *testing.T) {
+		conversations := AssembleConversations([]Utterance{
+			utterance("alice", 1, "Done.", 0, 2, true),
+			utterance("bob", 2, "Right.", 1.5, 2.5, true),
+		})
+
+		turn := conversations[0].Turns[1]
+		if !turn.Overlap || turn.Interrupts {
+			t.Errorf("Expected an overlap without interruption, got %+v", turn)
+		}
+		if turn.Label() != "bob (overlapping)" {
+			t.Errorf("Expected label \"bob (overlapping)\", got %q", turn.Label())
+		}
+	})
+
+	t.Run("One Conversation Per Channel", func(t *testing.T) {
+		other := utterance("carol", 3, "Elsewhere.", 0, 1, true)
+		other.ChannelID = "other"
+
+		conversations := AssembleConversations([]Utterance{
+			utterance("alice", 1, "Here.", 1, 2, true),
+			other,
+		})
+		if len(conversations) != 2 {
+			t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+		}
+		if conversations[0].ChannelID != "other" {
+			t.Errorf("Expected the earlier conversation first, got %s", conversations[0].ChannelID)
+		}
+	})
+}
+
+func TestWordUtterancesAttachPunctuation(t *testing.T) {
+	words := []TranscriptWord{
+		{Content: "Hello", RelativeEndTime: 0.5, IsEOS: false},
+		{Content: ".", AttachesTo: "previous", IsEOS: true},
+	}
+
+	utterances := WordUtterances(words, "guild", "channel", "alice", false)
+	if len(utterances) != 1 {
+		t.Fatalf("Expected 1 utterance, got %d", len(utterances))
+	}
+	if utterances[0].Content != "Hello." || !utterances[0].IsEOS {
+		t.Errorf("Expected \"Hello.\" ending a sentence, got %+v", utterances[0])
+	}
+}
+
+func TestSentenceUtterancesCorrections(t *testing.T) {
+	utterances := SentenceUtterances([]db.TranscriptSentence{
+		{ID: 1, Content: "As said.", OriginalContent: "As said."},
+		{ID: 2, Content: "Hi Jamie.", OriginalContent: "Hi jamey."},
+	})
+
+	if utterances[0].Corrected() {
+		t.Errorf("Expected the first sentence not to be corrected, got %+v", utterances[0])
+	}
+	if diff := utterances[0].Diff(); len(diff) != 1 || diff[0].Op != DiffEqual {
+		t.Errorf("Expected the first sentence unchanged, got %+v", diff)
+	}
+	if !utterances[1].Corrected() || utterances[1].Original != "Hi jamey." {
+		t.Errorf("Expected the second sentence to be corrected, got %+v", utterances[1])
+	}
+	if diff := utterances[1].Diff(); len(diff) != 3 || diff[2].Text != "Jamie." {
+		t.Errorf("Expected \"jamey.\" to be replaced with \"Jamie.\", got %+v", diff)
+	}
+}
+
+func TestTurnClip(t *testing.T) {
+	first := utterance("alice", 1, "Hello.", 1.2, 2.5, true)
+	second := utterance("alice", 1, "Again.", 3, 4.5, true)
+	reconnected := utterance("alice", 2, "Back.", 6.4, 7, true)
+	turn := Turn{Utterances: []Utterance{first, second, reconnected}}
+
+	origin := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		of       Utterance
+		expected Clip
+	}{
+		{
+			"Whole Seconds Around The Session's Utterances",
+			second,
+			Clip{
+				URL:   "/audio/1/2024-06-01T18:00:01Z/2024-06-01T18:00:05Z",
+				Start: origin.Add(time.Second),
+			},
+		},
+		{
+			"Another Session",
+			reconnected,
+			Clip{
+				URL:   "/audio/2/2024-06-01T18:00:06Z/2024-06-01T18:00:07Z",
+				Start: origin.Add(6 * time.Second),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := turn.Clip(tt.of)
+			if clip.URL != tt.expected.URL || !clip.Start.Equal(tt.expected.Start) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, clip)
+			}
+		})
+	}
+}
+
+func TestTimedSentenceUtterances(t *testing.T) {
+	origin := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+	at := func(seconds float64) time.Time {
+		return origin.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	sentence := func(id int64, speaker, content string, start, end float64) db.TranscriptSentence {
+		return db.TranscriptSentence{
+			ID:              id,
+			SessionID:       id,
+			GuildID:         "guild",
+			ChannelID:       "channel",
+			UserID:          speaker,
+			Content:         content,
+			OriginalContent: content,
+			StartTime:       pgtype.Timestamptz{Time: at(start), Valid: true},
+			EndTime:         pgtype.Timestamptz{Time: at(end), Valid: true},
+		}
+	}
+
+	sentences := []db.TranscriptSentence{
+		sentence(1, "alice", "We should ship it.", 0, 4),
+		sentence(2, "bob", "Wait.", 0.8, 1.3),
+		// Rewritten as a whole, so it has no words
+		sentence(3, "bob", "Not today.", 5, 6),
+	}
+	words := map[int64][]UtteranceWord{
+		1: {
+			{Content: "We", Start: at(0), End: at(0.5)},
+			{Content: "should", Start: at(0.5), End: at(1)},
+			{Content: "ship", Start: at(2.5), End: at(3)},
+			{Content: "it", Start: at(3), End: at(4)},
+			{Content: ".", AttachesTo: "previous", Start: at(4), End: at(4)},
+		},
+		2: {
+			{Content: "Wait", Start: at(0.8), End: at(1.3)},
+			{Content: ".", AttachesTo: "previous", Start: at(1.3), End: at(1.3)},
+		},
+	}
+
+	utterances := TimedSentenceUtterances(sentences, words)
+	if len(utterances) != 6 {
+		t.Fatalf("Expected 6 utterances, got %d", len(utterances))
+	}
+	if !utterances[0].StartsSentence || utterances[1].StartsSentence {
+		t.Errorf("Expected only the first word to start the sentence")
+	}
+	if last := utterances[3]; last.Content != "it." || !last.IsEOS || last.ID != 1 ||
+		!last.SentenceStart.Equal(at(0)) {
+		t.Errorf("Expected \"it.\" to end sentence 1, got %+v", last)
+	}
+	if rewritten := utterances[5]; rewritten.Content != "Not today." || rewritten.ID != 3 {
+		t.Errorf("Expected the rewritten sentence whole, got %+v", rewritten)
+	}
+
+	turns := AssembleConversations(utterances)[0].Turns
+	expected := []string{"We should", "Wait.", "ship it.", "Not today."}
+	if len(turns) != len(expected) {
+		t.Fatalf("Expected %d turns, got %d", len(expected), len(turns))
+	}
+	for i, turn := range turns {
+		if turn.Text() != expected[i] {
+			t.Errorf("Expected turn %d to be %q, got %q", i, expected[i], turn.Text())
+		}
+	}
+	if !turns[1].Interrupts {
+		t.Errorf("Expected bob to interrupt alice mid-sentence")
+	}
+	if turns[3].Interrupts {
+		t.Errorf("Expected bob not to interrupt after alice finished")
+	}
+}
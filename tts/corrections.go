@@ -0,0 +1,456 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"node.town/db"
+	"node.town/speechmatics"
+)
+
+// maxGuildVocabulary is how many words of a guild's vocabulary are given
+// to the speech recognizer, which takes at most 1000.
+const maxGuildVocabulary = 1000
+
+var (
+	errEmptyCorrection   = errors.New("a correction can't be empty")
+	errNothingChanged    = errors.New("the correction changes nothing")
+	errSentenceRewritten = errors.New(
+		"the sentence was rewritten as a whole, so correct the sentence instead",
+	)
+)
+
+// DiffOp says whether a part of a diff was kept, removed or added.
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffDelete
+	DiffInsert
+)
+
+// DiffPart is a run of words that a diff keeps, removes or adds.
+type DiffPart struct {
+	Op   DiffOp
+	Text string
+}
+
+// DiffWords compares two texts word by word, with punctuation counting
+// as part of the word it is written against. Deletions come before the
+// insertions that replace them.
+func DiffWords(before, after string) []DiffPart {
+	a, b := strings.Fields(before), strings.Fields(after)
+
+	// lengths[i][j] is the longest common run of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var parts []DiffPart
+	add := func(op DiffOp, word string) {
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += " " + word
+			return
+		}
+		parts = append(parts, DiffPart{Op: op, Text: word})
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			add(DiffEqual, a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lengths[i+1][j] >= lengths[i][j+1]):
+			add(DiffDelete, a[i])
+			i++
+		default:
+			add(DiffInsert, b[j])
+			j++
+		}
+	}
+	return parts
+}
+
+// vocabularyWords picks the words a correction brought in, bare of
+// punctuation, as vocabulary for the speech recognizer.
+func vocabularyWords(before, after string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, part := range DiffWords(before, after) {
+		if part.Op != DiffInsert {
+			continue
+		}
+		for _, word := range strings.Fields(part.Text) {
+			word = strings.TrimFunc(word, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			if !strings.ContainsFunc(word, unicode.IsLetter) || seen[word] {
+				continue
+			}
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// sentenceWord is a word of a sentence with its current text.
+type sentenceWord struct {
+	ID         int64
+	Content    string
+	AttachesTo string
+}
+
+// wordEdit replaces the text of a word.
+type wordEdit struct {
+	Word    sentenceWord
+	Content string
+}
+
+// planWordEdits works out which words to correct to turn a sentence into
+// content. Words are matched up by the whitespace between them, with
+// punctuation that attaches to a word kept with it. When the words can't
+// be matched up, ok is false and the sentence has to be rewritten as a
+// whole, losing the timing of its words.
+func planWordEdits(words []sentenceWord, content string) (edits []wordEdit, ok bool) {
+	// Each chunk is a word and the punctuation written against it
+	var chunks [][]sentenceWord
+	for _, word := range words {
+		if n := len(chunks); n > 0 && word.AttachesTo == "previous" {
+			chunks[n-1] = append(chunks[n-1], word)
+			continue
+		}
+		chunks = append(chunks, []sentenceWord{word})
+	}
+
+	tokens := strings.Fields(content)
+	if len(tokens) != len(chunks) {
+		return nil, false
+	}
+
+	for i, chunk := range chunks {
+		var text, attached strings.Builder
+		for j, word := range chunk {
+			text.WriteString(word.Content)
+			if j > 0 {
+				attached.WriteString(word.Content)
+			}
+		}
+		if tokens[i] == text.String() {
+			continue
+		}
+
+		replacement, found := strings.CutSuffix(tokens[i], attached.String())
+		if !found || replacement == "" {
+			return nil, false
+		}
+		edits = append(edits, wordEdit{Word: chunk[0], Content: replacement})
+	}
+	return edits, true
+}
+
+// Corrector stores corrections people make to transcripts, refreshes
+// the sentences they change and learns vocabulary from them.
+type Corrector struct {
+	pool    *pgxpool.Pool
+	queries *db.Queries
+}
+
+func NewCorrector(pool *pgxpool.Pool, queries *db.Queries) *Corrector {
+	return &Corrector{pool: pool, queries: queries}
+}
+
+// correctionAuthor is who corrections by the viewer are recorded as.
+func correctionAuthor(viewer *Viewer) string {
+	if viewer.UserID == "" {
+		return "anonymous"
+	}
+	return viewer.UserID
+}
+
+// CorrectSentence changes the text of a sentence to content. Where the
+// words still line up, the changed words are corrected one by one so
+// that playback can follow them; otherwise the sentence is rewritten.
+func (c *Corrector) CorrectSentence(
+	ctx context.Context,
+	sentence db.TranscriptSentence,
+	content, authorID string,
+) (db.TranscriptSentence, error) {
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return sentence, errEmptyCorrection
+	}
+	if content == sentence.Content {
+		return sentence, errNothingChanged
+	}
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return sentence, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := c.queries.WithTx(tx)
+
+	rewritten, err := sentenceRewritten(ctx, qtx, sentence.SegmentID, sentence.SentenceIndex)
+	if err != nil {
+		return sentence, err
+	}
+
+	var edits []wordEdit
+	ok := false
+	if !rewritten {
+		words, err := currentSentenceWords(ctx, qtx, sentence)
+		if err != nil {
+			return sentence, err
+		}
+		edits, ok = planWordEdits(words, content)
+	}
+
+	if ok {
+		for _, edit := range edits {
+			_, err := qtx.InsertTranscriptCorrection(
+				ctx,
+				db.InsertTranscriptCorrectionParams{
+					SegmentID:       sentence.SegmentID,
+					SentenceIndex:   sentence.SentenceIndex,
+					WordID:          pgtype.Int8{Int64: edit.Word.ID, Valid: true},
+					Content:         edit.Content,
+					PreviousContent: edit.Word.Content,
+					AuthorID:        authorID,
+				},
+			)
+			if err != nil {
+				return sentence, fmt.Errorf("failed to save word correction: %w", err)
+			}
+		}
+	} else {
+		_, err := qtx.InsertTranscriptCorrection(
+			ctx,
+			db.InsertTranscriptCorrectionParams{
+				SegmentID:       sentence.SegmentID,
+				SentenceIndex:   sentence.SentenceIndex,
+				Content:         content,
+				PreviousContent: sentence.Content,
+				AuthorID:        authorID,
+			},
+		)
+		if err != nil {
+			return sentence, fmt.Errorf("failed to save sentence correction: %w", err)
+		}
+	}
+
+	err = learnVocabulary(ctx, qtx, sentence.GuildID, sentence.Content, content)
+	if err != nil {
+		return sentence, err
+	}
+
+	corrected, err := refreshCorrectedSentence(
+		ctx,
+		qtx,
+		sentence.SegmentID,
+		sentence.SentenceIndex,
+	)
+	if err != nil {
+		return sentence, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return sentence, fmt.Errorf("failed to commit correction: %w", err)
+	}
+	return corrected, nil
+}
+
+// CorrectWord changes the text of one word. Words of sentences that were
+// rewritten as a whole no longer show, so they can't be corrected.
+func (c *Corrector) CorrectWord(
+	ctx context.Context,
+	word db.GetCurrentWordRow,
+	content, authorID string,
+) (db.TranscriptSentence, error) {
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return db.TranscriptSentence{}, errEmptyCorrection
+	}
+	if content == word.Content {
+		return db.TranscriptSentence{}, errNothingChanged
+	}
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return db.TranscriptSentence{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := c.queries.WithTx(tx)
+
+	rewritten, err := sentenceRewritten(ctx, qtx, word.SegmentID, word.SentenceIndex)
+	if err != nil {
+		return db.TranscriptSentence{}, err
+	}
+	if rewritten {
+		return db.TranscriptSentence{}, errSentenceRewritten
+	}
+
+	_, err = qtx.InsertTranscriptCorrection(ctx, db.InsertTranscriptCorrectionParams{
+		SegmentID:       word.SegmentID,
+		SentenceIndex:   word.SentenceIndex,
+		WordID:          pgtype.Int8{Int64: word.ID, Valid: true},
+		Content:         content,
+		PreviousContent: word.Content,
+		AuthorID:        authorID,
+	})
+	if err != nil {
+		return db.TranscriptSentence{}, fmt.Errorf("failed to save word correction: %w", err)
+	}
+
+	err = learnVocabulary(ctx, qtx, word.GuildID, word.Content, content)
+	if err != nil {
+		return db.TranscriptSentence{}, err
+	}
+
+	corrected, err := refreshCorrectedSentence(ctx, qtx, word.SegmentID, word.SentenceIndex)
+	if err != nil {
+		return db.TranscriptSentence{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.TranscriptSentence{}, fmt.Errorf("failed to commit correction: %w", err)
+	}
+	return corrected, nil
+}
+
+// sentenceRewritten says whether a sentence of the segment's current
+// version was ever rewritten as a whole, after which its words no longer
+// make it up.
+func sentenceRewritten(
+	ctx context.Context,
+	queries *db.Queries,
+	segmentID int64,
+	sentenceIndex int32,
+) (bool, error) {
+	corrections, err := queries.ListTranscriptCorrections(
+		ctx,
+		db.ListTranscriptCorrectionsParams{
+			SegmentID:     segmentID,
+			SentenceIndex: sentenceIndex,
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to load corrections: %w", err)
+	}
+	for _, correction := range corrections {
+		if !correction.WordID.Valid && !correction.Outdated {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// currentSentenceWords loads the words of a sentence with their current
+// text.
+func currentSentenceWords(
+	ctx context.Context,
+	queries *db.Queries,
+	sentence db.TranscriptSentence,
+) ([]sentenceWord, error) {
+	rows, err := queries.GetSentenceWords(ctx, []int64{sentence.SegmentID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sentence words: %w", err)
+	}
+	var words []sentenceWord
+	for _, row := range rows {
+		if row.SentenceIndex != sentence.SentenceIndex {
+			continue
+		}
+		words = append(words, sentenceWord{
+			ID:         row.WordID,
+			Content:    row.Content,
+			AttachesTo: row.AttachesTo.String,
+		})
+	}
+	return words, nil
+}
+
+// refreshCorrectedSentence rebuilds the text of a corrected segment and
+// reloads the corrected sentence, which keeps its ID.
+func refreshCorrectedSentence(
+	ctx context.Context,
+	queries *db.Queries,
+	segmentID int64,
+	sentenceIndex int32,
+) (db.TranscriptSentence, error) {
+	if err := queries.RefreshTranscriptionSegmentContent(ctx, segmentID); err != nil {
+		return db.TranscriptSentence{}, fmt.Errorf("failed to refresh segment content: %w", err)
+	}
+	if err := queries.RefreshTranscriptSentences(ctx, segmentID); err != nil {
+		return db.TranscriptSentence{}, fmt.Errorf("failed to refresh transcript sentences: %w", err)
+	}
+	sentence, err := queries.GetTranscriptSentenceAt(
+		ctx,
+		db.GetTranscriptSentenceAtParams{
+			SegmentID:     segmentID,
+			SentenceIndex: sentenceIndex,
+		},
+	)
+	if err != nil {
+		return sentence, fmt.Errorf("failed to load corrected sentence: %w", err)
+	}
+	return sentence, nil
+}
+
+// learnVocabulary adds the words a correction brought in to the guild's
+// vocabulary, counting how often each was needed.
+func learnVocabulary(
+	ctx context.Context,
+	queries *db.Queries,
+	guildID, before, after string,
+) error {
+	for _, word := range vocabularyWords(before, after) {
+		err := queries.UpsertGuildVocabulary(ctx, db.UpsertGuildVocabularyParams{
+			GuildID: guildID,
+			Content: word,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add to guild vocabulary: %w", err)
+		}
+	}
+	return nil
+}
+
+// GuildVocabulary is the vocabulary learned from corrections to a
+// guild's transcripts, most needed first, as the speech recognizer
+// takes it.
+func GuildVocabulary(
+	ctx context.Context,
+	queries *db.Queries,
+	guildID string,
+) ([]speechmatics.AdditionalVocab, error) {
+	rows, err := queries.ListGuildVocabulary(ctx, db.ListGuildVocabularyParams{
+		GuildID:    guildID,
+		MaxResults: maxGuildVocabulary,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load guild vocabulary: %w", err)
+	}
+	vocab := make([]speechmatics.AdditionalVocab, 0, len(rows))
+	for _, row := range rows {
+		vocab = append(vocab, speechmatics.AdditionalVocab{Content: row.Content})
+	}
+	return vocab, nil
+}
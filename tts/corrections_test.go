@@ -0,0 +1,122 @@
+package tts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected []DiffPart
+	}{
+		{"Same", "hello world", "hello  world", []DiffPart{{DiffEqual, "hello world"}}},
+		{
+			"Replaced Word",
+			"I met jamey today.",
+			"I met Jamie today.",
+			[]DiffPart{
+				{DiffEqual, "I met"},
+				{DiffDelete, "jamey"},
+				{DiffInsert, "Jamie"},
+				{DiffEqual, "today."},
+			},
+		},
+		{
+			"Added And Removed",
+			"so um we shipped it",
+			"so we shipped it yesterday",
+			[]DiffPart{
+				{DiffEqual, "so"},
+				{DiffDelete, "um"},
+				{DiffEqual, "we shipped it"},
+				{DiffInsert, "yesterday"},
+			},
+		},
+		{"From Nothing", "", "hi there", []DiffPart{{DiffInsert, "hi there"}}},
+		{"To Nothing", "hi there", "", []DiffPart{{DiffDelete, "hi there"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffWords(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVocabularyWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected []string
+	}{
+		{"Name", "talk to jamey, please", "talk to Jamie, please", []string{"Jamie"}},
+		{"Punctuation Only", "we're done", "we're done.", []string{"done"}},
+		{"Numbers", "at 5", "at 6", nil},
+		{"Repeated", "a b", "Kubernetes and Kubernetes", []string{"Kubernetes", "and"}},
+		{"Removed", "uh okay", "okay", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := vocabularyWords(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlanWordEdits(t *testing.T) {
+	words := []sentenceWord{
+		{ID: 1, Content: "Hello"},
+		{ID: 2, Content: ",", AttachesTo: "previous"},
+		{ID: 3, Content: "jamey"},
+		{ID: 4, Content: ".", AttachesTo: "previous"},
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		expected []wordEdit
+		ok       bool
+	}{
+		{"Unchanged", "Hello, jamey.", nil, true},
+		{
+			"Word Before Punctuation",
+			"Hello, Jamie.",
+			[]wordEdit{{Word: words[2], Content: "Jamie"}},
+			true,
+		},
+		{
+			"Two Words",
+			"Hi, Jamie.",
+			[]wordEdit{
+				{Word: words[0], Content: "Hi"},
+				{Word: words[2], Content: "Jamie"},
+			},
+			true,
+		},
+		{"Word Added", "Hello there, Jamie.", nil, false},
+		{"Punctuation Changed", "Hello, Jamie!", nil, false},
+		{"Word Emptied", "Hello, .", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits, ok := planWordEdits(words, tt.content)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok to be %v, got %v", tt.ok, ok)
+			}
+			if !reflect.DeepEqual(edits, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDiscordURL is where Discord's OAuth2 pages and API are. A
+	// stand-in issuer serving the same paths can replace it for testing.
+	defaultDiscordURL = "https://discord.com"
+
+	// discordAPIPath is the version of Discord's API that is spoken.
+	discordAPIPath = "/api/v10"
+
+	// discordGuildPageSize is the most guilds Discord lists at once.
+	discordGuildPageSize = 200
+
+	// channelAccessTTL is how long a channel permission check is
+	// remembered, so that browsing doesn't ask Discord on every request.
+	channelAccessTTL = 5 * time.Minute
+
+	permissionAdministrator uint64 = 1 << 3
+	permissionViewChannel   uint64 = 1 << 10
+)
+
+// errNotGuildMember is returned when Discord doesn't know a user as a
+// member of a guild.
+var errNotGuildMember = errors.New("not a member of the guild")
+
+// discordClient logs users in with Discord OAuth2 and, given a bot
+// token, checks what channels they can see.
+type discordClient struct {
+	baseURL      string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	botToken     string
+	httpClient   *http.Client
+}
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+// Name is what the user goes by.
+func (u discordUser) Name() string {
+	if u.GlobalName != "" {
+		return u.GlobalName
+	}
+	return u.Username
+}
+
+type discordRole struct {
+	ID          string `json:"id"`
+	Permissions string `json:"permissions"`
+}
+
+type discordGuild struct {
+	ID      string        `json:"id"`
+	OwnerID string        `json:"owner_id"`
+	Roles   []discordRole `json:"roles"`
+}
+
+type discordMember struct {
+	Roles []string `json:"roles"`
+}
+
+type discordOverwrite struct {
+	ID    string `json:"id"`
+	Type  int    `json:"type"` // 0 for a role, 1 for a member
+	Allow string `json:"allow"`
+	Deny  string `json:"deny"`
+}
+
+type discordChannel struct {
+	ID                   string             `json:"id"`
+	GuildID              string             `json:"guild_id"`
+	PermissionOverwrites []discordOverwrite `json:"permission_overwrites"`
+}
+
+// authorizeURL is where a browser is sent to log in.
+func (c *discordClient) authorizeURL(state string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.clientID)
+	query.Set("scope", "identify guilds")
+	query.Set("redirect_uri", c.redirectURL)
+	query.Set("state", state)
+	query.Set("prompt", "none")
+	return c.baseURL + "/oauth2/authorize?" + query.Encode()
+}
+
+// exchangeCode trades the code a login came back with for an access
+// token of the user.
+func (c *discordClient) exchangeCode(
+	ctx context.Context,
+	code string,
+) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.redirectURL)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		c.baseURL+discordAPIPath+"/oauth2/token",
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := c.do(req, &token); err != nil {
+		return "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("failed to exchange code: no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// currentUser is the user an access token belongs to.
+func (c *discordClient) currentUser(
+	ctx context.Context,
+	accessToken string,
+) (discordUser, error) {
+	var user discordUser
+	err := c.get(ctx, "/users/@me", "Bearer "+accessToken, &user)
+	if err != nil {
+		return user, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// currentUserGuilds lists the IDs of the guilds the user of an access
+// token is in.
+func (c *discordClient) currentUserGuilds(
+	ctx context.Context,
+	accessToken string,
+) ([]string, error) {
+	var guildIDs []string
+	after := ""
+	for {
+		path := "/users/@me/guilds?limit=" + strconv.Itoa(discordGuildPageSize)
+		if after != "" {
+			path += "&after=" + url.QueryEscape(after)
+		}
+
+		var guilds []discordGuild
+		if err := c.get(ctx, path, "Bearer "+accessToken, &guilds); err != nil {
+			return nil, fmt.Errorf("failed to list guilds: %w", err)
+		}
+		for _, guild := range guilds {
+			guildIDs = append(guildIDs, guild.ID)
+		}
+		if len(guilds) < discordGuildPageSize {
+			return guildIDs, nil
+		}
+		after = guilds[len(guilds)-1].ID
+	}
+}
+
+// canViewChannel asks Discord, as the bot, whether a user can see a
+// channel.
+func (c *discordClient) canViewChannel(
+	ctx context.Context,
+	guildID, channelID, userID string,
+) (bool, error) {
+	authorization := "Bot " + c.botToken
+
+	var guild discordGuild
+	if err := c.get(ctx, "/guilds/"+url.PathEscape(guildID), authorization, &guild); err != nil {
+		return false, fmt.Errorf("failed to get guild: %w", err)
+	}
+
+	var channel discordChannel
+	if err := c.get(ctx, "/channels/"+url.PathEscape(channelID), authorization, &channel); err != nil {
+		return false, fmt.Errorf("failed to get channel: %w", err)
+	}
+	if channel.GuildID != guildID {
+		return false, nil
+	}
+
+	var member discordMember
+	err := c.get(
+		ctx,
+		"/guilds/"+url.PathEscape(guildID)+"/members/"+url.PathEscape(userID),
+		authorization,
+		&member,
+	)
+	if errors.Is(err, errNotGuildMember) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	permissions := channelPermissions(guild, channel, member, userID)
+	return permissions&permissionViewChannel != 0, nil
+}
+
+func (c *discordClient) get(
+	ctx context.Context,
+	path string,
+	authorization string,
+	v any,
+) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		c.baseURL+discordAPIPath+path,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	return c.do(req, v)
+}
+
+func (c *discordClient) do(req *http.Request, v any) error {
+	httpClient := c.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound &&
+		strings.Contains(req.URL.Path, "/members/") {
+		return errNotGuildMember
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// channelPermissions works out a member's permissions in a channel the
+// way Discord does: the guild's owner and administrators may do
+// anything, and otherwise the permissions of @everyone and the member's
+// roles are adjusted by the channel's overwrites for @everyone, then
+// for the roles and then for the member.
+func channelPermissions(
+	guild discordGuild,
+	channel discordChannel,
+	member discordMember,
+	userID string,
+) uint64 {
+	const all = ^uint64(0)
+	if guild.OwnerID == userID {
+		return all
+	}
+
+	memberRoles := make(map[string]bool, len(member.Roles))
+	for _, role := range member.Roles {
+		memberRoles[role] = true
+	}
+
+	var permissions uint64
+	for _, role := range guild.Roles {
+		// The @everyone role has the guild's ID
+		if role.ID == guild.ID || memberRoles[role.ID] {
+			permissions |= parsePermissions(role.Permissions)
+		}
+	}
+	if permissions&permissionAdministrator != 0 {
+		return all
+	}
+
+	var everyoneOverwrite, memberOverwrite *discordOverwrite
+	var roleAllow, roleDeny uint64
+	for i, overwrite := range channel.PermissionOverwrites {
+		switch {
+		case overwrite.Type == 0 && overwrite.ID == guild.ID:
+			everyoneOverwrite = &channel.PermissionOverwrites[i]
+		case overwrite.Type == 0 && memberRoles[overwrite.ID]:
+			roleAllow |= parsePermissions(overwrite.Allow)
+			roleDeny |= parsePermissions(overwrite.Deny)
+		case overwrite.Type == 1 && overwrite.ID == userID:
+			memberOverwrite = &channel.PermissionOverwrites[i]
+		}
+	}
+
+	if everyoneOverwrite != nil {
+		permissions &^= parsePermissions(everyoneOverwrite.Deny)
+		permissions |= parsePermissions(everyoneOverwrite.Allow)
+	}
+	permissions &^= roleDeny
+	permissions |= roleAllow
+	if memberOverwrite != nil {
+		permissions &^= parsePermissions(memberOverwrite.Deny)
+		permissions |= parsePermissions(memberOverwrite.Allow)
+	}
+	return permissions
+}
+
+// parsePermissions reads a permission bit set, which Discord sends as a
+// decimal string.
+func parsePermissions(s string) uint64 {
+	permissions, _ := strconv.ParseUint(s, 10, 64)
+	return permissions
+}
+
+// channelChecker tells whether a user can see a channel.
+type channelChecker interface {
+	canViewChannel(ctx context.Context, guildID, channelID, userID string) (bool, error)
+}
+
+type channelAccessKey struct {
+	guildID, channelID, userID string
+}
+
+type channelAccess struct {
+	allowed bool
+	checked time.Time
+}
+
+// cachedChannelChecker remembers the answers of a channelChecker for a
+// while.
+type cachedChannelChecker struct {
+	checker channelChecker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	answers map[channelAccessKey]channelAccess
+}
+
+func newCachedChannelChecker(
+	checker channelChecker,
+	ttl time.Duration,
+) *cachedChannelChecker {
+	return &cachedChannelChecker{
+		checker: checker,
+		ttl:     ttl,
+		answers: make(map[channelAccessKey]channelAccess),
+	}
+}
+
+func (c *cachedChannelChecker) canViewChannel(
+	ctx context.Context,
+	guildID, channelID, userID string,
+) (bool, error) {
+	key := channelAccessKey{guildID, channelID, userID}
+
+	c.mu.Lock()
+	answer, ok := c.answers[key]
+	c.mu.Unlock()
+	if ok && time.Since(answer.checked) < c.ttl {
+		return answer.allowed, nil
+	}
+
+	allowed, err := c.checker.canViewChannel(ctx, guildID, channelID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.answers[key] = channelAccess{allowed: allowed, checked: time.Now()}
+	c.mu.Unlock()
+	return allowed, nil
+}
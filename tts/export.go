@@ -0,0 +1,481 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/cobra"
+	"node.town/db"
+)
+
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export transcripts as subtitles or text",
+	Long: `This command writes the transcript of a session, or of everything said
+between two times, as SRT or WebVTT subtitles, JSON, Markdown or plain text.`,
+	Run: runExport,
+}
+
+func init() {
+	ExportCmd.Flags().
+		StringP("format", "f", "txt", "Output format (srt, vtt, json, md or txt)")
+	ExportCmd.Flags().Int64P("session", "s", 0, "Transcription session ID")
+	ExportCmd.Flags().String("from", "", "Start time (RFC3339)")
+	ExportCmd.Flags().String("to", "", "End time (RFC3339)")
+	ExportCmd.Flags().StringP("guild", "g", "", "Only this guild ID")
+	ExportCmd.Flags().StringP("channel", "c", "", "Only this channel ID")
+	ExportCmd.Flags().StringP("output", "o", "", "Output file (default stdout)")
+	ExportCmd.Flags().
+		Int("transcript-version", 0, "Export this transcript version (default the latest)")
+}
+
+type ExportFormat string
+
+const (
+	FormatSRT      ExportFormat = "srt"
+	FormatVTT      ExportFormat = "vtt"
+	FormatJSON     ExportFormat = "json"
+	FormatMarkdown ExportFormat = "md"
+	FormatText     ExportFormat = "txt"
+)
+
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch format := ExportFormat(strings.ToLower(s)); format {
+	case FormatSRT, FormatVTT, FormatJSON, FormatMarkdown, FormatText:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q", s)
+	}
+}
+
+// ContentType is the MIME type of an export in the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case FormatSRT:
+		return "application/x-subrip"
+	case FormatVTT:
+		return "text/vtt; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// ExportOptions selects the sentences of an export: those of one
+// session, or those spoken between From and To in a transcript version.
+type ExportOptions struct {
+	SessionID int64
+	From, To  time.Time
+	GuildID   string
+	ChannelID string
+	Version   int // 0 for the latest
+}
+
+// FileName is a name for an export with these options.
+func (o ExportOptions) FileName(format ExportFormat) string {
+	if o.SessionID != 0 {
+		return fmt.Sprintf("transcript_%d.%s", o.SessionID, format)
+	}
+	return fmt.Sprintf(
+		"transcript_%s_%s.%s",
+		o.From.UTC().Format("20060102T150405Z"),
+		o.To.UTC().Format("20060102T150405Z"),
+		format,
+	)
+}
+
+// LoadExportSentences loads the sentences an export covers, in the
+// order they were spoken.
+func LoadExportSentences(
+	ctx context.Context,
+	queries *db.Queries,
+	options ExportOptions,
+) ([]db.TranscriptSentence, error) {
+	params := db.GetTranscriptSentencesParams{
+		StartTime: pgtype.Timestamptz{Time: options.From, Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: options.To, Valid: true},
+		GuildID: pgtype.Text{
+			String: options.GuildID,
+			Valid:  options.GuildID != "",
+		},
+		ChannelID: pgtype.Text{
+			String: options.ChannelID,
+			Valid:  options.ChannelID != "",
+		},
+		Version: pgtype.Int4{
+			Int32: int32(options.Version),
+			Valid: options.Version > 0,
+		},
+	}
+
+	if options.SessionID != 0 {
+		params.SessionID = pgtype.Int8{Int64: options.SessionID, Valid: true}
+		params.StartTime = pgtype.Timestamptz{
+			InfinityModifier: pgtype.NegativeInfinity,
+			Valid:            true,
+		}
+		params.EndTime = pgtype.Timestamptz{
+			InfinityModifier: pgtype.Infinity,
+			Valid:            true,
+		}
+	} else if !options.To.After(options.From) {
+		return nil, errors.New("export must end after it starts")
+	}
+
+	sentences, err := queries.GetTranscriptSentences(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transcript sentences: %w", err)
+	}
+	return sentences, nil
+}
+
+// WriteExport writes sentences in a format. Formats written in turns
+// interleave speakers by the sentences' timed words, by sentence ID.
+func WriteExport(
+	w io.Writer,
+	format ExportFormat,
+	sentences []db.TranscriptSentence,
+	words map[int64][]UtteranceWord,
+) error {
+	switch format {
+	case FormatSRT:
+		return writeSubtitles(w, sentences, false)
+	case FormatVTT:
+		return writeSubtitles(w, sentences, true)
+	case FormatJSON:
+		return writeJSON(w, sentences)
+	case FormatMarkdown:
+		return writeMarkdown(w, TimedSentenceUtterances(sentences, words))
+	case FormatText:
+		return writeText(w, TimedSentenceUtterances(sentences, words))
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+const (
+	// subtitleLineWidth and subtitleMaxLines keep cues readable.
+	subtitleLineWidth = 42
+	subtitleMaxLines  = 2
+)
+
+// subtitleCue is one subtitle, timed from the start of the export.
+type subtitleCue struct {
+	Start, End time.Duration
+	Lines      []string
+}
+
+// subtitleCues turns sentences into cues, labelled with their speaker.
+// A sentence too long for one cue is split across several, its time
+// shared out by length.
+func subtitleCues(sentences []db.TranscriptSentence) []subtitleCue {
+	if len(sentences) == 0 {
+		return nil
+	}
+	origin := sentences[0].StartTime.Time
+
+	var cues []subtitleCue
+	for _, sentence := range sentences {
+		start := sentence.StartTime.Time.Sub(origin)
+		end := sentence.EndTime.Time.Sub(origin)
+
+		lines := wrapText(
+			fmt.Sprintf("%s: %s", sentence.UserID, sentence.Content),
+			subtitleLineWidth,
+		)
+		if len(lines) == 0 {
+			continue
+		}
+		total := 0
+		for _, line := range lines {
+			total += len(line)
+		}
+
+		done := 0
+		for i := 0; i < len(lines); i += subtitleMaxLines {
+			chunk := lines[i:min(i+subtitleMaxLines, len(lines))]
+			size := 0
+			for _, line := range chunk {
+				size += len(line)
+			}
+
+			cue := subtitleCue{
+				Start: start + time.Duration(int64(end-start)*int64(done)/int64(total)),
+				Lines: chunk,
+			}
+			done += size
+			cue.End = start + time.Duration(int64(end-start)*int64(done)/int64(total))
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+// wrapText breaks text into lines of at most width bytes where it can,
+// at spaces. Longer words get lines of their own.
+func wrapText(text string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	for _, word := range strings.Fields(text) {
+		if line.Len() > 0 && line.Len()+1+len(word) > width {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(word)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+func writeSubtitles(
+	w io.Writer,
+	sentences []db.TranscriptSentence,
+	vtt bool,
+) error {
+	if vtt {
+		if _, err := fmt.Fprint(w, "WEBVTT\n\n"); err != nil {
+			return err
+		}
+	}
+
+	for i, cue := range subtitleCues(sentences) {
+		var err error
+		if vtt {
+			_, err = fmt.Fprintf(
+				w,
+				"%s --> %s\n%s\n\n",
+				formatCueTime(cue.Start, '.'),
+				formatCueTime(cue.End, '.'),
+				strings.Join(cue.Lines, "\n"),
+			)
+		} else {
+			_, err = fmt.Fprintf(
+				w,
+				"%d\n%s --> %s\n%s\n\n",
+				i+1,
+				formatCueTime(cue.Start, ','),
+				formatCueTime(cue.End, ','),
+				strings.Join(cue.Lines, "\n"),
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatCueTime formats a cue time as hh:mm:ss followed by the
+// separator and milliseconds: a comma for SRT and a dot for WebVTT.
+func formatCueTime(d time.Duration, separator byte) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf(
+		"%02d:%02d:%02d%c%03d",
+		ms/3600000,
+		ms/60000%60,
+		ms/1000%60,
+		separator,
+		ms%1000,
+	)
+}
+
+// exportedSentence is a sentence in a JSON export.
+type exportedSentence struct {
+	SessionID  int64     `json:"session_id"`
+	GuildID    string    `json:"guild_id"`
+	ChannelID  string    `json:"channel_id"`
+	UserID     string    `json:"user_id"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Text       string    `json:"text"`
+	Confidence float32   `json:"confidence"`
+}
+
+func writeJSON(w io.Writer, sentences []db.TranscriptSentence) error {
+	exported := make([]exportedSentence, 0, len(sentences))
+	for _, sentence := range sentences {
+		exported = append(exported, exportedSentence{
+			SessionID:  sentence.SessionID,
+			GuildID:    sentence.GuildID,
+			ChannelID:  sentence.ChannelID,
+			UserID:     sentence.UserID,
+			Start:      sentence.StartTime.Time,
+			End:        sentence.EndTime.Time,
+			Text:       sentence.Content,
+			Confidence: sentence.Confidence,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exported)
+}
+
+// writeMarkdown writes a paragraph per turn, with a section per voice
+// channel if there are several.
+func writeMarkdown(w io.Writer, utterances []Utterance) error {
+	conversations := AssembleConversations(utterances)
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		"# Transcript, %s\n",
+		conversations[0].Turns[0].Start.Format("2006-01-02 15:04"),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, conversation := range conversations {
+		if len(conversations) > 1 {
+			_, err := fmt.Fprintf(
+				w,
+				"\n## %s/%s\n",
+				conversation.GuildID,
+				conversation.ChannelID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		for _, turn := range conversation.Turns {
+			_, err := fmt.Fprintf(
+				w,
+				"\n**%s** (%s): %s\n",
+				turn.Label(),
+				turn.Start.Format("15:04:05"),
+				turn.Text(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeText writes a line per turn, with a heading per voice channel if
+// there are several.
+func writeText(w io.Writer, utterances []Utterance) error {
+	conversations := AssembleConversations(utterances)
+	for _, conversation := range conversations {
+		if len(conversations) > 1 {
+			_, err := fmt.Fprintf(
+				w,
+				"── %s/%s\n",
+				conversation.GuildID,
+				conversation.ChannelID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		for _, turn := range conversation.Turns {
+			_, err := fmt.Fprintf(
+				w,
+				"[%s] %s: %s\n",
+				turn.Start.Format("15:04:05"),
+				turn.Label(),
+				turn.Text(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	formatStr, _ := cmd.Flags().GetString("format")
+	sessionID, _ := cmd.Flags().GetInt64("session")
+	fromStr, _ := cmd.Flags().GetString("from")
+	toStr, _ := cmd.Flags().GetString("to")
+	guildID, _ := cmd.Flags().GetString("guild")
+	channelID, _ := cmd.Flags().GetString("channel")
+	output, _ := cmd.Flags().GetString("output")
+	version, _ := cmd.Flags().GetInt("transcript-version")
+
+	format, err := ParseExportFormat(formatStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	options := ExportOptions{
+		SessionID: sessionID,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Version:   version,
+	}
+	if sessionID == 0 {
+		if fromStr == "" || toStr == "" {
+			fmt.Fprintln(os.Stderr, "Either --session or both --from and --to are required")
+			os.Exit(1)
+		}
+		options.From, err = time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --from time: %v\n", err)
+			os.Exit(1)
+		}
+		options.To, err = time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --to time: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	sqlDB, queries, err := db.OpenDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	defer sqlDB.Close()
+
+	ctx := context.Background()
+	sentences, err := LoadExportSentences(ctx, queries, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	words, err := loadSentenceWords(ctx, queries, sentences)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	if err := WriteExport(out, format, sentences, words); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write export: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,138 @@
+package tts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+func exportSentence(userID, content string, start, end time.Duration) db.TranscriptSentence {
+	origin := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+	return db.TranscriptSentence{
+		UserID:    userID,
+		Content:   content,
+		StartTime: pgtype.Timestamptz{Time: origin.Add(start), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: origin.Add(end), Valid: true},
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		width    int
+		expected []string
+	}{
+		{"Short", "hello world", 42, []string{"hello world"}},
+		{"Wrapped", "one two three four", 9, []string{"one two", "three", "four"}},
+		{"Long Word", "a supercalifragilistic b", 5, []string{"a", "supercalifragilistic", "b"}},
+		{"Empty", "   ", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := wrapText(tt.text, tt.width)
+			if !reflect.DeepEqual(lines, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestFormatCueTime(t *testing.T) {
+	d := time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond
+	if got := formatCueTime(d, ','); got != "01:02:03,045" {
+		t.Errorf("Expected 01:02:03,045, got %s", got)
+	}
+	if got := formatCueTime(d, '.'); got != "01:02:03.045" {
+		t.Errorf("Expected 01:02:03.045, got %s", got)
+	}
+}
+
+func TestSubtitleCuesSplitLongSentences(t *testing.T) {
+	sentences := []db.TranscriptSentence{
+		exportSentence(
+			"alice",
+			"this sentence goes on and on for much longer than a single subtitle could ever hold on screen",
+			0,
+			10*time.Second,
+		),
+	}
+
+	cues := subtitleCues(sentences)
+	if len(cues) != 2 {
+		t.Fatalf("Expected 2 cues, got %d: %+v", len(cues), cues)
+	}
+	if len(cues[0].Lines) != subtitleMaxLines {
+		t.Errorf("Expected the first cue to be full, got %q", cues[0].Lines)
+	}
+	if cues[0].Start != 0 || cues[1].End != 10*time.Second {
+		t.Errorf("Expected cues to span the sentence, got %+v", cues)
+	}
+	if cues[0].End != cues[1].Start {
+		t.Errorf("Expected cues to meet, got %v and %v", cues[0].End, cues[1].Start)
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	sentences := []db.TranscriptSentence{
+		exportSentence("alice", "Hello there.", 0, 1500*time.Millisecond),
+		exportSentence("alice", "How are you?", 2*time.Second, 3*time.Second),
+		exportSentence("bob", "Fine.", 4*time.Second, 5*time.Second),
+	}
+
+	tests := []struct {
+		format   ExportFormat
+		expected string
+	}{
+		{
+			FormatSRT,
+			"1\n00:00:00,000 --> 00:00:01,500\nalice: Hello there.\n\n" +
+				"2\n00:00:02,000 --> 00:00:03,000\nalice: How are you?\n\n" +
+				"3\n00:00:04,000 --> 00:00:05,000\nbob: Fine.\n\n",
+		},
+		{
+			FormatVTT,
+			"WEBVTT\n\n" +
+				"00:00:00.000 --> 00:00:01.500\nalice: Hello there.\n\n" +
+				"00:00:02.000 --> 00:00:03.000\nalice: How are you?\n\n" +
+				"00:00:04.000 --> 00:00:05.000\nbob: Fine.\n\n",
+		},
+		{
+			FormatText,
+			"[18:00:00] alice: Hello there. How are you?\n" +
+				"[18:00:04] bob: Fine.\n",
+		},
+		{
+			FormatMarkdown,
+			"# Transcript, 2024-06-01 18:00\n" +
+				"\n**alice** (18:00:00): Hello there. How are you?\n" +
+				"\n**bob** (18:00:04): Fine.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteExport(&buf, tt.format, sentences, nil); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	if format, err := ParseExportFormat("SRT"); err != nil || format != FormatSRT {
+		t.Errorf("Expected srt, got %q (%v)", format, err)
+	}
+	if _, err := ParseExportFormat("docx"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
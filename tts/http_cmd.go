@@ -2,6 +2,8 @@ package tts
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,24 +11,29 @@ import (
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/spf13/cobra"
+	"node.town/archive"
 	"node.town/db"
-	"node.town/snd"
 )
 
 var HTTPCmd = &cobra.Command{
 	Use:   "http",
 	Short: "Start an HTTP server to display transcripts",
-	Long:  `This command starts an HTTP server that displays the past eight hours of transcripts using HTML rendering.`,
+	Long:  `This command starts an HTTP server for browsing transcripts by guild, channel, day and session, searching them and exporting them.`,
 	Run:   runHTTPServer,
 }
 
 func init() {
 	HTTPCmd.Flags().IntP("port", "p", 8080, "Port to run the HTTP server on")
+	HTTPCmd.Flags().
+		Bool("no-auth", false, "Let anyone who can reach the server see every guild")
 }
 
 func runHTTPServer(cmd *cobra.Command, args []string) {
 	port, _ := cmd.Flags().GetInt("port")
+	noAuth, _ := cmd.Flags().GetBool("no-auth")
 
 	sqlDB, queries, err := db.OpenDatabase()
 	if err != nil {
@@ -35,49 +42,202 @@ func runHTTPServer(cmd *cobra.Command, args []string) {
 	}
 	defer sqlDB.Close()
 
-	http.HandleFunc("/", handleTranscriptPage(queries))
-	http.HandleFunc("/audio/", handleAudioRequest(queries))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := newLiveHub()
+	if err := hub.run(ctx, sqlDB, queries); err != nil {
+		fmt.Printf("Failed to follow transcriptions: %v\n", err)
+		return
+	}
+
+	corrector := NewCorrector(sqlDB, queries)
+	clips := newClipCache(clipCacheBytes)
+
+	http.HandleFunc("/", handleGuildList(queries))
+	http.HandleFunc("/guilds/", handleGuildRoutes(queries))
+	http.HandleFunc("/sessions/", handleSessionPage(queries))
+	http.HandleFunc("/sentences/", handleSentencePage(corrector, queries))
+	http.HandleFunc("/audio/", handleAudioRequest(queries, clips))
+	http.HandleFunc("/search", handleSearchPage(queries))
+	http.HandleFunc("/export/", handleExportRequest(queries))
+	http.HandleFunc("/live", handleLivePage)
+	http.HandleFunc("/live/events", hub.handleLiveEvents)
+	http.HandleFunc("/api/v1/", handleAPI(corrector, queries, clips))
+
+	var handler http.Handler
+	if noAuth {
+		log.Warn("Serving every guild without authentication")
+		handler = openAccess(http.DefaultServeMux)
+	} else {
+		auth, err := newWebAuth(queries)
+		if err != nil {
+			fmt.Printf("Failed to set up login (or pass --no-auth): %v\n", err)
+			return
+		}
+		http.HandleFunc("/auth/login", auth.handleLogin)
+		http.HandleFunc("/auth/callback", auth.handleCallback)
+		http.HandleFunc("/auth/logout", auth.handleLogout)
+		handler = auth.middleware(http.DefaultServeMux)
+	}
 
 	fmt.Printf("Starting HTTP server on port %d...\n", port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
 	if err != nil {
 		fmt.Printf("Failed to start HTTP server: %v\n", err)
 	}
 }
 
-func handleTranscriptPage(queries *db.Queries) http.HandlerFunc {
+// utteranceClass styles an utterance by how sure the transcription was.
+func utteranceClass(utterance Utterance) string {
+	if utterance.Partial {
+		return "text-gray-500 italic"
+	}
+	switch getConfidenceStyle(utterance.Confidence) {
+	case StyleHighConfidence:
+		return "text-gray-900"
+	case StyleMediumConfidence:
+		return "text-yellow-700"
+	default:
+		return "text-red-700"
+	}
+}
+
+func handleSearchPage(queries *db.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		transcripts, err := LoadRecentTranscripts(queries)
+		query := r.URL.Query()
+		sinceStr := query.Get("since")
+
+		since, err := ParseSince(sinceStr, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+
+		viewer := viewerFrom(r.Context())
+		options := SearchOptions{
+			Query:    query.Get("q"),
+			UserID:   query.Get("user"),
+			GuildID:  query.Get("guild"),
+			GuildIDs: viewer.GuildIDs(),
+			Since:    since,
+		}
+		if limit, err := strconv.Atoi(query.Get("limit")); err == nil {
+			options.Limit = limit
+		}
+		if version, err := strconv.Atoi(query.Get("version")); err == nil {
+			options.Version = version
+		}
+
+		results, err := SearchTranscripts(r.Context(), queries, options)
 		if err != nil {
+			log.Error("Failed to search transcripts", "error", err)
 			http.Error(
 				w,
-				fmt.Sprintf("Failed to load transcripts: %v", err),
+				"Failed to search transcripts",
 				http.StatusInternalServerError,
 			)
 			return
 		}
 
-		builder := NewTranscriptBuilder()
-		for _, segment := range transcripts {
-			builder.AppendWords(segment.Words, false)
+		visible := results[:0]
+		for _, result := range results {
+			if viewer.CanSeeChannel(r.Context(), result.GuildID, result.ChannelID) {
+				visible = append(visible, result)
+			}
 		}
+		results = visible
 
-		html, err := builder.RenderHTML()
+		w.Header().Set("Content-Type", "text/html")
+		err = SearchTemplate(options, sinceStr, results).Render(r.Context(), w)
 		if err != nil {
+			log.Error("Failed to render search page", "error", err)
+		}
+	}
+}
+
+// handleExportRequest serves /export/{format} for ?session=ID, or for
+// ?from=...&to=... with optional guild, channel and version.
+func handleExportRequest(queries *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := ParseExportFormat(strings.TrimPrefix(r.URL.Path, "/export/"))
+		if err != nil {
+			http.Error(w, "Invalid export format", http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		options := ExportOptions{
+			GuildID:   query.Get("guild"),
+			ChannelID: query.Get("channel"),
+		}
+		if session := query.Get("session"); session != "" {
+			options.SessionID, err = strconv.ParseInt(session, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid session ID", http.StatusBadRequest)
+				return
+			}
+		} else {
+			if version := query.Get("version"); version != "" {
+				options.Version, err = strconv.Atoi(version)
+				if err != nil || options.Version < 1 {
+					http.Error(w, "Invalid version", http.StatusBadRequest)
+					return
+				}
+			}
+			options.From, err = time.Parse(time.RFC3339, query.Get("from"))
+			if err != nil {
+				http.Error(w, "Invalid from time", http.StatusBadRequest)
+				return
+			}
+			options.To, err = time.Parse(time.RFC3339, query.Get("to"))
+			if err != nil {
+				http.Error(w, "Invalid to time", http.StatusBadRequest)
+				return
+			}
+		}
+
+		sentences, err := LoadExportSentences(r.Context(), queries, options)
+		if err != nil {
+			log.Error("Failed to load export", "error", err)
 			http.Error(
 				w,
-				"Failed to render HTML",
+				"Failed to load transcript",
 				http.StatusInternalServerError,
 			)
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprint(w, html)
+		viewer := viewerFrom(r.Context())
+		visible := sentences[:0]
+		for _, sentence := range sentences {
+			if viewer.CanSeeChannel(r.Context(), sentence.GuildID, sentence.ChannelID) {
+				visible = append(visible, sentence)
+			}
+		}
+		sentences = visible
+
+		words, err := loadSentenceWords(r.Context(), queries, sentences)
+		if err != nil {
+			log.Error("Failed to load export", "error", err)
+			http.Error(
+				w,
+				"Failed to load transcript",
+				http.StatusInternalServerError,
+			)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().
+			Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", options.FileName(format)))
+		if err := WriteExport(w, format, sentences, words); err != nil {
+			log.Error("Failed to write export", "error", err)
+		}
 	}
 }
 
-func handleAudioRequest(queries *db.Queries) http.HandlerFunc {
+func handleAudioRequest(queries *db.Queries, clips *clipCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(r.URL.Path, "/")
 		if len(parts) != 5 {
@@ -103,84 +263,133 @@ func handleAudioRequest(queries *db.Queries) http.HandlerFunc {
 			return
 		}
 
-		// Fetch the SSRC for the given session ID
-		ssrc, err := queries.GetSSRCForSession(r.Context(), sessionID)
-		if err != nil {
-			http.Error(
-				w,
-				"Failed to get SSRC for session",
-				http.StatusInternalServerError,
-			)
-			return
-		}
+		serveAudioClip(w, r, queries, clips, sessionID, startTime, endTime)
+	}
+}
 
-		// Fetch opus packets for the given time range
-		packets, err := queries.GetOpusPacketsForTimeRange(
+// serveAudioClip serves what was recorded of a session between two
+// times as an Ogg file, from the database or from the archive. Finished
+// clips are encoded once and then served from clips.
+func serveAudioClip(
+	w http.ResponseWriter,
+	r *http.Request,
+	queries *db.Queries,
+	clips *clipCache,
+	sessionID int64,
+	startTime, endTime time.Time,
+) {
+	session, err := visibleSession(r.Context(), queries, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("Failed to load session", "error", err)
+		http.Error(w, "Failed to load session", http.StatusInternalServerError)
+		return
+	}
+
+	name := fmt.Sprintf(
+		"audio_%d_%s_%s.ogg",
+		sessionID,
+		startTime.Format(time.RFC3339),
+		endTime.Format(time.RFC3339),
+	)
+	finished := endTime.Before(time.Now())
+	if oggData, ok := clips.Get(oggETag(name)); finished && ok {
+		serveOgg(w, r, name, finished, oggData)
+		return
+	}
+
+	// SSRCs are only unique within a voice channel, so fetch the opus
+	// packets of the session's channel for the given time range
+	packets, err := queries.GetOpusPacketsForTimeRange(
+		r.Context(),
+		db.GetOpusPacketsForTimeRangeParams{
+			GuildID:     session.GuildID,
+			ChannelID:   session.ChannelID,
+			Ssrc:        session.Ssrc,
+			CreatedAt:   pgtype.Timestamptz{Time: startTime, Valid: true},
+			CreatedAt_2: pgtype.Timestamptz{Time: endTime, Valid: true},
+		},
+	)
+	if err != nil {
+		http.Error(
+			w,
+			"Failed to fetch opus packets",
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	// Packets of archived sessions have been moved out of the database
+	if len(packets) == 0 {
+		packets, err = archive.LoadClip(
 			r.Context(),
-			ssrc,
+			queries,
+			session.GuildID,
+			session.ChannelID,
+			session.Ssrc,
 			startTime,
 			endTime,
 		)
 		if err != nil {
+			log.Error("Failed to load archived audio", "error", err)
 			http.Error(
 				w,
-				"Failed to fetch opus packets",
-				http.StatusInternalServerError,
-			)
-			return
-		}
-
-		// Generate OGG file
-		oggData, err := generateOggFile(packets)
-		if err != nil {
-			http.Error(
-				w,
-				"Failed to generate OGG file",
+				"Failed to load archived audio",
 				http.StatusInternalServerError,
 			)
 			return
 		}
-
-		w.Header().Set("Content-Type", "audio/ogg")
-		w.Header().
-			Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audio_%d_%s_%s.ogg\"", sessionID, startTime.Format(time.RFC3339), endTime.Format(time.RFC3339)))
-		w.Write(oggData)
 	}
-}
-
-func generateOggFile(packets []db.OpusPacket) ([]byte, error) {
-	var buf bytes.Buffer
-	oggWriter, err := snd.NewOggWriter(&buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create OGG writer: %w", err)
+	if len(packets) == 0 {
+		http.Error(w, "No audio in this time range", http.StatusNotFound)
+		return
 	}
 
-	ogg, err := snd.NewOgg(
-		packets[0].Ssrc,
-		packets[0].CreatedAt.Time,
-		packets[len(packets)-1].CreatedAt.Time,
-		oggWriter,
-		&snd.RealTimeProvider{},
-		log.Default(),
-	)
+	// Generate OGG file
+	oggData, err := archive.EncodeOgg(packets)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OGG: %w", err)
+		http.Error(
+			w,
+			"Failed to generate OGG file",
+			http.StatusInternalServerError,
+		)
+		return
 	}
-
-	for _, dbPacket := range packets {
-		packet := snd.OpusPacket{
-			ID:        int(dbPacket.ID),
-			Sequence:  uint16(dbPacket.Sequence),
-			Timestamp: uint32(dbPacket.Timestamp),
-			CreatedAt: dbPacket.CreatedAt.Time,
-			OpusData:  dbPacket.OpusData,
-		}
-		ogg.WritePacket(packet)
+	if finished {
+		clips.Put(oggETag(name), oggData)
 	}
 
-	if err := ogg.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close OGG: %w", err)
+	serveOgg(w, r, name, finished, oggData)
+}
+
+// serveOgg serves an Ogg file so that players can stream and seek in it
+// with range requests. It is shown inline unless ?download is given. A
+// finished file doesn't change, so its name makes a fine ETag.
+func serveOgg(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	finished bool,
+	data []byte,
+) {
+	disposition := "inline"
+	if r.URL.Query().Has("download") {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "audio/ogg")
+	w.Header().
+		Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, name))
+	if finished {
+		w.Header().Set("ETag", oggETag(name))
+		w.Header().Set("Cache-Control", "private, max-age=3600")
 	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
 
-	return buf.Bytes(), nil
+// oggETag is the ETag of a finished Ogg file.
+func oggETag(name string) string {
+	return fmt.Sprintf("%q", name)
 }
@@ -0,0 +1,109 @@
+package tts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeOgg(t *testing.T) {
+	data := []byte("OggS0123456789")
+
+	tests := []struct {
+		name                string
+		path                string
+		finished            bool
+		rangeHeader         string
+		expectedStatus      int
+		expectedBody        string
+		expectedDisposition string
+		expectedETag        string
+	}{
+		{
+			"Whole File",
+			"/audio/1/a/b",
+			true,
+			"",
+			http.StatusOK,
+			string(data),
+			`inline; filename="clip.ogg"`,
+			`"clip.ogg"`,
+		},
+		{
+			"Range",
+			"/audio/1/a/b",
+			true,
+			"bytes=4-7",
+			http.StatusPartialContent,
+			"0123",
+			`inline; filename="clip.ogg"`,
+			`"clip.ogg"`,
+		},
+		{
+			"Download",
+			"/audio/1/a/b?download",
+			false,
+			"",
+			http.StatusOK,
+			string(data),
+			`attachment; filename="clip.ogg"`,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			serveOgg(w, r, "clip.ogg", tt.finished, data)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Body.String() != tt.expectedBody {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.expectedDisposition {
+				t.Errorf("Expected disposition %s, got %s", tt.expectedDisposition, got)
+			}
+			if got := w.Header().Get("ETag"); got != tt.expectedETag {
+				t.Errorf("Expected ETag %s, got %s", tt.expectedETag, got)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Expected range support, got Accept-Ranges %q", got)
+			}
+		})
+	}
+}
+
+func TestClipCache(t *testing.T) {
+	cache := newClipCache(8)
+	cache.Put(`"a"`, []byte("aaaa"))
+	cache.Put(`"b"`, []byte("bbbb"))
+
+	// Using a makes b the one to drop when c comes in
+	if data, ok := cache.Get(`"a"`); !ok || string(data) != "aaaa" {
+		t.Errorf("Expected a to be cached, got %q", data)
+	}
+	cache.Put(`"c"`, []byte("cccc"))
+	if _, ok := cache.Get(`"b"`); ok {
+		t.Errorf("Expected b to be dropped")
+	}
+	if _, ok := cache.Get(`"a"`); !ok {
+		t.Errorf("Expected a to stay cached")
+	}
+
+	cache.Put(`"d"`, []byte("too large"))
+	if _, ok := cache.Get(`"d"`); ok {
+		t.Errorf("Expected a clip over the limit not to be cached")
+	}
+
+	var none *clipCache
+	none.Put(`"a"`, []byte("aaaa"))
+	if _, ok := none.Get(`"a"`); ok {
+		t.Errorf("Expected a nil cache to keep nothing")
+	}
+}
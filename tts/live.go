@@ -0,0 +1,239 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"node.town/db"
+	"node.town/snd"
+)
+
+const (
+	// liveBufferSize is how many segments a browser may fall behind by
+	// before it is dropped and has to reconnect.
+	liveBufferSize = 100
+
+	// liveKeepAlive is how often an idle event stream gets a comment, so
+	// that proxies don't close it.
+	liveKeepAlive = 30 * time.Second
+)
+
+// LiveSegment is a transcript segment as sent to browsers following a
+// meeting live. A partial segment is replaced by the next segment of
+// the same session.
+type LiveSegment struct {
+	SessionID int64     `json:"session_id"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	UserID    string    `json:"user_id"`
+	IsFinal   bool      `json:"is_final"`
+	Start     time.Time `json:"start"`
+	Text      string    `json:"text"`
+}
+
+func liveSegmentFrom(segment TranscriptSegment) LiveSegment {
+	live := LiveSegment{
+		SessionID: segment.SessionID,
+		GuildID:   segment.GuildID,
+		ChannelID: segment.ChannelID,
+		UserID:    segment.UserID,
+		IsFinal:   segment.IsFinal,
+	}
+	if len(segment.Words) > 0 {
+		live.Start = segment.Words[0].AbsoluteStartTime
+	}
+
+	var text strings.Builder
+	for i, word := range segment.Words {
+		if i > 0 && word.AttachesTo != "previous" {
+			text.WriteByte(' ')
+		}
+		text.WriteString(word.Content)
+	}
+	live.Text = text.String()
+	return live
+}
+
+// liveHub fans transcript segments out to the browsers following them.
+type liveHub struct {
+	mu          sync.Mutex
+	subscribers map[chan LiveSegment]struct{}
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{subscribers: make(map[chan LiveSegment]struct{})}
+}
+
+func (h *liveHub) subscribe() chan LiveSegment {
+	ch := make(chan LiveSegment, liveBufferSize)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *liveHub) unsubscribe(ch chan LiveSegment) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends a segment to every subscriber. A subscriber too far
+// behind is dropped rather than holding up the others; its browser
+// reconnects.
+func (h *liveHub) publish(segment LiveSegment) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- segment:
+		default:
+			log.Warn("Dropping slow live transcript subscriber")
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// run publishes transcription changes until ctx is canceled.
+func (h *liveHub) run(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	queries *db.Queries,
+) error {
+	updates, err := snd.ListenForTranscriptionChanges(ctx, pool)
+	if err != nil {
+		return fmt.Errorf(
+			"failed to set up transcription change listener: %w",
+			err,
+		)
+	}
+
+	segments := make(chan TranscriptSegment, liveBufferSize)
+	go func() {
+		defer close(segments)
+		for update := range updates {
+			handleTranscriptionUpdate(ctx, update, queries, segments)
+		}
+	}()
+
+	go func() {
+		for segment := range segments {
+			h.publish(liveSegmentFrom(segment))
+		}
+	}()
+
+	return nil
+}
+
+// handleLiveEvents serves the transcript segments of a guild or channel
+// as server-sent events while they are transcribed.
+func (h *liveHub) handleLiveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	guildID := r.URL.Query().Get("guild")
+	channelID := r.URL.Query().Get("channel")
+	viewer := viewerFrom(r.Context())
+
+	segments := h.subscribe()
+	defer h.unsubscribe(segments)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case segment, ok := <-segments:
+			if !ok {
+				return
+			}
+			if guildID != "" && segment.GuildID != guildID ||
+				channelID != "" && segment.ChannelID != channelID ||
+				!viewer.CanSeeChannel(r.Context(), segment.GuildID, segment.ChannelID) {
+				continue
+			}
+
+			data, err := json.Marshal(segment)
+			if err != nil {
+				log.Error("Failed to marshal live segment", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: segment\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// LivePage is the page following a guild's or channel's meeting live.
+type LivePage struct {
+	Title     string
+	Crumbs    []Crumb
+	EventsURL string
+}
+
+func liveURL(path, guildID, channelID string) string {
+	query := url.Values{}
+	if guildID != "" {
+		query.Set("guild", guildID)
+	}
+	if channelID != "" {
+		query.Set("channel", channelID)
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+func handleLivePage(w http.ResponseWriter, r *http.Request) {
+	guildID := r.URL.Query().Get("guild")
+	channelID := r.URL.Query().Get("channel")
+
+	page := LivePage{
+		Title:     "Live",
+		Crumbs:    []Crumb{{Name: "Guilds", URL: "/"}},
+		EventsURL: liveURL("/live/events", guildID, channelID),
+	}
+	if guildID != "" {
+		page.Crumbs = append(page.Crumbs, Crumb{Name: guildID, URL: guildURL(guildID)})
+		if channelID != "" {
+			page.Crumbs = append(page.Crumbs, Crumb{
+				Name: channelID,
+				URL:  channelURL(guildID, channelID),
+			})
+		}
+	}
+	renderPage(w, r, LiveTemplate(page))
+}
@@ -0,0 +1,103 @@
+package tts
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveSegmentFrom(t *testing.T) {
+	segment := TranscriptSegment{
+		SessionID: 3,
+		UserID:    "alice",
+		IsFinal:   true,
+		Words: []TranscriptWord{
+			word("Hello", 1, false),
+			{Content: ",", AttachesTo: "previous"},
+			word("world", 2, true),
+		},
+	}
+
+	live := liveSegmentFrom(segment)
+	if live.Text != "Hello, world" {
+		t.Errorf("Expected \"Hello, world\", got %q", live.Text)
+	}
+	if !live.Start.Equal(segment.Words[0].AbsoluteStartTime) {
+		t.Errorf("Expected the segment to start with its first word, got %v", live.Start)
+	}
+	if live.SessionID != 3 || live.UserID != "alice" || !live.IsFinal {
+		t.Errorf("Expected the segment's session, speaker and finality, got %+v", live)
+	}
+}
+
+func TestLiveHubDropsSlowSubscribers(t *testing.T) {
+	hub := newLiveHub()
+	slow := hub.subscribe()
+
+	for i := 0; i < liveBufferSize+1; i++ {
+		hub.publish(LiveSegment{SessionID: int64(i)})
+	}
+
+	count := 0
+	for range slow {
+		count++
+	}
+	if count != liveBufferSize {
+		t.Errorf("Expected %d buffered segments before the drop, got %d", liveBufferSize, count)
+	}
+
+	// Unsubscribing a dropped subscriber is harmless
+	hub.unsubscribe(slow)
+}
+
+func TestHandleLiveEvents(t *testing.T) {
+	hub := newLiveHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = withViewer(ctx, &Viewer{UserID: "42", guilds: map[string]bool{"g": true}})
+	request := httptest.NewRequest("GET", "/live/events?channel=general", nil).
+		WithContext(ctx)
+	recorder := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hub.handleLiveEvents(recorder, request)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mu.Lock()
+		subscribed := len(hub.subscribers) == 1
+		hub.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the handler to subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	hub.publish(LiveSegment{GuildID: "g", ChannelID: "random", Text: "elsewhere"})
+	hub.publish(LiveSegment{GuildID: "other", ChannelID: "general", Text: "hidden"})
+	hub.publish(LiveSegment{GuildID: "g", ChannelID: "general", Text: "here"})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	body := recorder.Body.String()
+	if recorder.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "event: segment\ndata: ") || !strings.Contains(body, `"text":"here"`) {
+		t.Errorf("Expected the channel's segment, got %q", body)
+	}
+	if strings.Contains(body, "hidden") {
+		t.Errorf("Expected no segments of guilds the viewer isn't in, got %q", body)
+	}
+	if strings.Contains(body, "elsewhere") {
+		t.Errorf("Expected other channels to be filtered out, got %q", body)
+	}
+}
@@ -23,6 +23,9 @@ type TranscriptWord struct {
 // TranscriptSegment represents a segment of transcription, which may be partial or final
 type TranscriptSegment struct {
 	SessionID int64            // ID of the transcription session
+	GuildID   string           // Guild of the voice channel
+	ChannelID string           // Voice channel the session was in
+	UserID    string           // Speaker of the session
 	IsFinal   bool             // Indicates if this is a final transcription
 	Words     []TranscriptWord // The words in this segment
 }
@@ -41,11 +44,19 @@ func ConvertDBRowsToTranscriptSegments(
 ) []TranscriptSegment {
 	segmentMap := make(map[int64]TranscriptSegment)
 
-	for _, row := range rows {
+	for i, row := range rows {
+		// Rows come once per alternative, the most confident first
+		if i > 0 && rows[i-1].WordID == row.WordID {
+			continue
+		}
+
 		segment, ok := segmentMap[row.ID]
 		if !ok {
 			segment = TranscriptSegment{
 				SessionID: row.SessionID,
+				GuildID:   row.GuildID,
+				ChannelID: row.ChannelID,
+				UserID:    row.UserID,
 				IsFinal:   row.IsFinal,
 				Words:     []TranscriptWord{},
 			}
@@ -76,17 +87,19 @@ func ConvertDBRowsToTranscriptSegments(
 	return segments
 }
 
+// recentTranscriptWindow is how far back the stream UI looks.
+const recentTranscriptWindow = 16 * time.Hour
+
 func LoadRecentTranscripts(
 	dbQueries *db.Queries,
 ) ([]TranscriptSegment, error) {
-	// Fetch transcripts from the last 8 hours
-	eightHoursAgo := time.Now().Add(-16 * time.Hour)
+	since := time.Now().Add(-recentTranscriptWindow)
 
 	segments, err := dbQueries.GetTranscripts(
 		context.Background(),
 		db.GetTranscriptsParams{
 			SegmentID: pgtype.Int8{Valid: false},
-			CreatedAt: pgtype.Timestamptz{Time: eightHoursAgo, Valid: true},
+			CreatedAt: pgtype.Timestamptz{Time: since, Valid: true},
 		},
 	)
 	if err != nil {
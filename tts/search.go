@@ -0,0 +1,283 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/cobra"
+	"node.town/db"
+)
+
+var SearchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the transcripts",
+	Long: `This command searches the final transcripts for the given words. The query
+uses web search syntax: "quoted phrases", -excluded words and OR.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runSearch,
+}
+
+func init() {
+	SearchCmd.Flags().StringP("user", "u", "", "Only lines spoken by this user ID")
+	SearchCmd.Flags().StringP("guild", "g", "", "Only lines from this guild ID")
+	SearchCmd.Flags().
+		StringP("since", "s", "", "Only lines since a time (RFC3339, a date, or an age like 7d or 36h)")
+	SearchCmd.Flags().IntP("limit", "n", 20, "Maximum number of results")
+	SearchCmd.Flags().
+		Int("transcript-version", 0, "Search this transcript version (default the latest)")
+}
+
+// defaultSearchLimit caps a search when the caller doesn't.
+const defaultSearchLimit = 20
+
+// SearchOptions narrows a transcript search.
+type SearchOptions struct {
+	Query    string
+	UserID   string
+	GuildID  string
+	GuildIDs []string // Guilds to search in, or nil for all of them
+	Since    time.Time
+	Limit    int
+	Version  int // Transcript version to search, 0 for the latest
+}
+
+// VersionValue fills in the version field of the search form.
+func (o SearchOptions) VersionValue() string {
+	return versionValue(o.Version)
+}
+
+// SearchPart is a piece of a matching line, marked if it matched the
+// query.
+type SearchPart struct {
+	Content string
+	Match   bool
+}
+
+// SearchResult is a transcript sentence that matched a search, with the
+// sentences spoken just before and after it in the same channel.
+type SearchResult struct {
+	SentenceID int64
+	SessionID  int64
+	GuildID    string
+	ChannelID  string
+	UserID     string
+	StartTime  time.Time
+	EndTime    time.Time
+	Parts      []SearchPart
+	Rank       float32
+
+	BeforeUserID string
+	Before       string
+	AfterUserID  string
+	After        string
+}
+
+// AudioURL is where the HTTP server serves the audio of the line.
+func (r SearchResult) AudioURL() string {
+	return AudioURL(r.SessionID, r.StartTime, r.EndTime)
+}
+
+// TranscriptURL links to the line in its channel's transcript.
+func (r SearchResult) TranscriptURL() string {
+	page := TranscriptPage{Path: channelTranscriptURL(r.GuildID, r.ChannelID)}
+	return page.LineURL(Utterance{ID: r.SentenceID, SentenceStart: r.StartTime})
+}
+
+// AudioURL is where the HTTP server serves a clip of a session.
+func AudioURL(sessionID int64, start, end time.Time) string {
+	return fmt.Sprintf(
+		"/audio/%d/%s/%s",
+		sessionID,
+		start.Format(time.RFC3339),
+		end.Format(time.RFC3339),
+	)
+}
+
+// SearchTranscripts finds the transcript sentences matching a query,
+// newest first.
+func SearchTranscripts(
+	ctx context.Context,
+	queries *db.Queries,
+	options SearchOptions,
+) ([]SearchResult, error) {
+	if strings.TrimSpace(options.Query) == "" {
+		return nil, nil
+	}
+	if options.Limit <= 0 {
+		options.Limit = defaultSearchLimit
+	}
+
+	rows, err := queries.SearchTranscripts(ctx, db.SearchTranscriptsParams{
+		Query: options.Query,
+		Version: pgtype.Int4{
+			Int32: int32(options.Version),
+			Valid: options.Version > 0,
+		},
+		UserID:   pgtype.Text{String: options.UserID, Valid: options.UserID != ""},
+		GuildID:  pgtype.Text{String: options.GuildID, Valid: options.GuildID != ""},
+		GuildIds: options.GuildIDs,
+		Since: pgtype.Timestamptz{
+			Time:  options.Since,
+			Valid: !options.Since.IsZero(),
+		},
+		MaxResults: int32(options.Limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search transcripts: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, SearchResult{
+			SentenceID:   row.SentenceID,
+			SessionID:    row.SessionID,
+			GuildID:      row.GuildID,
+			ChannelID:    row.ChannelID,
+			UserID:       row.UserID,
+			StartTime:    row.StartTime.Time,
+			EndTime:      row.EndTime.Time,
+			Parts:        splitHeadline(row.Headline),
+			Rank:         row.Rank,
+			BeforeUserID: row.BeforeUserID,
+			Before:       row.BeforeContent,
+			AfterUserID:  row.AfterUserID,
+			After:        row.AfterContent,
+		})
+	}
+	return results, nil
+}
+
+// splitHeadline splits a headline from ts_headline, whose matches are
+// wrapped in << and >>, into parts.
+func splitHeadline(headline string) []SearchPart {
+	var parts []SearchPart
+	for headline != "" {
+		start := strings.Index(headline, "<<")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(headline[start+2:], ">>")
+		if end < 0 {
+			break
+		}
+		if start > 0 {
+			parts = append(parts, SearchPart{Content: headline[:start]})
+		}
+		parts = append(parts, SearchPart{
+			Content: headline[start+2 : start+2+end],
+			Match:   true,
+		})
+		headline = headline[start+2+end+2:]
+	}
+	if headline != "" {
+		parts = append(parts, SearchPart{Content: headline})
+	}
+	return parts
+}
+
+// ParseSince reads the start of a search window: an RFC3339 time, a
+// date, or an age such as 7d or 36h counted back from now.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func runSearch(cmd *cobra.Command, args []string) {
+	userID, _ := cmd.Flags().GetString("user")
+	guildID, _ := cmd.Flags().GetString("guild")
+	sinceStr, _ := cmd.Flags().GetString("since")
+	limit, _ := cmd.Flags().GetInt("limit")
+	version, _ := cmd.Flags().GetInt("transcript-version")
+
+	since, err := ParseSince(sinceStr, time.Now())
+	if err != nil {
+		fmt.Printf("Failed to parse --since: %v\n", err)
+		return
+	}
+
+	sqlDB, queries, err := db.OpenDatabase()
+	if err != nil {
+		fmt.Printf("Failed to open database: %v\n", err)
+		return
+	}
+	defer sqlDB.Close()
+
+	results, err := SearchTranscripts(
+		context.Background(),
+		queries,
+		SearchOptions{
+			Query:   strings.Join(args, " "),
+			UserID:  userID,
+			GuildID: guildID,
+			Since:   since,
+			Limit:   limit,
+			Version: version,
+		},
+	)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No matching lines found.")
+		return
+	}
+
+	timeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	userStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+	matchStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214"))
+	contextStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+
+	for _, result := range results {
+		fmt.Printf(
+			"%s %s\n",
+			timeStyle.Render(result.StartTime.Local().Format("2006-01-02 15:04:05")),
+			timeStyle.Render(result.AudioURL()),
+		)
+		if result.Before != "" {
+			fmt.Println(contextStyle.Render(
+				fmt.Sprintf("  %s: %s", result.BeforeUserID, result.Before),
+			))
+		}
+
+		var line strings.Builder
+		for _, part := range result.Parts {
+			if part.Match {
+				line.WriteString(matchStyle.Render(part.Content))
+			} else {
+				line.WriteString(part.Content)
+			}
+		}
+		fmt.Printf("  %s: %s\n", userStyle.Render(result.UserID), line.String())
+
+		if result.After != "" {
+			fmt.Println(contextStyle.Render(
+				fmt.Sprintf("  %s: %s", result.AfterUserID, result.After),
+			))
+		}
+		fmt.Println()
+	}
+}
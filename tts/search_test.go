@@ -0,0 +1,85 @@
+package tts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitHeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		headline string
+		expected []SearchPart
+	}{
+		{"No Match", "hello world", []SearchPart{{Content: "hello world"}}},
+		{
+			"Match In Middle",
+			"we talked about <<pizza>> last week",
+			[]SearchPart{
+				{Content: "we talked about "},
+				{Content: "pizza", Match: true},
+				{Content: " last week"},
+			},
+		},
+		{
+			"Adjacent Matches",
+			"<<pizza>> <<oven>>",
+			[]SearchPart{
+				{Content: "pizza", Match: true},
+				{Content: " "},
+				{Content: "oven", Match: true},
+			},
+		},
+		{
+			"Unclosed Marker",
+			"a <<b",
+			[]SearchPart{{Content: "a <<b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitHeadline(tt.headline)
+			if !reflect.DeepEqual(parts, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, parts)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"Empty", "", time.Time{}, false},
+		{"RFC3339", "2024-06-01T18:00:00Z", time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), false},
+		{"Date", "2024-06-01", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"Days", "7d", now.AddDate(0, 0, -7), false},
+		{"Duration", "36h", now.Add(-36 * time.Hour), false},
+		{"Invalid", "last week", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			since, err := ParseSince(tt.input, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !since.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, since)
+			}
+		})
+	}
+}
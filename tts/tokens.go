@@ -0,0 +1,164 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/cobra"
+	"node.town/db"
+)
+
+var TokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for scripts",
+	Long: `API tokens let scripts use the HTTP server's API as a user, sending
+"Authorization: Bearer <token>". A token sees the guilds its user was in when
+they last logged in, so the user has to have logged in once.`,
+}
+
+func init() {
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create an API token for a user",
+		Run:   runTokenCreate,
+	}
+	createCmd.Flags().StringP("user", "u", "", "Discord user ID of the token's user")
+	createCmd.Flags().StringP("name", "n", "", "What the token is for")
+	createCmd.MarkFlagRequired("user")
+	createCmd.MarkFlagRequired("name")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List API tokens",
+		Run:   runTokenList,
+	}
+	listCmd.Flags().StringP("user", "u", "", "Only tokens of this Discord user ID")
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke [token ID]",
+		Short: "Revoke an API token",
+		Args:  cobra.ExactArgs(1),
+		Run:   runTokenRevoke,
+	}
+
+	TokenCmd.AddCommand(createCmd, listCmd, revokeCmd)
+}
+
+func openTokenDatabase() (*db.Queries, func()) {
+	sqlDB, queries, err := db.OpenDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		os.Exit(1)
+	}
+	return queries, sqlDB.Close
+}
+
+// CreateAPIToken makes a token for a user and returns it. Only its hash
+// is stored, so it can't be shown again.
+func CreateAPIToken(
+	ctx context.Context,
+	queries *db.Queries,
+	userID, name string,
+) (int64, string, error) {
+	if _, err := queries.GetWebUser(ctx, userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", fmt.Errorf("user %s has never logged in", userID)
+		}
+		return 0, "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		return 0, "", err
+	}
+	token := apiTokenPrefix + secret
+
+	id, err := queries.CreateAPIToken(ctx, db.CreateAPITokenParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to save API token: %w", err)
+	}
+	return id, token, nil
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) {
+	userID, _ := cmd.Flags().GetString("user")
+	name, _ := cmd.Flags().GetString("name")
+
+	queries, closeDB := openTokenDatabase()
+	defer closeDB()
+
+	id, token, err := CreateAPIToken(context.Background(), queries, userID, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create token: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Created token %d. It won't be shown again:\n", id)
+	fmt.Println(token)
+}
+
+func runTokenList(cmd *cobra.Command, args []string) {
+	userID, _ := cmd.Flags().GetString("user")
+
+	queries, closeDB := openTokenDatabase()
+	defer closeDB()
+
+	tokens, err := queries.ListAPITokens(
+		context.Background(),
+		pgtype.Text{String: userID, Valid: userID != ""},
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list tokens: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, token := range tokens {
+		status := "never used"
+		if token.LastUsedAt.Valid {
+			status = "last used " + token.LastUsedAt.Time.Format(time.RFC3339)
+		}
+		if token.RevokedAt.Valid {
+			status = "revoked " + token.RevokedAt.Time.Format(time.RFC3339)
+		}
+		fmt.Printf(
+			"%d\t%s\t%s\tcreated %s, %s\n",
+			token.ID,
+			token.UserID,
+			token.Name,
+			token.CreatedAt.Time.Format(time.RFC3339),
+			status,
+		)
+	}
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid token ID: %s\n", args[0])
+		os.Exit(1)
+	}
+
+	queries, closeDB := openTokenDatabase()
+	defer closeDB()
+
+	revoked, err := queries.RevokeAPIToken(context.Background(), id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to revoke token: %v\n", err)
+		os.Exit(1)
+	}
+	if revoked == 0 {
+		fmt.Fprintf(os.Stderr, "No active token %d\n", id)
+		os.Exit(1)
+	}
+	fmt.Printf("Revoked token %d\n", id)
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -67,10 +68,24 @@ func (s *SpeechmaticsService) CloseWebSocket() error {
 	return s.client.CloseWebSocket()
 }
 
+// finalTranscriptTimeout is how long a stream waits for the last
+// transcripts after it ends.
+const finalTranscriptTimeout = 30 * time.Second
+
+// DefaultTranscriptionConfig is the configuration streams are
+// transcribed with unless the handler is given another.
+func DefaultTranscriptionConfig() speechmatics.TranscriptionConfig {
+	return speechmatics.TranscriptionConfig{
+		Language:       "en",
+		EnablePartials: true,
+	}
+}
+
 type TranscriptionHandler struct {
 	queries *db.Queries
 	pool    *pgxpool.Pool
 	service TranscriptionService
+	config  speechmatics.TranscriptionConfig
 }
 
 func NewTranscriptionHandler(
@@ -82,7 +97,35 @@ func NewTranscriptionHandler(
 		queries: queries,
 		pool:    pool,
 		service: service,
+		config:  DefaultTranscriptionConfig(),
+	}
+}
+
+// SetConfig changes the configuration later streams are transcribed
+// with.
+func (h *TranscriptionHandler) SetConfig(config speechmatics.TranscriptionConfig) {
+	h.config = config
+}
+
+// UseGuildVocabulary adds the vocabulary learned from corrections to a
+// guild's transcripts to the configuration later streams are transcribed
+// with.
+func (h *TranscriptionHandler) UseGuildVocabulary(
+	ctx context.Context,
+	guildID string,
+) error {
+	vocab, err := GuildVocabulary(ctx, h.queries, guildID)
+	if err != nil {
+		return err
 	}
+	if len(vocab) == 0 {
+		return nil
+	}
+	h.config.AdditionalVocab = append(
+		slices.Clone(h.config.AdditionalVocab),
+		vocab...,
+	)
+	return nil
 }
 
 func (h *TranscriptionHandler) HandleTranscript(
@@ -158,6 +201,21 @@ func (h *TranscriptionHandler) HandleTranscript(
 		}
 	}
 
+	// Final segments keep their text and sentences for reading
+	if !transcript.IsPartial() {
+		err = qtx.RefreshTranscriptionSegmentContent(ctx, segmentID)
+		if err != nil {
+			return fmt.Errorf(
+				"failed to refresh transcription segment content: %w",
+				err,
+			)
+		}
+		err = qtx.RefreshTranscriptSentences(ctx, segmentID)
+		if err != nil {
+			return fmt.Errorf("failed to refresh transcript sentences: %w", err)
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -178,15 +236,11 @@ func (h *TranscriptionHandler) ProcessAudioStream(
 	stream <-chan snd.OpusPacketNotification,
 	sessionID int64,
 ) error {
-	config := speechmatics.TranscriptionConfig{
-		Language:       "en",
-		EnablePartials: true,
-	}
 	audioFormat := speechmatics.AudioFormat{
 		Type: "file",
 	}
 
-	if err := h.service.ConnectWebSocket(ctx, config, audioFormat); err != nil {
+	if err := h.service.ConnectWebSocket(ctx, h.config, audioFormat); err != nil {
 		return fmt.Errorf(
 			"failed to connect to Speechmatics WebSocket: %w",
 			err,
@@ -207,13 +261,19 @@ func (h *TranscriptionHandler) ProcessAudioStream(
 	silenceTimer := time.NewTicker(100 * time.Millisecond)
 	defer silenceTimer.Stop()
 
-	go h.handleTranscripts(ctx, transcriptChan, errChan, sessionID)
+	transcriptsDone := make(chan struct{})
+	go func() {
+		defer close(transcriptsDone)
+		h.handleTranscripts(ctx, transcriptChan, errChan, sessionID)
+	}()
 
 	for {
 		select {
 		case packet, ok := <-stream:
 			if !ok {
-				return h.finalizeStream(buffer, seqNo)
+				err := h.finalizeStream(buffer, seqNo)
+				h.awaitFinalTranscripts(transcriptsDone)
+				return err
 			}
 			if err := h.processPacket(packet, oggWriter, buffer, &seqNo, &lastPacketTime); err != nil {
 				return err
@@ -240,6 +300,9 @@ func (h *TranscriptionHandler) handleTranscripts(
 			if !ok {
 				return
 			}
+			if transcript.Message == "EndOfTranscript" {
+				return
+			}
 			if err := h.HandleTranscript(ctx, transcript, sessionID); err != nil {
 				log.Error("Failed to handle transcript", "error", err)
 			}
@@ -326,6 +389,17 @@ func (h *TranscriptionHandler) finalizeStream(
 	return nil
 }
 
+// awaitFinalTranscripts waits for the service to deliver the transcripts
+// of the end of a stream, so that they are stored before the connection
+// is closed.
+func (h *TranscriptionHandler) awaitFinalTranscripts(done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(finalTranscriptTimeout):
+		log.Warn("Timed out waiting for final transcripts")
+	}
+}
+
 func setupOggWriter(sessionID int64) (*snd.Ogg, *bytes.Buffer, error) {
 	tmpDir := "tmp"
 	if err := os.MkdirAll(tmpDir, 0755); err != nil {
@@ -2,17 +2,19 @@ package tts
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"node.town/db"
 	"node.town/snd"
+	"node.town/speechmatics"
 )
 
 type Config struct {
@@ -23,8 +25,16 @@ type Config struct {
 var TranscribeCmd = &cobra.Command{
 	Use:   "transcribe",
 	Short: "Transcribe audio from Opus packets",
-	Long:  `This command listens for Opus packets, transcribes them, and updates the database with transcription data.`,
-	Run:   runTranscribe,
+	Long: `This command listens for Opus packets, transcribes them, and updates the database with transcription data.
+
+With --from and --to it instead re-transcribes the packets recorded in that
+time range, optionally narrowed to a guild and channel, and stores the result
+as a new transcript version next to the earlier ones. Transcripts show the
+new version from then on; earlier ones can still be asked for by number.
+
+With --file it transcribes the packets of a capture file written by "jamie
+capture", or of an Ogg Opus file.`,
+	Run: runTranscribe,
 }
 
 var StreamCmd = &cobra.Command{
@@ -37,6 +47,29 @@ var StreamCmd = &cobra.Command{
 func init() {
 	TranscribeCmd.Flags().
 		Bool("speechmatics", false, "Use Speechmatics API for transcription (default is Gemini)")
+	TranscribeCmd.Flags().
+		String("from", "", "Re-transcribe packets recorded from this time (RFC3339)")
+	TranscribeCmd.Flags().
+		String("to", "", "Re-transcribe packets recorded until this time (RFC3339)")
+	TranscribeCmd.Flags().
+		String("guild", "", "Only re-transcribe this guild, or the guild to label an Ogg --file with")
+	TranscribeCmd.Flags().
+		String("channel", "", "Only re-transcribe this channel, or the channel to label an Ogg --file with")
+	TranscribeCmd.Flags().
+		String("file", "", "Transcribe packets from a capture or Ogg file")
+	TranscribeCmd.Flags().
+		Float64("speed", 1, "Replay speed, 1 for real time, 0 for as fast as possible")
+	TranscribeCmd.Flags().
+		Int("buffer-size", snd.DefaultDemuxerOptions().BufferSize, "Packets to buffer per speaker")
+	TranscribeCmd.Flags().
+		String("overflow", "", "What to do with packets for a full buffer, drop or block (default drop when live, block otherwise)")
+	TranscribeCmd.Flags().
+		Duration("idle-timeout", 10*time.Second, "End a speaker's session after this much silence (0 to keep it open)")
+	TranscribeCmd.Flags().String("language", "en", "Transcription language")
+	TranscribeCmd.Flags().
+		String("operating-point", string(speechmatics.OperatingPointStandard), "Operating point (standard or enhanced)")
+	TranscribeCmd.Flags().
+		Float64("max-delay", 0, "Maximum delay of final transcripts in seconds (0 for the service default)")
 }
 
 func runTranscribe(cmd *cobra.Command, args []string) {
@@ -51,50 +84,228 @@ func runTranscribe(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service := NewSpeechmaticsService(cfg.SpeechmaticsAPIKey)
-	handler := NewTranscriptionHandler(queries, pgPool, service)
+	language, _ := cmd.Flags().GetString("language")
+	operatingPoint, _ := cmd.Flags().GetString("operating-point")
+	maxDelay, _ := cmd.Flags().GetFloat64("max-delay")
+	config := DefaultTranscriptionConfig()
+	config.Language = language
+	config.OperatingPoint = speechmatics.OperatingPoint(operatingPoint)
+	config.MaxDelay = maxDelay
+
+	// Each stream gets its own connection to the service
+	newHandler := func() *TranscriptionHandler {
+		service := NewSpeechmaticsService(cfg.SpeechmaticsAPIKey)
+		handler := NewTranscriptionHandler(queries, pgPool, service)
+		handler.SetConfig(config)
+		return handler
+	}
+
+	ssrcCache := snd.NewSSRCUserIDCache(queries)
+	err = ssrcCache.ListenForChanges(ctx, pgPool, log.Default())
+	if err != nil {
+		log.Fatal("Failed to listen for SSRC mapping changes", "error", err)
+	}
+	var cache snd.UserIDCache = ssrcCache
+	var streamer snd.PacketStreamer
+	version := int32(1)
+	var replayer *snd.ReplayPacketStreamer
+	var pendingVersionID int64
+
+	demuxerOptions := snd.DefaultDemuxerOptions()
+	demuxerOptions.BufferSize, _ = cmd.Flags().GetInt("buffer-size")
+	demuxerOptions.IdleTimeout, _ = cmd.Flags().GetDuration("idle-timeout")
+	// Recorded packets can arrive faster than they are transcribed, and
+	// waiting for them costs nothing
+	demuxerOptions.Overflow = snd.BlockWhenFull
+
+	file, _ := cmd.Flags().GetString("file")
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	if file != "" {
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		guildID, _ := cmd.Flags().GetString("guild")
+		channelID, _ := cmd.Flags().GetString("channel")
+		fileStreamer := snd.NewFilePacketStreamer(
+			file,
+			log.Default(),
+			snd.FileStreamOptions{
+				Speed:     speed,
+				GuildID:   guildID,
+				ChannelID: channelID,
+			},
+		)
+		streamer, cache = fileStreamer, fileStreamer
+		log.Info("Transcribing packet file", "path", file, "speed", speed)
+	} else if from != "" || to != "" {
+		options, err := replayOptions(cmd, from, to)
+		if err != nil {
+			log.Fatal("Invalid replay options", "error", err)
+		}
+
+		fromTime := pgtype.Timestamptz{Time: options.From, Valid: true}
+		toTime := pgtype.Timestamptz{Time: options.To, Valid: true}
+		guildID := pgtype.Text{
+			String: options.GuildID,
+			Valid:  options.GuildID != "",
+		}
+		channelID := pgtype.Text{
+			String: options.ChannelID,
+			Valid:  options.ChannelID != "",
+		}
+		version, err = queries.GetNextTranscriptVersion(
+			ctx,
+			db.GetNextTranscriptVersionParams{
+				FromTime:  fromTime,
+				ToTime:    toTime,
+				GuildID:   guildID,
+				ChannelID: channelID,
+			},
+		)
+		if err != nil {
+			log.Fatal("Failed to get transcript version", "error", err)
+		}
+
+		// The new version stays pending, and readers keep showing the
+		// previous one, until the whole range has been transcribed
+		pendingVersionID, err = queries.InsertTranscriptVersion(
+			ctx,
+			db.InsertTranscriptVersionParams{
+				Version:   version,
+				FromTime:  fromTime,
+				ToTime:    toTime,
+				GuildID:   guildID,
+				ChannelID: channelID,
+			},
+		)
+		if err != nil {
+			log.Fatal("Failed to record transcript version", "error", err)
+		}
+
+		log.Info(
+			"Re-transcribing recorded packets",
+			"from", options.From,
+			"to", options.To,
+			"speed", options.Speed,
+			"version", version,
+		)
+		replayer = snd.NewReplayPacketStreamer(
+			queries,
+			cache,
+			log.Default(),
+			options,
+		)
+		streamer = replayer
+	} else {
+		streamer = snd.NewPostgresPacketStreamer(pgPool, cache, log.Default())
+		demuxerOptions.Overflow = snd.DropWhenFull
+		log.Info("Real-time transcription enabled")
+	}
+
+	overflow, _ := cmd.Flags().GetString("overflow")
+	switch overflow {
+	case "":
+	case "drop":
+		demuxerOptions.Overflow = snd.DropWhenFull
+	case "block":
+		demuxerOptions.Overflow = snd.BlockWhenFull
+	default:
+		log.Fatal("Invalid --overflow, expected drop or block", "overflow", overflow)
+	}
 
-	err = streamAndTranscribe(ctx, pgPool, queries, handler)
+	demuxer := snd.NewPacketDemuxerWithOptions(cache, log.Default(), demuxerOptions)
+	err = streamAndTranscribe(ctx, streamer, demuxer, queries, newHandler, version)
 	if err != nil {
 		log.Fatal("Error in streamAndTranscribe", "error", err)
 	}
+
+	if replayer != nil {
+		if err := replayer.Err(); err != nil {
+			log.Fatal("Re-transcription stopped early", "version", version, "error", err)
+		}
+		// From here on readers show the new version of the range
+		if err := queries.CompleteTranscriptVersion(ctx, pendingVersionID); err != nil {
+			log.Fatal("Failed to complete transcript version", "error", err)
+		}
+		log.Info("Re-transcription complete", "version", version)
+	}
 }
 
+func replayOptions(
+	cmd *cobra.Command,
+	from, to string,
+) (snd.ReplayOptions, error) {
+	var options snd.ReplayOptions
+
+	if from == "" || to == "" {
+		return options, errors.New("--from and --to must be given together")
+	}
+
+	var err error
+	options.From, err = time.Parse(time.RFC3339, from)
+	if err != nil {
+		return options, fmt.Errorf("error parsing --from: %w", err)
+	}
+	options.To, err = time.Parse(time.RFC3339, to)
+	if err != nil {
+		return options, fmt.Errorf("error parsing --to: %w", err)
+	}
+
+	options.GuildID, _ = cmd.Flags().GetString("guild")
+	options.ChannelID, _ = cmd.Flags().GetString("channel")
+	options.Speed, _ = cmd.Flags().GetFloat64("speed")
+
+	return options, nil
+}
+
+// streamAndTranscribe transcribes every stream of packets the streamer
+// delivers, into sessions of the given transcript version, and returns
+// once all of them have been transcribed.
 func streamAndTranscribe(
 	ctx context.Context,
-	pgPool *pgxpool.Pool,
+	streamer snd.PacketStreamer,
+	demuxer snd.PacketDemuxer,
 	queries *db.Queries,
-	handler *TranscriptionHandler,
+	newHandler func() *TranscriptionHandler,
+	version int32,
 ) error {
-	cache := snd.NewSSRCUserIDCache(queries)
-	streamer := snd.NewPostgresPacketStreamer(pgPool, cache, log.Default())
 	packetChan, err := snd.StreamOpusPackets(ctx, streamer)
 	if err != nil {
 		return fmt.Errorf("error setting up opus packet stream: %w", err)
 	}
 
-	demuxer := snd.NewDefaultPacketDemuxer(cache, log.Default())
 	streamChan := snd.DemuxOpusPackets(ctx, demuxer, packetChan)
 
 	log.Info(
 		"Listening for demuxed Opus packet streams. Press CTRL-C to exit.",
 	)
-	log.Info("Real-time transcription enabled")
 
+	var wg sync.WaitGroup
 	for stream := range streamChan {
+		wg.Add(1)
 		go func(s <-chan snd.OpusPacketNotification) {
+			defer wg.Done()
+
 			firstPacket := <-s
+			startTime, err := time.Parse(
+				time.RFC3339Nano,
+				firstPacket.CreatedAt,
+			)
+			if err != nil {
+				startTime = time.Now()
+			}
+
 			sessionID, err := queries.InsertTranscriptionSession(
 				ctx,
 				db.InsertTranscriptionSessionParams{
 					Ssrc: firstPacket.Ssrc,
 					StartTime: pgtype.Timestamptz{
-						Time:  time.Now(),
+						Time:  startTime,
 						Valid: true,
 					},
-					GuildID:   firstPacket.GuildID,
-					ChannelID: firstPacket.ChannelID,
-					UserID:    firstPacket.UserID,
+					GuildID:           firstPacket.GuildID,
+					ChannelID:         firstPacket.ChannelID,
+					UserID:            firstPacket.UserID,
+					TranscriptVersion: version,
 				},
 			)
 			if err != nil {
@@ -106,6 +317,16 @@ func streamAndTranscribe(
 				return
 			}
 
+			handler := newHandler()
+			err = handler.UseGuildVocabulary(ctx, firstPacket.GuildID)
+			if err != nil {
+				log.Warn(
+					"Transcribing without guild vocabulary",
+					"guildID", firstPacket.GuildID,
+					"error", err,
+				)
+			}
+
 			err = handler.ProcessAudioStream(ctx, s, sessionID)
 			if err != nil {
 				log.Error("Error processing audio stream", "error", err)
@@ -113,6 +334,7 @@ func streamAndTranscribe(
 		}(stream)
 	}
 
+	wg.Wait()
 	return nil
 }
 
@@ -186,6 +408,9 @@ func handleTranscriptionUpdate(
 	if len(dbSegment) > 0 {
 		transcriptChan <- TranscriptSegment{
 			SessionID: dbSegment[0].SessionID,
+			GuildID:   dbSegment[0].GuildID,
+			ChannelID: dbSegment[0].ChannelID,
+			UserID:    dbSegment[0].UserID,
 			IsFinal:   dbSegment[0].IsFinal,
 			Words:     convertDBRowsToTranscriptWords(dbSegment),
 		}
@@ -195,9 +420,13 @@ func handleTranscriptionUpdate(
 func convertDBRowsToTranscriptWords(
 	rows []db.GetTranscriptsRow,
 ) []TranscriptWord {
-	words := make([]TranscriptWord, len(rows))
+	words := make([]TranscriptWord, 0, len(rows))
 	for i, row := range rows {
-		words[i] = TranscriptWord{
+		// Rows come once per alternative, the most confident first
+		if i > 0 && rows[i-1].WordID == row.WordID {
+			continue
+		}
+		words = append(words, TranscriptWord{
 			Content:           row.Content,
 			RelativeStartTime: float64(row.StartTime.Microseconds) / 1000000,
 			RelativeEndTime: float64(
@@ -207,7 +436,8 @@ func convertDBRowsToTranscriptWords(
 			IsEOS:             row.IsEos,
 			AttachesTo:        row.AttachesTo.String,
 			AbsoluteStartTime: row.RealStartTime.Time,
-		}
+			SessionID:         row.SessionID,
+		})
 	}
 	return words
 }
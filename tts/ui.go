@@ -12,6 +12,9 @@ import (
 )
 
 type SessionTranscript struct {
+	GuildID           string
+	ChannelID         string
+	UserID            string
 	FinalTranscript   []TranscriptWord
 	CurrentTranscript []TranscriptWord
 }
@@ -58,7 +61,11 @@ func initialModel(
 func (m *model) updateTranscript(msg TranscriptSegment) {
 	session, ok := m.sessions[msg.SessionID]
 	if !ok {
-		session = &SessionTranscript{}
+		session = &SessionTranscript{
+			GuildID:   msg.GuildID,
+			ChannelID: msg.ChannelID,
+			UserID:    msg.UserID,
+		}
 		m.sessions[msg.SessionID] = session
 	}
 
@@ -178,32 +185,60 @@ func (m model) contentView() string {
 }
 
 func (m model) TranscriptView() string {
-	var allBuilders []*TranscriptBuilder
-	for _, session := range m.sessions {
-		builder := NewTranscriptBuilder()
-		builder.AppendWords(session.FinalTranscript, false)
-		builder.AppendWords(session.CurrentTranscript, true)
-		allBuilders = append(allBuilders, builder)
+	sessionIDs := make([]int64, 0, len(m.sessions))
+	for sessionID := range m.sessions {
+		sessionIDs = append(sessionIDs, sessionID)
 	}
+	sort.Slice(sessionIDs, func(i, j int) bool {
+		return sessionIDs[i] < sessionIDs[j]
+	})
 
-	var allLines []Line
-	for _, builder := range allBuilders {
-		allLines = append(allLines, builder.GetLines()...)
+	var utterances []Utterance
+	for _, sessionID := range sessionIDs {
+		session := m.sessions[sessionID]
+		utterances = append(utterances, WordUtterances(
+			session.FinalTranscript,
+			session.GuildID,
+			session.ChannelID,
+			session.UserID,
+			false,
+		)...)
+		utterances = append(utterances, WordUtterances(
+			session.CurrentTranscript,
+			session.GuildID,
+			session.ChannelID,
+			session.UserID,
+			true,
+		)...)
 	}
 
-	sort.Slice(allLines, func(i, j int) bool {
-		return allLines[i].StartTime.Before(allLines[j].StartTime)
-	})
+	conversations := AssembleConversations(utterances)
 
 	var result strings.Builder
-	for _, line := range allLines {
-		result.WriteString(
-			fmt.Sprintf("(%s) ", line.StartTime.Format("15:04:05")),
-		)
-		for _, span := range line.Spans {
-			result.WriteString(span.Style.Render(span.Content))
+	for _, conversation := range conversations {
+		if len(conversations) > 1 {
+			result.WriteString(
+				fmt.Sprintf(
+					"── %s/%s\n",
+					conversation.GuildID,
+					conversation.ChannelID,
+				),
+			)
+		}
+		for _, turn := range conversation.Turns {
+			result.WriteString(
+				fmt.Sprintf("(%s) ", turn.Start.Format("15:04:05")),
+			)
+			if label := turn.Label(); label != "" {
+				result.WriteString(label + ": ")
+			}
+			style := StyleNormal
+			if turn.Partial() {
+				style = StylePartial
+			}
+			result.WriteString(style.Render(turn.Text()))
+			result.WriteString("\n")
 		}
-		result.WriteString("\n")
 	}
 
 	return result.String()
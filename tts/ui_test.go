@@ -42,6 +42,9 @@ func (m *testModel) addFinalTranscript(
 		session = &SessionTranscript{}
 		m.sessions[sessionID] = session
 	}
+	for i := range words {
+		words[i].SessionID = sessionID
+	}
 	session.FinalTranscript = append(session.FinalTranscript, words...)
 }
 
@@ -54,6 +57,9 @@ func (m *testModel) setCurrentTranscript(
 		session = &SessionTranscript{}
 		m.sessions[sessionID] = session
 	}
+	for i := range words {
+		words[i].SessionID = sessionID
+	}
 	session.CurrentTranscript = words
 }
 
@@ -96,7 +102,7 @@ func TestTranscriptView(t *testing.T) {
 			word("C", 2, false),
 		)
 
-		expected := "(00:00:00) A B\n(00:00:02) C\n"
+		expected := "(00:00:00) A B C\n"
 		result := model(m).TranscriptView()
 
 		if result != expected {
@@ -125,7 +131,7 @@ func TestTranscriptView(t *testing.T) {
 			word("Another sentence", 2, false),
 		)
 
-		expected := "(00:00:00) This is a sentence.\n(00:00:02) Another sentence\n"
+		expected := "(00:00:00) This is a sentence. Another sentence\n"
 		result := model(m).TranscriptView()
 
 		if result != expected {
@@ -162,7 +168,7 @@ func TestTranscriptView(t *testing.T) {
 			word("4", 5, true),
 		)
 
-		expected := "(00:00:00) A B C D\n(00:00:01) 1 2\n(00:00:04) 3 4\n(00:00:05) E\n"
+		expected := "(00:00:00) A B\n(00:00:01) 1\n(00:00:02) C\n(00:00:02) 2\n(00:00:03) D\n(00:00:04) 3\n(00:00:05) E\n(00:00:05) 4\n"
 		result := model(m).TranscriptView()
 
 		if result != expected {
@@ -0,0 +1,798 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+const (
+	// transcriptPageSize is how many sentences a transcript page shows.
+	transcriptPageSize = 200
+
+	// defaultTranscriptWindow is how far back a channel transcript goes
+	// unless asked for a time range.
+	defaultTranscriptWindow = 24 * time.Hour
+)
+
+// Crumb is a link in the trail at the top of a page.
+type Crumb struct {
+	Name string
+	URL  string
+}
+
+// IndexEntry is a row of a listing of guilds, channels or days.
+type IndexEntry struct {
+	Name        string
+	URL         string
+	Count       int64
+	First, Last time.Time
+}
+
+// IndexPage lists where there are transcripts to read.
+type IndexPage struct {
+	Title   string
+	Crumbs  []Crumb
+	Entries []IndexEntry
+}
+
+// TranscriptFilter narrows a transcript page. Zero values don't narrow.
+type TranscriptFilter struct {
+	From, To      time.Time
+	Speaker       string
+	MinConfidence float64
+	Page          int  // From 1
+	Diff          bool // Show corrections against what was recognized
+	Version       int  // Transcript version to show, 0 for the latest
+}
+
+// ParseTranscriptFilter reads a filter from query parameters: from and
+// to as RFC3339 times, speaker, min_confidence, page, diff and version.
+func ParseTranscriptFilter(query url.Values) (TranscriptFilter, error) {
+	filter := TranscriptFilter{
+		Speaker: query.Get("speaker"),
+		Page:    1,
+		Diff:    query.Get("diff") != "",
+	}
+
+	var err error
+	if s := query.Get("from"); s != "" {
+		if filter.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return filter, fmt.Errorf("invalid from time: %w", err)
+		}
+	}
+	if s := query.Get("to"); s != "" {
+		if filter.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return filter, fmt.Errorf("invalid to time: %w", err)
+		}
+	}
+	if s := query.Get("min_confidence"); s != "" {
+		filter.MinConfidence, err = strconv.ParseFloat(s, 64)
+		if err != nil || filter.MinConfidence < 0 || filter.MinConfidence > 1 {
+			return filter, fmt.Errorf("invalid min_confidence %q", s)
+		}
+	}
+	if s := query.Get("page"); s != "" {
+		filter.Page, err = strconv.Atoi(s)
+		if err != nil || filter.Page < 1 {
+			return filter, fmt.Errorf("invalid page %q", s)
+		}
+	}
+	if s := query.Get("version"); s != "" {
+		filter.Version, err = strconv.Atoi(s)
+		if err != nil || filter.Version < 1 {
+			return filter, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	return filter, nil
+}
+
+// Query is the filter as query parameters, leaving out what is unset.
+func (f TranscriptFilter) Query() url.Values {
+	query := url.Values{}
+	if !f.From.IsZero() {
+		query.Set("from", f.From.Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		query.Set("to", f.To.Format(time.RFC3339))
+	}
+	if f.Speaker != "" {
+		query.Set("speaker", f.Speaker)
+	}
+	if f.MinConfidence > 0 {
+		query.Set("min_confidence", f.MinConfidenceValue())
+	}
+	if f.Page > 1 {
+		query.Set("page", strconv.Itoa(f.Page))
+	}
+	if f.Diff {
+		query.Set("diff", "1")
+	}
+	if f.Version > 0 {
+		query.Set("version", strconv.Itoa(f.Version))
+	}
+	return query
+}
+
+// FromValue, ToValue, MinConfidenceValue and VersionValue fill in the
+// filter form.
+func (f TranscriptFilter) FromValue() string {
+	if f.From.IsZero() {
+		return ""
+	}
+	return f.From.Format(time.RFC3339)
+}
+
+func (f TranscriptFilter) ToValue() string {
+	if f.To.IsZero() {
+		return ""
+	}
+	return f.To.Format(time.RFC3339)
+}
+
+func (f TranscriptFilter) MinConfidenceValue() string {
+	if f.MinConfidence == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f.MinConfidence, 'f', -1, 64)
+}
+
+func (f TranscriptFilter) VersionValue() string {
+	return versionValue(f.Version)
+}
+
+// versionValue fills in a transcript version field, empty for the latest.
+func versionValue(version int) string {
+	if version == 0 {
+		return ""
+	}
+	return strconv.Itoa(version)
+}
+
+// TranscriptPage is one page of the transcript of a channel or session.
+type TranscriptPage struct {
+	Title         string
+	Crumbs        []Crumb
+	Path          string
+	Filter        TranscriptFilter
+	Conversations []Conversation
+	HasNext       bool
+	LiveURL       string // Empty if the page can't be followed live
+}
+
+func (p TranscriptPage) pageURL(filter TranscriptFilter) string {
+	if query := filter.Query().Encode(); query != "" {
+		return p.Path + "?" + query
+	}
+	return p.Path
+}
+
+// PrevURL and NextURL link to the neighbouring pages, or are empty.
+func (p TranscriptPage) PrevURL() string {
+	if p.Filter.Page <= 1 {
+		return ""
+	}
+	filter := p.Filter
+	filter.Page--
+	return p.pageURL(filter)
+}
+
+func (p TranscriptPage) NextURL() string {
+	if !p.HasNext {
+		return ""
+	}
+	filter := p.Filter
+	filter.Page++
+	return p.pageURL(filter)
+}
+
+// LineURL links to the sentence of an utterance: the page starting with
+// it, scrolled to it, so that the link keeps working as the transcript
+// grows.
+func (p TranscriptPage) LineURL(utterance Utterance) string {
+	filter := p.Filter
+	filter.From = utterance.SentenceStart.Truncate(time.Second)
+	filter.Page = 1
+	return p.pageURL(filter) + "#" + LineAnchor(utterance)
+}
+
+// LineAnchor is the HTML ID of a sentence on a transcript page.
+func LineAnchor(utterance Utterance) string {
+	return fmt.Sprintf("s%d", utterance.ID)
+}
+
+func guildURL(guildID string) string {
+	return "/guilds/" + url.PathEscape(guildID)
+}
+
+func channelURL(guildID, channelID string) string {
+	return guildURL(guildID) + "/channels/" + url.PathEscape(channelID)
+}
+
+func channelTranscriptURL(guildID, channelID string) string {
+	return channelURL(guildID, channelID) + "/transcript"
+}
+
+func sessionURL(sessionID int64) string {
+	return fmt.Sprintf("/sessions/%d", sessionID)
+}
+
+// SentenceURL links to the page for correcting a sentence.
+func SentenceURL(sentenceID int64) string {
+	return fmt.Sprintf("/sentences/%d", sentenceID)
+}
+
+// loadTranscriptPage loads a page of sentences matching params and the
+// filter and assembles them into conversations.
+func loadTranscriptPage(
+	ctx context.Context,
+	queries *db.Queries,
+	params db.GetTranscriptSentencesParams,
+	page TranscriptPage,
+) (TranscriptPage, error) {
+	filter := page.Filter
+	params.UserID = pgtype.Text{
+		String: filter.Speaker,
+		Valid:  filter.Speaker != "",
+	}
+	params.MinConfidence = pgtype.Float4{
+		Float32: float32(filter.MinConfidence),
+		Valid:   filter.MinConfidence > 0,
+	}
+	params.Version = pgtype.Int4{
+		Int32: int32(filter.Version),
+		Valid: filter.Version > 0,
+	}
+	params.MaxResults = pgtype.Int4{Int32: transcriptPageSize + 1, Valid: true}
+	params.Skip = int32((filter.Page - 1) * transcriptPageSize)
+
+	sentences, err := queries.GetTranscriptSentences(ctx, params)
+	if err != nil {
+		return page, fmt.Errorf("failed to load transcript sentences: %w", err)
+	}
+	if len(sentences) > transcriptPageSize {
+		sentences = sentences[:transcriptPageSize]
+		page.HasNext = true
+	}
+
+	words, err := loadSentenceWords(ctx, queries, sentences)
+	if err != nil {
+		return page, err
+	}
+
+	page.Conversations = AssembleConversations(
+		TimedSentenceUtterances(sentences, words),
+	)
+	return page, nil
+}
+
+// loadSentenceWords loads the timed words of sentences, by sentence ID.
+func loadSentenceWords(
+	ctx context.Context,
+	queries *db.Queries,
+	sentences []db.TranscriptSentence,
+) (map[int64][]UtteranceWord, error) {
+	if len(sentences) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[int64]bool)
+	var segmentIDs []int64
+	for _, sentence := range sentences {
+		if !seen[sentence.SegmentID] {
+			seen[sentence.SegmentID] = true
+			segmentIDs = append(segmentIDs, sentence.SegmentID)
+		}
+	}
+
+	rows, err := queries.GetSentenceWords(ctx, segmentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sentence words: %w", err)
+	}
+	return groupSentenceWords(sentences, rows), nil
+}
+
+type sentenceKey struct {
+	segmentID int64
+	index     int32
+}
+
+// groupSentenceWords sorts words into the sentences they make up.
+// Sentences rewritten as a whole get no words, as theirs no longer make
+// them up.
+func groupSentenceWords(
+	sentences []db.TranscriptSentence,
+	rows []db.GetSentenceWordsRow,
+) map[int64][]UtteranceWord {
+	sentenceIDs := make(map[sentenceKey]int64, len(sentences))
+	for _, sentence := range sentences {
+		key := sentenceKey{sentence.SegmentID, sentence.SentenceIndex}
+		sentenceIDs[key] = sentence.ID
+	}
+
+	words := make(map[int64][]UtteranceWord, len(sentences))
+	for _, row := range rows {
+		sentenceID, ok := sentenceIDs[sentenceKey{row.SegmentID, row.SentenceIndex}]
+		if !ok {
+			continue
+		}
+		words[sentenceID] = append(words[sentenceID], UtteranceWord{
+			Content:    row.Content,
+			AttachesTo: row.AttachesTo.String,
+			Start:      row.StartTime.Time,
+			End:        row.EndTime.Time,
+			Confidence: row.Confidence,
+		})
+	}
+
+	for _, sentence := range sentences {
+		if wordsText(words[sentence.ID]) != sentence.Content {
+			delete(words, sentence.ID)
+		}
+	}
+	return words
+}
+
+// wordsText is the text timed words make up.
+func wordsText(words []UtteranceWord) string {
+	var text strings.Builder
+	for i, word := range words {
+		if i > 0 && word.AttachesTo != "previous" {
+			text.WriteByte(' ')
+		}
+		text.WriteString(word.Content)
+	}
+	return text.String()
+}
+
+// unixMillis is a time as the browser's clock counts it.
+func unixMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func renderPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	component interface {
+		Render(ctx context.Context, w io.Writer) error
+	},
+) {
+	var html strings.Builder
+	if err := component.Render(r.Context(), &html); err != nil {
+		log.Error("Failed to render page", "error", err)
+		http.Error(w, "Failed to render HTML", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, html.String())
+}
+
+func handleGuildList(queries *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		guilds, err := queries.ListTranscriptGuilds(r.Context())
+		if err != nil {
+			log.Error("Failed to list guilds", "error", err)
+			http.Error(
+				w,
+				"Failed to list guilds",
+				http.StatusInternalServerError,
+			)
+			return
+		}
+
+		viewer := viewerFrom(r.Context())
+		page := IndexPage{Title: "Guilds"}
+		for _, guild := range guilds {
+			if !viewer.CanSeeGuild(guild.GuildID) {
+				continue
+			}
+			page.Entries = append(page.Entries, IndexEntry{
+				Name:  guild.GuildID,
+				URL:   guildURL(guild.GuildID),
+				Count: guild.SentenceCount,
+				First: guild.FirstSpoken.Time,
+				Last:  guild.LastSpoken.Time,
+			})
+		}
+		renderPage(w, r, IndexTemplate(page))
+	}
+}
+
+// handleGuildRoutes serves /guilds/{guild}, which lists its channels,
+// /guilds/{guild}/channels/{channel}, which lists the days it has
+// transcripts for, and /guilds/{guild}/channels/{channel}/transcript.
+func handleGuildRoutes(queries *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(
+			strings.Trim(strings.TrimPrefix(r.URL.Path, "/guilds/"), "/"),
+			"/",
+		)
+		for i, part := range parts {
+			unescaped, err := url.PathUnescape(part)
+			if err != nil || unescaped == "" {
+				http.NotFound(w, r)
+				return
+			}
+			parts[i] = unescaped
+		}
+
+		viewer := viewerFrom(r.Context())
+		if !viewer.CanSeeGuild(parts[0]) ||
+			len(parts) > 2 && !viewer.CanSeeChannel(r.Context(), parts[0], parts[2]) {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			serveChannelList(w, r, queries, parts[0])
+		case len(parts) == 3 && parts[1] == "channels":
+			serveDayList(w, r, queries, parts[0], parts[2])
+		case len(parts) == 4 && parts[1] == "channels" && parts[3] == "transcript":
+			serveChannelTranscript(w, r, queries, parts[0], parts[2])
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func serveChannelList(
+	w http.ResponseWriter,
+	r *http.Request,
+	queries *db.Queries,
+	guildID string,
+) {
+	channels, err := queries.ListTranscriptChannels(r.Context(), guildID)
+	if err != nil {
+		log.Error("Failed to list channels", "error", err)
+		http.Error(w, "Failed to list channels", http.StatusInternalServerError)
+		return
+	}
+
+	page := IndexPage{
+		Title:  "Channels",
+		Crumbs: []Crumb{{Name: "Guilds", URL: "/"}, {Name: guildID}},
+	}
+	viewer := viewerFrom(r.Context())
+	for _, channel := range channels {
+		if !viewer.CanSeeChannel(r.Context(), guildID, channel.ChannelID) {
+			continue
+		}
+		page.Entries = append(page.Entries, IndexEntry{
+			Name:  channel.ChannelID,
+			URL:   channelURL(guildID, channel.ChannelID),
+			Count: channel.SentenceCount,
+			First: channel.FirstSpoken.Time,
+			Last:  channel.LastSpoken.Time,
+		})
+	}
+	renderPage(w, r, IndexTemplate(page))
+}
+
+func serveDayList(
+	w http.ResponseWriter,
+	r *http.Request,
+	queries *db.Queries,
+	guildID, channelID string,
+) {
+	days, err := queries.ListTranscriptDays(
+		r.Context(),
+		db.ListTranscriptDaysParams{GuildID: guildID, ChannelID: channelID},
+	)
+	if err != nil {
+		log.Error("Failed to list days", "error", err)
+		http.Error(w, "Failed to list days", http.StatusInternalServerError)
+		return
+	}
+
+	page := IndexPage{
+		Title: "Days",
+		Crumbs: []Crumb{
+			{Name: "Guilds", URL: "/"},
+			{Name: guildID, URL: guildURL(guildID)},
+			{Name: channelID},
+		},
+	}
+	for _, day := range days {
+		start := day.Day.Time.UTC()
+		filter := TranscriptFilter{From: start, To: start.AddDate(0, 0, 1)}
+		page.Entries = append(page.Entries, IndexEntry{
+			Name: start.Format("Monday, 2 January 2006"),
+			URL: channelTranscriptURL(guildID, channelID) +
+				"?" + filter.Query().Encode(),
+			Count: day.SentenceCount,
+			First: day.FirstSpoken.Time,
+			Last:  day.LastSpoken.Time,
+		})
+	}
+	renderPage(w, r, IndexTemplate(page))
+}
+
+func serveChannelTranscript(
+	w http.ResponseWriter,
+	r *http.Request,
+	queries *db.Queries,
+	guildID, channelID string,
+) {
+	filter, err := ParseTranscriptFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to := filter.From, filter.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultTranscriptWindow)
+	}
+
+	page, err := loadTranscriptPage(
+		r.Context(),
+		queries,
+		db.GetTranscriptSentencesParams{
+			StartTime: pgtype.Timestamptz{Time: from, Valid: true},
+			EndTime:   pgtype.Timestamptz{Time: to, Valid: true},
+			GuildID:   pgtype.Text{String: guildID, Valid: true},
+			ChannelID: pgtype.Text{String: channelID, Valid: true},
+		},
+		TranscriptPage{
+			Title: fmt.Sprintf(
+				"%s to %s",
+				from.Format("2006-01-02 15:04"),
+				to.Format("2006-01-02 15:04"),
+			),
+			Crumbs: []Crumb{
+				{Name: "Guilds", URL: "/"},
+				{Name: guildID, URL: guildURL(guildID)},
+				{Name: channelID, URL: channelURL(guildID, channelID)},
+			},
+			Path:    channelTranscriptURL(guildID, channelID),
+			Filter:  filter,
+			LiveURL: liveURL("/live", guildID, channelID),
+		},
+	)
+	if err != nil {
+		log.Error("Failed to load transcript", "error", err)
+		http.Error(
+			w,
+			"Failed to load transcript",
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	renderPage(w, r, ConversationTemplate(page))
+}
+
+// handleSessionPage serves /sessions/{id}, the transcript of one
+// transcription session.
+func handleSessionPage(queries *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := strconv.ParseInt(
+			strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/"),
+			10,
+			64,
+		)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, err = visibleSession(r.Context(), queries, sessionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Error("Failed to load session", "error", err)
+			http.Error(w, "Failed to load session", http.StatusInternalServerError)
+			return
+		}
+
+		filter, err := ParseTranscriptFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := db.GetTranscriptSentencesParams{
+			SessionID: pgtype.Int8{Int64: sessionID, Valid: true},
+			StartTime: pgtype.Timestamptz{
+				InfinityModifier: pgtype.NegativeInfinity,
+				Valid:            true,
+			},
+			EndTime: pgtype.Timestamptz{
+				InfinityModifier: pgtype.Infinity,
+				Valid:            true,
+			},
+		}
+		if !filter.From.IsZero() {
+			params.StartTime = pgtype.Timestamptz{Time: filter.From, Valid: true}
+		}
+		if !filter.To.IsZero() {
+			params.EndTime = pgtype.Timestamptz{Time: filter.To, Valid: true}
+		}
+
+		page, err := loadTranscriptPage(
+			r.Context(),
+			queries,
+			params,
+			TranscriptPage{
+				Title:  fmt.Sprintf("Session %d", sessionID),
+				Crumbs: []Crumb{{Name: "Guilds", URL: "/"}},
+				Path:   sessionURL(sessionID),
+				Filter: filter,
+			},
+		)
+		if err != nil {
+			log.Error("Failed to load transcript", "error", err)
+			http.Error(
+				w,
+				"Failed to load transcript",
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		renderPage(w, r, ConversationTemplate(page))
+	}
+}
+
+// CorrectionEntry is a change in the edit history of a sentence.
+type CorrectionEntry struct {
+	Author   string
+	Time     time.Time
+	Word     bool // Corrected one word rather than the sentence
+	Outdated bool // Made to an earlier version of the transcript
+	Diff     []DiffPart
+}
+
+// SentencePage shows a sentence with its corrections and lets viewers
+// correct it.
+type SentencePage struct {
+	Title    string
+	Crumbs   []Crumb
+	Path     string
+	Sentence Utterance
+	Content  string // Text being corrected to
+	Error    string
+	History  []CorrectionEntry
+}
+
+// correctionEntries is the edit history of a sentence, latest first.
+func correctionEntries(corrections []db.ListTranscriptCorrectionsRow) []CorrectionEntry {
+	entries := make([]CorrectionEntry, 0, len(corrections))
+	for _, correction := range corrections {
+		entries = append(entries, CorrectionEntry{
+			Author:   correction.AuthorID,
+			Time:     correction.CreatedAt.Time,
+			Word:     correction.WordID.Valid,
+			Outdated: correction.Outdated,
+			Diff:     DiffWords(correction.PreviousContent, correction.Content),
+		})
+	}
+	return entries
+}
+
+// handleSentencePage serves /sentences/{id}, where a sentence can be
+// corrected and its edit history read.
+func handleSentencePage(corrector *Corrector, queries *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+			r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sentenceID, err := strconv.ParseInt(
+			strings.Trim(strings.TrimPrefix(r.URL.Path, "/sentences/"), "/"),
+			10,
+			64,
+		)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		sentence, err := visibleSentence(r.Context(), queries, sentenceID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Error("Failed to load sentence", "error", err)
+			http.Error(w, "Failed to load sentence", http.StatusInternalServerError)
+			return
+		}
+
+		path := SentenceURL(sentenceID)
+		content := sentence.Content
+		status := http.StatusOK
+		var message string
+		if r.Method == http.MethodPost {
+			content = r.FormValue("content")
+			_, err := corrector.CorrectSentence(
+				r.Context(),
+				sentence,
+				content,
+				correctionAuthor(viewerFrom(r.Context())),
+			)
+			switch {
+			case err == nil:
+				http.Redirect(w, r, path, http.StatusSeeOther)
+				return
+			case errors.Is(err, errEmptyCorrection), errors.Is(err, errNothingChanged):
+				status = http.StatusBadRequest
+				message = err.Error()
+			default:
+				log.Error("Failed to correct sentence", "error", err)
+				http.Error(w, "Failed to correct sentence", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		corrections, err := queries.ListTranscriptCorrections(
+			r.Context(),
+			db.ListTranscriptCorrectionsParams{
+				SegmentID:     sentence.SegmentID,
+				SentenceIndex: sentence.SentenceIndex,
+			},
+		)
+		if err != nil {
+			log.Error("Failed to load corrections", "error", err)
+			http.Error(w, "Failed to load corrections", http.StatusInternalServerError)
+			return
+		}
+
+		utterance := SentenceUtterances([]db.TranscriptSentence{sentence})[0]
+		session := TranscriptPage{
+			Path:   sessionURL(sentence.SessionID),
+			Filter: TranscriptFilter{Page: 1},
+		}
+		page := SentencePage{
+			Title: fmt.Sprintf(
+				"Sentence at %s",
+				sentence.StartTime.Time.Format("2006-01-02 15:04:05"),
+			),
+			Crumbs: []Crumb{
+				{Name: "Guilds", URL: "/"},
+				{Name: sentence.GuildID, URL: guildURL(sentence.GuildID)},
+				{
+					Name: sentence.ChannelID,
+					URL:  channelURL(sentence.GuildID, sentence.ChannelID),
+				},
+				{
+					Name: fmt.Sprintf("Session %d", sentence.SessionID),
+					URL:  session.LineURL(utterance),
+				},
+			},
+			Path:     path,
+			Sentence: utterance,
+			Content:  content,
+			Error:    message,
+			History:  correctionEntries(corrections),
+		}
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(status)
+		}
+		renderPage(w, r, SentenceTemplate(page))
+	}
+}
@@ -0,0 +1,138 @@
+package tts
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"node.town/db"
+)
+
+func TestParseTranscriptFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected TranscriptFilter
+		wantErr  bool
+	}{
+		{"Empty", "", TranscriptFilter{Page: 1}, false},
+		{
+			"Everything",
+			"from=2024-06-01T18:00:00Z&to=2024-06-01T19:00:00Z&speaker=42&min_confidence=0.8&page=3&diff=1&version=2",
+			TranscriptFilter{
+				From:          time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC),
+				To:            time.Date(2024, 6, 1, 19, 0, 0, 0, time.UTC),
+				Speaker:       "42",
+				MinConfidence: 0.8,
+				Page:          3,
+				Diff:          true,
+				Version:       2,
+			},
+			false,
+		},
+		{"Bad Time", "from=yesterday", TranscriptFilter{}, true},
+		{"Confidence Out Of Range", "min_confidence=2", TranscriptFilter{}, true},
+		{"Bad Page", "page=0", TranscriptFilter{}, true},
+		{"Bad Version", "version=0", TranscriptFilter{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			filter, err := ParseTranscriptFilter(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if filter != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, filter)
+			}
+
+			roundTrip, err := ParseTranscriptFilter(filter.Query())
+			if err != nil || roundTrip != filter {
+				t.Errorf("Expected the query to round trip, got %+v (%v)", roundTrip, err)
+			}
+		})
+	}
+}
+
+func TestTranscriptPageLinks(t *testing.T) {
+	page := TranscriptPage{
+		Path:    "/sessions/7",
+		Filter:  TranscriptFilter{Speaker: "42", Page: 2},
+		HasNext: true,
+	}
+
+	if got := page.PrevURL(); got != "/sessions/7?speaker=42" {
+		t.Errorf("Expected previous page /sessions/7?speaker=42, got %s", got)
+	}
+	if got := page.NextURL(); got != "/sessions/7?page=3&speaker=42" {
+		t.Errorf("Expected next page /sessions/7?page=3&speaker=42, got %s", got)
+	}
+
+	line := Utterance{
+		ID:            99,
+		SentenceStart: time.Date(2024, 6, 1, 18, 0, 5, 500000000, time.UTC),
+		Start:         time.Date(2024, 6, 1, 18, 0, 9, 0, time.UTC),
+	}
+	expected := "/sessions/7?from=2024-06-01T18%3A00%3A05Z&speaker=42#s99"
+	if got := page.LineURL(line); got != expected {
+		t.Errorf("Expected line link %s, got %s", expected, got)
+	}
+
+	page.Filter.Page = 1
+	page.HasNext = false
+	if page.PrevURL() != "" || page.NextURL() != "" {
+		t.Errorf("Expected no neighbouring pages, got %q and %q", page.PrevURL(), page.NextURL())
+	}
+}
+
+func TestGroupSentenceWords(t *testing.T) {
+	at := func(seconds int) pgtype.Timestamptz {
+		return pgtype.Timestamptz{
+			Time:  time.Date(2024, 6, 1, 18, 0, seconds, 0, time.UTC),
+			Valid: true,
+		}
+	}
+	sentences := []db.TranscriptSentence{
+		{ID: 10, SegmentID: 1, SentenceIndex: 0, Content: "Hi."},
+		{ID: 11, SegmentID: 1, SentenceIndex: 1, Content: "Bye"},
+		// Rewritten as a whole, so its words no longer make it up
+		{ID: 12, SegmentID: 2, SentenceIndex: 0, Content: "See you soon."},
+	}
+	rows := []db.GetSentenceWordsRow{
+		{SegmentID: 1, SentenceIndex: 0, Content: "Hi", StartTime: at(0), EndTime: at(1)},
+		{
+			SegmentID:     1,
+			SentenceIndex: 0,
+			Content:       ".",
+			AttachesTo:    pgtype.Text{String: "previous", Valid: true},
+			StartTime:     at(1),
+			EndTime:       at(1),
+		},
+		{SegmentID: 1, SentenceIndex: 1, Content: "Bye", StartTime: at(2), EndTime: at(3)},
+		// A sentence not on the page
+		{SegmentID: 1, SentenceIndex: 2, Content: "Later", StartTime: at(4), EndTime: at(5)},
+		{SegmentID: 2, SentenceIndex: 0, Content: "Seeya", StartTime: at(6), EndTime: at(7)},
+	}
+
+	words := groupSentenceWords(sentences, rows)
+	if len(words) != 2 {
+		t.Fatalf("Expected words of 2 sentences, got %d", len(words))
+	}
+	if len(words[10]) != 2 || words[10][1].Content != "." || words[10][1].AttachesTo != "previous" {
+		t.Errorf("Expected \"Hi\" and \".\" in the first sentence, got %+v", words[10])
+	}
+	if len(words[11]) != 1 || !words[11][0].Start.Equal(at(2).Time) {
+		t.Errorf("Expected \"Bye\" at 18:00:02 in the second sentence, got %+v", words[11])
+	}
+	if _, ok := words[12]; ok {
+		t.Errorf("Expected no words for the rewritten sentence, got %+v", words[12])
+	}
+}